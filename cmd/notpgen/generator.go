@@ -0,0 +1,265 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+const (
+	// generatedHeader is the header marking the generated files.
+	generatedHeader = "// Code generated by notpgen. DO NOT EDIT.\n\n"
+	// packetsImport is the import of the packets package used by the generated code.
+	packetsImport = `notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"`
+	// nullByte is the null byte passed to the serializers by the generated code.
+	nullByte = "notppackets.PacketNullByte"
+)
+
+// serializerNames maps the primitive types of the schema to the suffix of their serializers.
+var serializerNames = map[string]string{
+	"bool":    "Bool",
+	"int8":    "Int8",
+	"int16":   "Int16",
+	"int32":   "Int32",
+	"int64":   "Int64",
+	"uint16":  "Uint16",
+	"uint32":  "Uint32",
+	"uint64":  "Uint64",
+	"float32": "Float32",
+	"float64": "Float64",
+	"string":  "String",
+	"bytes":   "Bytes",
+	"time":    "Time",
+	"uuid":    "UUID",
+}
+
+// sampleValues maps the primitive types of the schema to the sample values used by the generated tests.
+var sampleValues = map[string]string{
+	"bool":    "true",
+	"int8":    "-8",
+	"int16":   "-16",
+	"int32":   "-32",
+	"int64":   "-64",
+	"uint16":  "0xFFFF",
+	"uint32":  "0xFFFFFFFF",
+	"uint64":  "0xFFFFFFFFFFFFFFFF",
+	"float32": "1.5",
+	"float64": "-2.25",
+	"string":  `"sample"`,
+	"bytes":   "[]byte{0x00, 0xFF}",
+	"time":    "time.Unix(1700000000, 123456789).UTC()",
+	"uuid":    "[16]byte{0xFF, 0x01}",
+}
+
+// generator writes the Go source of the schema.
+type generator struct {
+	schema *Schema
+	buf    strings.Builder
+}
+
+// printf writes the formatted text to the source.
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// format returns the formatted source.
+func (g *generator) format() ([]byte, error) {
+	source, err := format.Source([]byte(g.buf.String()))
+	if err != nil {
+		return nil, fmt.Errorf("notpgen: failed to format the generated source: %w", err)
+	}
+	return source, nil
+}
+
+// isMessage returns true if the type of the schema is a message.
+func (g *generator) isMessage(schemaType string) bool {
+	return g.schema.GetMessage(schemaType) != nil
+}
+
+// getGoType returns the Go type of the field.
+func (g *generator) getGoType(field Field) string {
+	elemType := field.GetElemType()
+	goType, ok := primitiveTypes[elemType]
+	if !ok {
+		goType = "*" + elemType
+	}
+	if field.IsSlice() {
+		return "[]" + goType
+	}
+	return goType
+}
+
+// hasMessageFields returns true if the message has fields holding other messages.
+func (g *generator) hasMessageFields(message Message) bool {
+	for _, field := range message.Fields {
+		if g.isMessage(field.GetElemType()) {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateTypes generates the Go types of the messages of the schema.
+func GenerateTypes(schema *Schema) ([]byte, error) {
+	g := &generator{schema: schema}
+	g.printf("%spackage %s\n\n", generatedHeader, schema.Package)
+	g.printf("import (\n")
+	for _, message := range schema.Messages {
+		if g.hasMessageFields(message) {
+			g.printf("\"errors\"\n")
+			break
+		}
+	}
+	if schema.UsesTime() {
+		g.printf("\"time\"\n")
+	}
+	g.printf("\n%s\n)\n", packetsImport)
+	for _, message := range schema.Messages {
+		g.generateType(message)
+	}
+	return g.format()
+}
+
+// generateType generates the Go type of the message.
+func (g *generator) generateType(message Message) {
+	name := message.Name
+	g.printf("\n// %s represents the %s packet.\n", name, name)
+	g.printf("type %s struct {\n", name)
+	for _, field := range message.Fields {
+		g.printf("%s %s\n", field.Name, g.getGoType(field))
+	}
+	g.printf("}\n")
+
+	g.printf("\n// GetType returns the packet type.\n")
+	g.printf("func (p *%s) GetType() uint64 {\n", name)
+	g.printf("return notppackets.CombineUint32toUint64(%d, %d)\n}\n", message.Type.High, message.Type.Low)
+
+	g.printf("\n// Serialize serializes the packet.\n")
+	g.printf("func (p *%s) Serialize() ([]byte, error) {\n", name)
+	g.printf("data := make([]byte, 0)\n")
+	if g.hasMessageFields(message) {
+		g.printf("var err error\n")
+	}
+	for _, field := range message.Fields {
+		g.generateSerializeField(field)
+	}
+	g.printf("return data, nil\n}\n")
+
+	g.printf("\n// Deserialize deserializes the packet.\n")
+	g.printf("func (p *%s) Deserialize(data []byte) error {\n", name)
+	if len(message.Fields) > 0 {
+		g.printf("var err error\n")
+	}
+	for _, field := range message.Fields {
+		g.generateDeserializeField(field)
+	}
+	g.printf("return nil\n}\n")
+}
+
+// generateSerializeField generates the serialization of the field.
+func (g *generator) generateSerializeField(field Field) {
+	elemType := field.GetElemType()
+	switch {
+	case !g.isMessage(elemType) && !field.IsSlice():
+		g.printf("data = notppackets.Serialize%s(data, p.%s, %s)\n", serializerNames[elemType], field.Name, nullByte)
+	case !g.isMessage(elemType):
+		g.printf("data = notppackets.SerializeSlice(data, p.%s, %s, notppackets.Serialize%s)\n", field.Name, nullByte, serializerNames[elemType])
+	case !field.IsSlice():
+		g.printf("if p.%s == nil {\nreturn nil, errors.New(\"notp: nil field %s\")\n}\n", field.Name, field.Name)
+		g.printf("data, err = notppackets.SerializePacketable(data, p.%s, %s)\n", field.Name, nullByte)
+		g.printf("if err != nil {\nreturn nil, err\n}\n")
+	default:
+		g.printf("data = notppackets.SerializeUint32(data, uint32(len(p.%s)), %s)\n", field.Name, nullByte)
+		g.printf("for _, value := range p.%s {\n", field.Name)
+		g.printf("if value == nil {\nreturn nil, errors.New(\"notp: nil value of field %s\")\n}\n", field.Name)
+		g.printf("data, err = notppackets.SerializePacketable(data, value, %s)\n", nullByte)
+		g.printf("if err != nil {\nreturn nil, err\n}\n}\n")
+	}
+}
+
+// generateDeserializeField generates the deserialization of the field.
+func (g *generator) generateDeserializeField(field Field) {
+	elemType := field.GetElemType()
+	switch {
+	case !g.isMessage(elemType) && !field.IsSlice():
+		g.printf("p.%s, data, err = notppackets.Deserialize%s(data, %s)\n", field.Name, serializerNames[elemType], nullByte)
+	case !g.isMessage(elemType):
+		g.printf("p.%s, data, err = notppackets.DeserializeSlice(data, %s, notppackets.Deserialize%s)\n", field.Name, nullByte, serializerNames[elemType])
+	case !field.IsSlice():
+		g.printf("p.%s = &%s{}\n", field.Name, elemType)
+		g.printf("data, err = notppackets.DeserializePacketable(data, p.%s, %s)\n", field.Name, nullByte)
+	default:
+		g.printf("p.%s, data, err = notppackets.DeserializeSlice(data, %s, func(data []byte, nullByte byte) (*%s, []byte, error) {\n", field.Name, nullByte, elemType)
+		g.printf("value := &%s{}\n", elemType)
+		g.printf("data, err := notppackets.DeserializePacketable(data, value, nullByte)\n")
+		g.printf("return value, data, err\n})\n")
+	}
+	g.printf("if err != nil {\nreturn err\n}\n")
+}
+
+// GenerateTests generates the round trip tests of the messages of the schema.
+func GenerateTests(schema *Schema) ([]byte, error) {
+	g := &generator{schema: schema}
+	g.printf("%spackage %s\n\n", generatedHeader, schema.Package)
+	g.printf("import (\n\"testing\"\n")
+	if schema.UsesTime() {
+		g.printf("\"time\"\n")
+	}
+	g.printf("\n\"github.com/stretchr/testify/assert\"\n)\n")
+	for _, message := range schema.Messages {
+		g.generateTest(message)
+	}
+	return g.format()
+}
+
+// getSampleValue returns the Go expression of a sample value of the type of the schema.
+func (g *generator) getSampleValue(schemaType string) string {
+	if g.isMessage(schemaType) {
+		return fmt.Sprintf("newSample%s()", schemaType)
+	}
+	return sampleValues[schemaType]
+}
+
+// generateTest generates the round trip test of the message.
+func (g *generator) generateTest(message Message) {
+	name := message.Name
+	g.printf("\n// newSample%s creates a %s packet with sample values.\n", name, name)
+	g.printf("func newSample%s() *%s {\n", name, name)
+	g.printf("return &%s{\n", name)
+	for _, field := range message.Fields {
+		value := g.getSampleValue(field.GetElemType())
+		if field.IsSlice() {
+			value = fmt.Sprintf("%s{%s}", g.getGoType(field), value)
+		}
+		g.printf("%s: %s,\n", field.Name, value)
+	}
+	g.printf("}\n}\n")
+
+	g.printf("\n// Test%s tests the serialization round trip of the %s packet.\n", name, name)
+	g.printf("func Test%s(t *testing.T) {\n", name)
+	g.printf("assert := assert.New(t)\n\n")
+	g.printf("inPacket := newSample%s()\n", name)
+	g.printf("data, err := inPacket.Serialize()\n")
+	g.printf("assert.Nil(err)\n\n")
+	g.printf("outPacket := &%s{}\n", name)
+	g.printf("err = outPacket.Deserialize(data)\n")
+	g.printf("assert.Nil(err)\n")
+	g.printf("assert.Equal(inPacket, outPacket)\n")
+	g.printf("assert.Equal(inPacket.GetType(), outPacket.GetType())\n}\n")
+}
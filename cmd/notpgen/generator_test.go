@@ -0,0 +1,122 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/permguard/permguard-notp-protocol/cmd/notpgen/internal/samplepackets"
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// TestGenerateSamplePackets tests that the generated sample packets are up to date with the generator.
+func TestGenerateSamplePackets(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := filepath.Join("internal", "samplepackets")
+	data, err := os.ReadFile(filepath.Join(dir, "schema.json"))
+	assert.Nil(err)
+	schema, err := ParseSchema(data)
+	assert.Nil(err)
+
+	source, err := GenerateTypes(schema)
+	assert.Nil(err)
+	expected, err := os.ReadFile(filepath.Join(dir, "packets_gen.go"))
+	assert.Nil(err)
+	assert.Equal(string(expected), string(source))
+
+	source, err = GenerateTests(schema)
+	assert.Nil(err)
+	expected, err = os.ReadFile(filepath.Join(dir, "packets_gen_test.go"))
+	assert.Nil(err)
+	assert.Equal(string(expected), string(source))
+}
+
+// TestGeneratedPacketsWithCodec tests that the generated packets serialize the same bytes as the codec.
+func TestGeneratedPacketsWithCodec(t *testing.T) {
+	assert := assert.New(t)
+
+	address := &samplepackets.AddressPacket{Street: "Main Street", Number: 0xFFFF, Coordinates: []float32{45.5, 9.25}}
+	expected, err := address.Serialize()
+	assert.Nil(err)
+	data, err := notppackets.Marshal(&struct {
+		Street      string    `notp:"1"`
+		Number      uint16    `notp:"2"`
+		Coordinates []float32 `notp:"3"`
+	}{Street: "Main Street", Number: 0xFFFF, Coordinates: []float32{45.5, 9.25}})
+	assert.Nil(err)
+	assert.Equal(expected, data)
+
+	_, err = (&samplepackets.UserPacket{}).Serialize()
+	assert.NotNil(err)
+}
+
+// TestParseSchemaWithInvalidSchemas tests that the invalid schemas are rejected.
+func TestParseSchemaWithInvalidSchemas(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{
+			name:   "TypeCollision",
+			schema: `{"package": "p", "messages": [{"name": "A", "type": {"high": 100, "low": 1}}, {"name": "B", "type": {"high": 100, "low": 1}}]}`,
+		},
+		{
+			name:   "ReservedTypeCollision",
+			schema: `{"package": "p", "messages": [{"name": "A", "type": {"high": 10, "low": 0}}]}`,
+		},
+		{
+			name:   "DuplicatedMessage",
+			schema: `{"package": "p", "messages": [{"name": "A", "type": {"high": 100, "low": 1}}, {"name": "A", "type": {"high": 100, "low": 2}}]}`,
+		},
+		{
+			name:   "DuplicatedField",
+			schema: `{"package": "p", "messages": [{"name": "A", "type": {"high": 100}, "fields": [{"name": "F", "type": "bool"}, {"name": "F", "type": "bool"}]}]}`,
+		},
+		{
+			name:   "UnknownType",
+			schema: `{"package": "p", "messages": [{"name": "A", "type": {"high": 100}, "fields": [{"name": "F", "type": "map"}]}]}`,
+		},
+		{
+			name:   "RecursiveMessage",
+			schema: `{"package": "p", "messages": [{"name": "A", "type": {"high": 100}, "fields": [{"name": "F", "type": "[]B"}]}, {"name": "B", "type": {"high": 101}, "fields": [{"name": "F", "type": "A"}]}]}`,
+		},
+		{
+			name:   "InvalidPackage",
+			schema: `{"package": "my-packets", "messages": [{"name": "A", "type": {"high": 100}}]}`,
+		},
+		{
+			name:   "UnexportedField",
+			schema: `{"package": "p", "messages": [{"name": "A", "type": {"high": 100}, "fields": [{"name": "f", "type": "bool"}]}]}`,
+		},
+		{
+			name:   "UnknownProperty",
+			schema: `{"package": "p", "messages": [{"name": "A", "type": {"high": 100}, "ids": []}]}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			_, err := ParseSchema([]byte(test.schema))
+			assert.NotNil(err)
+		})
+	}
+}
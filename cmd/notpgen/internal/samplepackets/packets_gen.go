@@ -0,0 +1,228 @@
+// Code generated by notpgen. DO NOT EDIT.
+
+package samplepackets
+
+import (
+	"errors"
+	"time"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// UserPacket represents the UserPacket packet.
+type UserPacket struct {
+	ID        [16]byte
+	Name      string
+	Active    bool
+	Age       int8
+	Score     float64
+	CreatedAt time.Time
+	Avatar    []byte
+	Roles     []string
+	Address   *AddressPacket
+	Contacts  []*ContactPacket
+}
+
+// GetType returns the packet type.
+func (p *UserPacket) GetType() uint64 {
+	return notppackets.CombineUint32toUint64(100, 1)
+}
+
+// Serialize serializes the packet.
+func (p *UserPacket) Serialize() ([]byte, error) {
+	data := make([]byte, 0)
+	var err error
+	data = notppackets.SerializeUUID(data, p.ID, notppackets.PacketNullByte)
+	data = notppackets.SerializeString(data, p.Name, notppackets.PacketNullByte)
+	data = notppackets.SerializeBool(data, p.Active, notppackets.PacketNullByte)
+	data = notppackets.SerializeInt8(data, p.Age, notppackets.PacketNullByte)
+	data = notppackets.SerializeFloat64(data, p.Score, notppackets.PacketNullByte)
+	data = notppackets.SerializeTime(data, p.CreatedAt, notppackets.PacketNullByte)
+	data = notppackets.SerializeBytes(data, p.Avatar, notppackets.PacketNullByte)
+	data = notppackets.SerializeSlice(data, p.Roles, notppackets.PacketNullByte, notppackets.SerializeString)
+	if p.Address == nil {
+		return nil, errors.New("notp: nil field Address")
+	}
+	data, err = notppackets.SerializePacketable(data, p.Address, notppackets.PacketNullByte)
+	if err != nil {
+		return nil, err
+	}
+	data = notppackets.SerializeUint32(data, uint32(len(p.Contacts)), notppackets.PacketNullByte)
+	for _, value := range p.Contacts {
+		if value == nil {
+			return nil, errors.New("notp: nil value of field Contacts")
+		}
+		data, err = notppackets.SerializePacketable(data, value, notppackets.PacketNullByte)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// Deserialize deserializes the packet.
+func (p *UserPacket) Deserialize(data []byte) error {
+	var err error
+	p.ID, data, err = notppackets.DeserializeUUID(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Name, data, err = notppackets.DeserializeString(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Active, data, err = notppackets.DeserializeBool(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Age, data, err = notppackets.DeserializeInt8(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Score, data, err = notppackets.DeserializeFloat64(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.CreatedAt, data, err = notppackets.DeserializeTime(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Avatar, data, err = notppackets.DeserializeBytes(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Roles, data, err = notppackets.DeserializeSlice(data, notppackets.PacketNullByte, notppackets.DeserializeString)
+	if err != nil {
+		return err
+	}
+	p.Address = &AddressPacket{}
+	data, err = notppackets.DeserializePacketable(data, p.Address, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Contacts, data, err = notppackets.DeserializeSlice(data, notppackets.PacketNullByte, func(data []byte, nullByte byte) (*ContactPacket, []byte, error) {
+		value := &ContactPacket{}
+		data, err := notppackets.DeserializePacketable(data, value, nullByte)
+		return value, data, err
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AddressPacket represents the AddressPacket packet.
+type AddressPacket struct {
+	Street      string
+	Number      uint16
+	Coordinates []float32
+}
+
+// GetType returns the packet type.
+func (p *AddressPacket) GetType() uint64 {
+	return notppackets.CombineUint32toUint64(100, 2)
+}
+
+// Serialize serializes the packet.
+func (p *AddressPacket) Serialize() ([]byte, error) {
+	data := make([]byte, 0)
+	data = notppackets.SerializeString(data, p.Street, notppackets.PacketNullByte)
+	data = notppackets.SerializeUint16(data, p.Number, notppackets.PacketNullByte)
+	data = notppackets.SerializeSlice(data, p.Coordinates, notppackets.PacketNullByte, notppackets.SerializeFloat32)
+	return data, nil
+}
+
+// Deserialize deserializes the packet.
+func (p *AddressPacket) Deserialize(data []byte) error {
+	var err error
+	p.Street, data, err = notppackets.DeserializeString(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Number, data, err = notppackets.DeserializeUint16(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Coordinates, data, err = notppackets.DeserializeSlice(data, notppackets.PacketNullByte, notppackets.DeserializeFloat32)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ContactPacket represents the ContactPacket packet.
+type ContactPacket struct {
+	Kind     int16
+	Value    string
+	Priority uint32
+	Counter  int64
+	Revision uint64
+	Offset   int32
+}
+
+// GetType returns the packet type.
+func (p *ContactPacket) GetType() uint64 {
+	return notppackets.CombineUint32toUint64(100, 3)
+}
+
+// Serialize serializes the packet.
+func (p *ContactPacket) Serialize() ([]byte, error) {
+	data := make([]byte, 0)
+	data = notppackets.SerializeInt16(data, p.Kind, notppackets.PacketNullByte)
+	data = notppackets.SerializeString(data, p.Value, notppackets.PacketNullByte)
+	data = notppackets.SerializeUint32(data, p.Priority, notppackets.PacketNullByte)
+	data = notppackets.SerializeInt64(data, p.Counter, notppackets.PacketNullByte)
+	data = notppackets.SerializeUint64(data, p.Revision, notppackets.PacketNullByte)
+	data = notppackets.SerializeInt32(data, p.Offset, notppackets.PacketNullByte)
+	return data, nil
+}
+
+// Deserialize deserializes the packet.
+func (p *ContactPacket) Deserialize(data []byte) error {
+	var err error
+	p.Kind, data, err = notppackets.DeserializeInt16(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Value, data, err = notppackets.DeserializeString(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Priority, data, err = notppackets.DeserializeUint32(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Counter, data, err = notppackets.DeserializeInt64(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Revision, data, err = notppackets.DeserializeUint64(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.Offset, data, err = notppackets.DeserializeInt32(data, notppackets.PacketNullByte)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// EmptyPacket represents the EmptyPacket packet.
+type EmptyPacket struct {
+}
+
+// GetType returns the packet type.
+func (p *EmptyPacket) GetType() uint64 {
+	return notppackets.CombineUint32toUint64(100, 4)
+}
+
+// Serialize serializes the packet.
+func (p *EmptyPacket) Serialize() ([]byte, error) {
+	data := make([]byte, 0)
+	return data, nil
+}
+
+// Deserialize deserializes the packet.
+func (p *EmptyPacket) Deserialize(data []byte) error {
+	return nil
+}
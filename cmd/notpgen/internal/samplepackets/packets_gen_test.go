@@ -0,0 +1,112 @@
+// Code generated by notpgen. DO NOT EDIT.
+
+package samplepackets
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newSampleUserPacket creates a UserPacket packet with sample values.
+func newSampleUserPacket() *UserPacket {
+	return &UserPacket{
+		ID:        [16]byte{0xFF, 0x01},
+		Name:      "sample",
+		Active:    true,
+		Age:       -8,
+		Score:     -2.25,
+		CreatedAt: time.Unix(1700000000, 123456789).UTC(),
+		Avatar:    []byte{0x00, 0xFF},
+		Roles:     []string{"sample"},
+		Address:   newSampleAddressPacket(),
+		Contacts:  []*ContactPacket{newSampleContactPacket()},
+	}
+}
+
+// TestUserPacket tests the serialization round trip of the UserPacket packet.
+func TestUserPacket(t *testing.T) {
+	assert := assert.New(t)
+
+	inPacket := newSampleUserPacket()
+	data, err := inPacket.Serialize()
+	assert.Nil(err)
+
+	outPacket := &UserPacket{}
+	err = outPacket.Deserialize(data)
+	assert.Nil(err)
+	assert.Equal(inPacket, outPacket)
+	assert.Equal(inPacket.GetType(), outPacket.GetType())
+}
+
+// newSampleAddressPacket creates a AddressPacket packet with sample values.
+func newSampleAddressPacket() *AddressPacket {
+	return &AddressPacket{
+		Street:      "sample",
+		Number:      0xFFFF,
+		Coordinates: []float32{1.5},
+	}
+}
+
+// TestAddressPacket tests the serialization round trip of the AddressPacket packet.
+func TestAddressPacket(t *testing.T) {
+	assert := assert.New(t)
+
+	inPacket := newSampleAddressPacket()
+	data, err := inPacket.Serialize()
+	assert.Nil(err)
+
+	outPacket := &AddressPacket{}
+	err = outPacket.Deserialize(data)
+	assert.Nil(err)
+	assert.Equal(inPacket, outPacket)
+	assert.Equal(inPacket.GetType(), outPacket.GetType())
+}
+
+// newSampleContactPacket creates a ContactPacket packet with sample values.
+func newSampleContactPacket() *ContactPacket {
+	return &ContactPacket{
+		Kind:     -16,
+		Value:    "sample",
+		Priority: 0xFFFFFFFF,
+		Counter:  -64,
+		Revision: 0xFFFFFFFFFFFFFFFF,
+		Offset:   -32,
+	}
+}
+
+// TestContactPacket tests the serialization round trip of the ContactPacket packet.
+func TestContactPacket(t *testing.T) {
+	assert := assert.New(t)
+
+	inPacket := newSampleContactPacket()
+	data, err := inPacket.Serialize()
+	assert.Nil(err)
+
+	outPacket := &ContactPacket{}
+	err = outPacket.Deserialize(data)
+	assert.Nil(err)
+	assert.Equal(inPacket, outPacket)
+	assert.Equal(inPacket.GetType(), outPacket.GetType())
+}
+
+// newSampleEmptyPacket creates a EmptyPacket packet with sample values.
+func newSampleEmptyPacket() *EmptyPacket {
+	return &EmptyPacket{}
+}
+
+// TestEmptyPacket tests the serialization round trip of the EmptyPacket packet.
+func TestEmptyPacket(t *testing.T) {
+	assert := assert.New(t)
+
+	inPacket := newSampleEmptyPacket()
+	data, err := inPacket.Serialize()
+	assert.Nil(err)
+
+	outPacket := &EmptyPacket{}
+	err = outPacket.Deserialize(data)
+	assert.Nil(err)
+	assert.Equal(inPacket, outPacket)
+	assert.Equal(inPacket.GetType(), outPacket.GetType())
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Command notpgen generates the Go types implementing the packetable interface from a schema.
+//
+// The schema is a JSON document listing the messages with their packet type and their fields in serialization order:
+//
+//	{
+//		"package": "mypackets",
+//		"messages": [
+//			{
+//				"name": "UserPacket",
+//				"type": {"high": 100, "low": 1},
+//				"fields": [
+//					{"name": "ID", "type": "uint64"},
+//					{"name": "Tags", "type": "[]string"}
+//				]
+//			}
+//		]
+//	}
+//
+// The supported types are bool, int8, int16, int32, int64, uint16, uint32, uint64, float32, float64, string,
+// bytes, time, uuid and the names of the messages of the schema, optionally prefixed by [] for repeated values.
+//
+// It is meant to be invoked by go generate:
+//
+//	//go:generate go run github.com/permguard/permguard-notp-protocol/cmd/notpgen -schema packets.json -out packets_gen.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	schemaPath := flag.String("schema", "", "path of the schema file")
+	outPath := flag.String("out", "", "path of the generated Go file")
+	withTests := flag.Bool("tests", true, "generate the round trip tests next to the generated Go file")
+	flag.Parse()
+	if err := run(*schemaPath, *outPath, *withTests); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run generates the Go types, and optionally their tests, of the schema.
+func run(schemaPath string, outPath string, withTests bool) error {
+	if schemaPath == "" || outPath == "" {
+		return fmt.Errorf("notpgen: both -schema and -out are required")
+	}
+	if !strings.HasSuffix(outPath, ".go") || strings.HasSuffix(outPath, "_test.go") {
+		return fmt.Errorf("notpgen: invalid output file %s", outPath)
+	}
+	data, err := os.ReadFile(schemaPath)
+	if err != nil {
+		return fmt.Errorf("notpgen: failed to read the schema: %w", err)
+	}
+	schema, err := ParseSchema(data)
+	if err != nil {
+		return err
+	}
+	source, err := GenerateTypes(schema)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(outPath, source, 0o644); err != nil {
+		return fmt.Errorf("notpgen: failed to write the generated types: %w", err)
+	}
+	if !withTests {
+		return nil
+	}
+	source, err = GenerateTests(schema)
+	if err != nil {
+		return err
+	}
+	testPath := strings.TrimSuffix(outPath, ".go") + "_test.go"
+	if err = os.WriteFile(testPath, source, 0o644); err != nil {
+		return fmt.Errorf("notpgen: failed to write the generated tests: %w", err)
+	}
+	return nil
+}
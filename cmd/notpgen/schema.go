@@ -0,0 +1,200 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"strings"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+	notpsmpackets "github.com/permguard/permguard-notp-protocol/pkg/notp/statemachines/packets"
+)
+
+// primitiveTypes maps the primitive types of the schema to their Go types.
+var primitiveTypes = map[string]string{
+	"bool":    "bool",
+	"int8":    "int8",
+	"int16":   "int16",
+	"int32":   "int32",
+	"int64":   "int64",
+	"uint16":  "uint16",
+	"uint32":  "uint32",
+	"uint64":  "uint64",
+	"float32": "float32",
+	"float64": "float64",
+	"string":  "string",
+	"bytes":   "[]byte",
+	"time":    "time.Time",
+	"uuid":    "[16]byte",
+}
+
+// reservedTypes maps the packet types already defined by the protocol to their names.
+var reservedTypes = map[uint64]string{
+	notppackets.CombineUint32toUint64(notppackets.PacketType, 0):         "Packet",
+	notppackets.CombineUint32toUint64(notppackets.ProtocolPacketType, 0): "ProtocolPacket",
+	notppackets.CombineUint32toUint64(notpsmpackets.StatePacketType, 0):  "StatePacket",
+}
+
+// Schema represents the schema of the packets to generate.
+type Schema struct {
+	Package  string    `json:"package"`
+	Messages []Message `json:"messages"`
+}
+
+// Message represents a packet of the schema.
+type Message struct {
+	Name   string  `json:"name"`
+	Type   TypeID  `json:"type"`
+	Fields []Field `json:"fields"`
+}
+
+// TypeID represents the packet type combined from its high and low parts.
+type TypeID struct {
+	High uint32 `json:"high"`
+	Low  uint32 `json:"low"`
+}
+
+// GetType returns the packet type.
+func (t TypeID) GetType() uint64 {
+	return notppackets.CombineUint32toUint64(t.High, t.Low)
+}
+
+// Field represents a field of a packet in serialization order.
+type Field struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// IsSlice returns true if the field holds repeated values.
+func (f Field) IsSlice() bool {
+	return strings.HasPrefix(f.Type, "[]")
+}
+
+// GetElemType returns the type of the values of the field.
+func (f Field) GetElemType() string {
+	return strings.TrimPrefix(f.Type, "[]")
+}
+
+// ParseSchema parses and validates a schema.
+func ParseSchema(data []byte) (*Schema, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	schema := &Schema{}
+	if err := decoder.Decode(schema); err != nil {
+		return nil, fmt.Errorf("notpgen: invalid schema: %w", err)
+	}
+	if err := schema.Validate(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// Validate validates the names, the types and the packet types of the schema.
+func (s *Schema) Validate() error {
+	if !token.IsIdentifier(s.Package) {
+		return fmt.Errorf("notpgen: invalid package name %q", s.Package)
+	}
+	if len(s.Messages) == 0 {
+		return fmt.Errorf("notpgen: the schema does not define any message")
+	}
+	messages := map[string]*Message{}
+	types := map[uint64]string{}
+	for i := range s.Messages {
+		message := &s.Messages[i]
+		if !token.IsIdentifier(message.Name) || !token.IsExported(message.Name) {
+			return fmt.Errorf("notpgen: invalid message name %q", message.Name)
+		}
+		if _, ok := messages[message.Name]; ok {
+			return fmt.Errorf("notpgen: duplicated message %s", message.Name)
+		}
+		messages[message.Name] = message
+		packetType := message.Type.GetType()
+		if name, ok := reservedTypes[packetType]; ok {
+			return fmt.Errorf("notpgen: type %d-%d of message %s collides with the protocol packet %s", message.Type.High, message.Type.Low, message.Name, name)
+		}
+		if name, ok := types[packetType]; ok {
+			return fmt.Errorf("notpgen: type %d-%d of message %s collides with message %s", message.Type.High, message.Type.Low, message.Name, name)
+		}
+		types[packetType] = message.Name
+	}
+	for _, message := range s.Messages {
+		fields := map[string]bool{}
+		for _, field := range message.Fields {
+			if !token.IsIdentifier(field.Name) || !token.IsExported(field.Name) {
+				return fmt.Errorf("notpgen: invalid field name %s.%q", message.Name, field.Name)
+			}
+			if fields[field.Name] {
+				return fmt.Errorf("notpgen: duplicated field %s.%s", message.Name, field.Name)
+			}
+			fields[field.Name] = true
+			elemType := field.GetElemType()
+			if _, ok := primitiveTypes[elemType]; !ok && messages[elemType] == nil {
+				return fmt.Errorf("notpgen: unknown type %q of field %s.%s", field.Type, message.Name, field.Name)
+			}
+		}
+		if err := s.checkRecursion(message.Name, message.Name, map[string]bool{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRecursion checks that the message does not contain itself through its fields.
+func (s *Schema) checkRecursion(root string, name string, visited map[string]bool) error {
+	if visited[name] {
+		return nil
+	}
+	visited[name] = true
+	message := s.GetMessage(name)
+	for _, field := range message.Fields {
+		elemType := field.GetElemType()
+		if elemType == root {
+			return fmt.Errorf("notpgen: message %s contains itself through field %s.%s", root, message.Name, field.Name)
+		}
+		if s.GetMessage(elemType) != nil {
+			if err := s.checkRecursion(root, elemType, visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetMessage returns the message with the input name, or nil if it is not defined.
+func (s *Schema) GetMessage(name string) *Message {
+	for i := range s.Messages {
+		if s.Messages[i].Name == name {
+			return &s.Messages[i]
+		}
+	}
+	return nil
+}
+
+// UsesTime returns true if any field of the schema holds times.
+func (s *Schema) UsesTime() bool {
+	for _, message := range s.Messages {
+		for _, field := range message.Fields {
+			if field.GetElemType() == "time" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	// checksumSize is the size of a checksum.
+	checksumSize = 4
+)
+
+// checksumTable is the CRC32C table used to compute the checksums.
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// computeChecksum computes the CRC32C checksum of the data.
+func computeChecksum(data []byte) uint32 {
+	return crc32.Checksum(data, checksumTable)
+}
+
+// appendChecksum appends the checksum of the data starting at the offset.
+func appendChecksum(data []byte, offset int) []byte {
+	return binary.BigEndian.AppendUint32(data, computeChecksum(data[offset:]))
+}
+
+// verifyChecksum verifies the checksum against the checksummed data.
+func verifyChecksum(checksummed []byte, checksum []byte) error {
+	if len(checksum) != checksumSize {
+		return fmt.Errorf("%w: missing checksum", ErrTruncated)
+	}
+	expected := binary.BigEndian.Uint32(checksum)
+	if actual := computeChecksum(checksummed); actual != expected {
+		return fmt.Errorf("%w: expected %08x, computed %08x", ErrChecksumMismatch, expected, actual)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeChecksummedPacket writes a packet with the input checksum flags using both the buffered and the streaming writers.
+func writeChecksummedPacket(assert *assert.Assertions, flags uint32, payloads ...[]byte) []byte {
+	protocol := &ProtocolPacket{Version: ProtocolVersion2, MinVersion: ProtocolVersion1, MaxVersion: ProtocolVersion2, Flags: flags}
+
+	packet := &Packet{}
+	writer, err := NewPacketWriter(packet)
+	assert.Nil(err)
+	assert.Nil(writer.WriteProtocol(protocol))
+
+	var buffer bytes.Buffer
+	streamWriter, err := NewPacketStreamWriter(&buffer)
+	assert.Nil(err)
+	assert.Nil(streamWriter.WriteProtocol(protocol))
+	assert.Nil(streamWriter.BeginDataStream(uint64(len(payloads))))
+
+	for _, payload := range payloads {
+		assert.Nil(writer.AppendDataPacket(&Packet{Data: payload}))
+		assert.Nil(streamWriter.WriteDataPacket(&Packet{Data: payload}))
+	}
+	assert.Equal(packet.Data, buffer.Bytes())
+	return packet.Data
+}
+
+// TestChecksums tests the data and packet checksums with both the buffered and the streaming readers.
+func TestChecksums(t *testing.T) {
+	payloads := [][]byte{[]byte("sample data"), {0xFF, 0x00, 0xFF}, []byte("more sample data")}
+	for _, flags := range []uint32{DataChecksumFlag, PacketChecksumFlag, DataChecksumFlag | PacketChecksumFlag} {
+		t.Run(fmt.Sprintf("Flags%d", flags), func(t *testing.T) {
+			assert := assert.New(t)
+			data := writeChecksummedPacket(assert, flags, payloads...)
+
+			reader, err := NewPacketReader(&Packet{Data: data})
+			assert.Nil(err)
+			outPayloads, _, err := readAllDataPackets(reader.ReadNextDataPacket)
+			assert.Nil(err)
+			assert.Equal(payloads, outPayloads)
+
+			streamReader, err := NewPacketStreamReader(bytes.NewReader(data))
+			assert.Nil(err)
+			outPayloads, _, err = readAllDataPackets(streamReader.ReadNextDataPacket)
+			assert.Nil(err)
+			assert.Equal(payloads, outPayloads)
+
+			corrupted := bytes.Clone(data)
+			index := bytes.Index(corrupted, []byte("more sample data"))
+			corrupted[index] ^= 0x01
+
+			reader, err = NewPacketReader(&Packet{Data: corrupted})
+			assert.Nil(err)
+			_, _, err = readAllDataPackets(reader.ReadNextDataPacket)
+			assert.ErrorIs(err, ErrChecksumMismatch)
+
+			streamReader, err = NewPacketStreamReader(bytes.NewReader(corrupted))
+			assert.Nil(err)
+			_, _, err = readAllDataPackets(streamReader.ReadNextDataPacket)
+			assert.ErrorIs(err, ErrChecksumMismatch)
+		})
+	}
+}
+
+// TestChecksumsWithLegacyWireFormat tests that checksums are rejected with the legacy wire format.
+func TestChecksumsWithLegacyWireFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	writer, err := NewPacketWriter(&Packet{})
+	assert.Nil(err)
+	err = writer.WriteProtocol(&ProtocolPacket{Version: ProtocolVersion1, Flags: DataChecksumFlag})
+	assert.ErrorIs(err, ErrBadHeader)
+}
@@ -0,0 +1,520 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CodecTagName is the name of the struct tag holding the position of a field in the serialized packet.
+//
+// A struct implements Packetable by delegating to the codec:
+//
+//	type SamplePacket struct {
+//		ID   uint64 `notp:"1"`
+//		Text string `notp:"2"`
+//	}
+//
+//	func (p *SamplePacket) Serialize() ([]byte, error) { return Marshal(p) }
+//
+//	func (p *SamplePacket) Deserialize(data []byte) error { return Unmarshal(data, p) }
+const CodecTagName = "notp"
+
+var (
+	// timeType is the reflected type of the time.
+	timeType = reflect.TypeFor[time.Time]()
+	// packetableType is the reflected type of the packetable interface.
+	packetableType = reflect.TypeFor[Packetable]()
+	// structCodecs caches the codecs of the struct types.
+	structCodecs sync.Map
+)
+
+// encodeFunc serializes the value into the buffer.
+type encodeFunc func(data []byte, value reflect.Value) ([]byte, error)
+
+// decodeFunc deserializes the buffer into the settable value.
+type decodeFunc func(data []byte, value reflect.Value) ([]byte, error)
+
+// fieldCodec is the codec of a tagged field of a struct.
+type fieldCodec struct {
+	name     string
+	index    int
+	position uint64
+	encode   encodeFunc
+	decode   decodeFunc
+}
+
+// structCodec is the codec of a struct made of the codecs of its tagged fields in serialization order.
+type structCodec struct {
+	fields []fieldCodec
+}
+
+// encode serializes the tagged fields of the struct into the buffer.
+func (c *structCodec) encode(data []byte, value reflect.Value) ([]byte, error) {
+	if data == nil {
+		data = make([]byte, 0)
+	}
+	var err error
+	for _, field := range c.fields {
+		data, err = field.encode(data, value.Field(field.index))
+		if err != nil {
+			return nil, fmt.Errorf("notp: failed to serialize field %s: %w", field.name, err)
+		}
+	}
+	return data, nil
+}
+
+// decode deserializes the buffer into the tagged fields of the struct.
+func (c *structCodec) decode(data []byte, value reflect.Value) ([]byte, error) {
+	var err error
+	for _, field := range c.fields {
+		data, err = field.decode(data, value.Field(field.index))
+		if err != nil {
+			return nil, fmt.Errorf("notp: failed to deserialize field %s: %w", field.name, err)
+		}
+	}
+	return data, nil
+}
+
+// Marshal serializes the tagged fields of a struct, or of a pointer to a struct, in the order of their positions.
+func Marshal(value any) ([]byte, error) {
+	reflected := reflect.ValueOf(value)
+	if reflected.Kind() == reflect.Pointer {
+		if reflected.IsNil() {
+			return nil, fmt.Errorf("notp: cannot marshal a nil %T", value)
+		}
+		reflected = reflected.Elem()
+	}
+	if reflected.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("notp: cannot marshal %T as it is not a struct", value)
+	}
+	codec, err := getStructCodec(reflected.Type())
+	if err != nil {
+		return nil, err
+	}
+	return codec.encode(nil, reflected)
+}
+
+// Unmarshal deserializes the data into the tagged fields of a pointer to a struct, ignoring the trailing data.
+func Unmarshal(data []byte, value any) error {
+	reflected := reflect.ValueOf(value)
+	if reflected.Kind() != reflect.Pointer || reflected.IsNil() || reflected.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("notp: cannot unmarshal into %T as it is not a pointer to a struct", value)
+	}
+	reflected = reflected.Elem()
+	codec, err := getStructCodec(reflected.Type())
+	if err != nil {
+		return err
+	}
+	_, err = codec.decode(data, reflected)
+	return err
+}
+
+// getStructCodec returns the codec of the struct type, building it on first use.
+func getStructCodec(structType reflect.Type) (*structCodec, error) {
+	if codec, ok := structCodecs.Load(structType); ok {
+		return codec.(*structCodec), nil
+	}
+	codec, err := buildStructCodec(structType)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := structCodecs.LoadOrStore(structType, codec)
+	return actual.(*structCodec), nil
+}
+
+// buildStructCodec builds the codec of the struct type from the tags of its fields.
+func buildStructCodec(structType reflect.Type) (*structCodec, error) {
+	codec := &structCodec{}
+	for i := range structType.NumField() {
+		field := structType.Field(i)
+		tag, ok := field.Tag.Lookup(CodecTagName)
+		if !ok || tag == "-" {
+			continue
+		}
+		if !field.IsExported() {
+			return nil, fmt.Errorf("notp: tagged field %s.%s is not exported", structType, field.Name)
+		}
+		position, err := strconv.ParseUint(tag, 10, 32)
+		if err != nil || position == 0 {
+			return nil, fmt.Errorf("notp: invalid tag %q of field %s.%s", tag, structType, field.Name)
+		}
+		if slices.ContainsFunc(codec.fields, func(f fieldCodec) bool { return f.position == position }) {
+			return nil, fmt.Errorf("notp: duplicated tag %q of field %s.%s", tag, structType, field.Name)
+		}
+		encode, decode, err := getValueCodec(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("notp: invalid field %s.%s: %w", structType, field.Name, err)
+		}
+		codec.fields = append(codec.fields, fieldCodec{
+			name:     field.Name,
+			index:    i,
+			position: position,
+			encode:   encode,
+			decode:   decode,
+		})
+	}
+	slices.SortFunc(codec.fields, func(a, b fieldCodec) int {
+		return cmp.Compare(a.position, b.position)
+	})
+	return codec, nil
+}
+
+// getValueCodec returns the functions serializing and deserializing the values of the type.
+func getValueCodec(valueType reflect.Type) (encodeFunc, decodeFunc, error) {
+	switch {
+	case valueType == timeType:
+		return encodeTime, decodeTime, nil
+	case valueType.Kind() != reflect.Pointer && reflect.PointerTo(valueType).Implements(packetableType):
+		return encodePacketable, decodePacketable, nil
+	}
+	switch valueType.Kind() {
+	case reflect.Bool:
+		return encodeBool, decodeBool, nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		return encodeInt, decodeInt, nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		return encodeUint, decodeUint, nil
+	case reflect.Float32, reflect.Float64:
+		return encodeFloat, decodeFloat, nil
+	case reflect.String:
+		return encodeString, decodeString, nil
+	case reflect.Array:
+		if valueType.Len() == 16 && valueType.Elem().Kind() == reflect.Uint8 {
+			return encodeUUID, decodeUUID, nil
+		}
+	case reflect.Slice:
+		if valueType.Elem().Kind() == reflect.Uint8 {
+			return encodeBytes, decodeBytes, nil
+		}
+		return getSliceCodec(valueType)
+	case reflect.Pointer:
+		return getPointerCodec(valueType)
+	case reflect.Struct:
+		return getNestedStructCodec(valueType)
+	}
+	return nil, nil, fmt.Errorf("unsupported type %s", valueType)
+}
+
+// encodeTime serializes a time value.
+func encodeTime(data []byte, value reflect.Value) ([]byte, error) {
+	return SerializeTime(data, value.Interface().(time.Time), PacketNullByte), nil
+}
+
+// decodeTime deserializes a time value.
+func decodeTime(data []byte, value reflect.Value) ([]byte, error) {
+	decoded, data, err := DeserializeTime(data, PacketNullByte)
+	if err != nil {
+		return nil, err
+	}
+	value.Set(reflect.ValueOf(decoded))
+	return data, nil
+}
+
+// encodePacketable serializes a value implementing the packetable interface.
+func encodePacketable(data []byte, value reflect.Value) ([]byte, error) {
+	pointer := reflect.New(value.Type())
+	pointer.Elem().Set(value)
+	return SerializePacketable(data, pointer.Interface().(Packetable), PacketNullByte)
+}
+
+// decodePacketable deserializes a value implementing the packetable interface.
+func decodePacketable(data []byte, value reflect.Value) ([]byte, error) {
+	return DeserializePacketable(data, value.Addr().Interface().(Packetable), PacketNullByte)
+}
+
+// encodeBool serializes a bool value.
+func encodeBool(data []byte, value reflect.Value) ([]byte, error) {
+	return SerializeBool(data, value.Bool(), PacketNullByte), nil
+}
+
+// decodeBool deserializes a bool value.
+func decodeBool(data []byte, value reflect.Value) ([]byte, error) {
+	decoded, data, err := DeserializeBool(data, PacketNullByte)
+	if err != nil {
+		return nil, err
+	}
+	value.SetBool(decoded)
+	return data, nil
+}
+
+// encodeInt serializes a signed integer value with the serializer of its size.
+func encodeInt(data []byte, value reflect.Value) ([]byte, error) {
+	switch value.Kind() {
+	case reflect.Int8:
+		return SerializeInt8(data, int8(value.Int()), PacketNullByte), nil
+	case reflect.Int16:
+		return SerializeInt16(data, int16(value.Int()), PacketNullByte), nil
+	case reflect.Int32:
+		return SerializeInt32(data, int32(value.Int()), PacketNullByte), nil
+	default:
+		return SerializeInt64(data, value.Int(), PacketNullByte), nil
+	}
+}
+
+// decodeInt deserializes a signed integer value with the deserializer of its size.
+func decodeInt(data []byte, value reflect.Value) ([]byte, error) {
+	var decoded int64
+	var err error
+	switch value.Kind() {
+	case reflect.Int8:
+		var decoded8 int8
+		decoded8, data, err = DeserializeInt8(data, PacketNullByte)
+		decoded = int64(decoded8)
+	case reflect.Int16:
+		var decoded16 int16
+		decoded16, data, err = DeserializeInt16(data, PacketNullByte)
+		decoded = int64(decoded16)
+	case reflect.Int32:
+		var decoded32 int32
+		decoded32, data, err = DeserializeInt32(data, PacketNullByte)
+		decoded = int64(decoded32)
+	default:
+		decoded, data, err = DeserializeInt64(data, PacketNullByte)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if value.OverflowInt(decoded) {
+		return nil, fmt.Errorf("value %d overflows %s", decoded, value.Type())
+	}
+	value.SetInt(decoded)
+	return data, nil
+}
+
+// encodeUint serializes an unsigned integer value with the serializer of its size.
+func encodeUint(data []byte, value reflect.Value) ([]byte, error) {
+	switch value.Kind() {
+	case reflect.Uint8:
+		return SerializeInt8(data, int8(uint8(value.Uint())), PacketNullByte), nil
+	case reflect.Uint16:
+		return SerializeUint16(data, uint16(value.Uint()), PacketNullByte), nil
+	case reflect.Uint32:
+		return SerializeUint32(data, uint32(value.Uint()), PacketNullByte), nil
+	default:
+		return SerializeUint64(data, value.Uint(), PacketNullByte), nil
+	}
+}
+
+// decodeUint deserializes an unsigned integer value with the deserializer of its size.
+func decodeUint(data []byte, value reflect.Value) ([]byte, error) {
+	var decoded uint64
+	var err error
+	switch value.Kind() {
+	case reflect.Uint8:
+		var decoded8 int8
+		decoded8, data, err = DeserializeInt8(data, PacketNullByte)
+		decoded = uint64(uint8(decoded8))
+	case reflect.Uint16:
+		var decoded16 uint16
+		decoded16, data, err = DeserializeUint16(data, PacketNullByte)
+		decoded = uint64(decoded16)
+	case reflect.Uint32:
+		var decoded32 uint32
+		decoded32, data, err = DeserializeUint32(data, PacketNullByte)
+		decoded = uint64(decoded32)
+	default:
+		decoded, data, err = DeserializeUint64(data, PacketNullByte)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if value.OverflowUint(decoded) {
+		return nil, fmt.Errorf("value %d overflows %s", decoded, value.Type())
+	}
+	value.SetUint(decoded)
+	return data, nil
+}
+
+// encodeFloat serializes a floating point value with the serializer of its size.
+func encodeFloat(data []byte, value reflect.Value) ([]byte, error) {
+	if value.Kind() == reflect.Float32 {
+		return SerializeFloat32(data, float32(value.Float()), PacketNullByte), nil
+	}
+	return SerializeFloat64(data, value.Float(), PacketNullByte), nil
+}
+
+// decodeFloat deserializes a floating point value with the deserializer of its size.
+func decodeFloat(data []byte, value reflect.Value) ([]byte, error) {
+	if value.Kind() == reflect.Float32 {
+		decoded, data, err := DeserializeFloat32(data, PacketNullByte)
+		if err != nil {
+			return nil, err
+		}
+		value.SetFloat(float64(decoded))
+		return data, nil
+	}
+	decoded, data, err := DeserializeFloat64(data, PacketNullByte)
+	if err != nil {
+		return nil, err
+	}
+	value.SetFloat(decoded)
+	return data, nil
+}
+
+// encodeString serializes a string value.
+func encodeString(data []byte, value reflect.Value) ([]byte, error) {
+	return SerializeString(data, value.String(), PacketNullByte), nil
+}
+
+// decodeString deserializes a string value.
+func decodeString(data []byte, value reflect.Value) ([]byte, error) {
+	decoded, data, err := DeserializeString(data, PacketNullByte)
+	if err != nil {
+		return nil, err
+	}
+	value.SetString(decoded)
+	return data, nil
+}
+
+// encodeBytes serializes a bytes value.
+func encodeBytes(data []byte, value reflect.Value) ([]byte, error) {
+	return SerializeBytes(data, value.Bytes(), PacketNullByte), nil
+}
+
+// decodeBytes deserializes a bytes value.
+func decodeBytes(data []byte, value reflect.Value) ([]byte, error) {
+	decoded, data, err := DeserializeBytes(data, PacketNullByte)
+	if err != nil {
+		return nil, err
+	}
+	value.SetBytes(decoded)
+	return data, nil
+}
+
+// encodeUUID serializes a UUID value.
+func encodeUUID(data []byte, value reflect.Value) ([]byte, error) {
+	var uuid [16]byte
+	for i := range uuid {
+		uuid[i] = byte(value.Index(i).Uint())
+	}
+	return SerializeUUID(data, uuid, PacketNullByte), nil
+}
+
+// decodeUUID deserializes a UUID value.
+func decodeUUID(data []byte, value reflect.Value) ([]byte, error) {
+	uuid, data, err := DeserializeUUID(data, PacketNullByte)
+	if err != nil {
+		return nil, err
+	}
+	for i := range uuid {
+		value.Index(i).SetUint(uint64(uuid[i]))
+	}
+	return data, nil
+}
+
+// getSliceCodec returns the codec of a slice, serialized as the number of values followed by the values.
+func getSliceCodec(sliceType reflect.Type) (encodeFunc, decodeFunc, error) {
+	encodeElem, decodeElem, err := getValueCodec(sliceType.Elem())
+	if err != nil {
+		return nil, nil, err
+	}
+	encode := func(data []byte, value reflect.Value) ([]byte, error) {
+		if uint64(value.Len()) > math.MaxUint32 {
+			return nil, fmt.Errorf("slice of %d values is too large", value.Len())
+		}
+		data = SerializeUint32(data, uint32(value.Len()), PacketNullByte)
+		var err error
+		for i := range value.Len() {
+			data, err = encodeElem(data, value.Index(i))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	}
+	decode := func(data []byte, value reflect.Value) ([]byte, error) {
+		size, data, err := DeserializeUint32(data, PacketNullByte)
+		if err != nil {
+			return nil, fmt.Errorf("missing data for slice")
+		}
+		if uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid data for slice: %d values exceed the remaining %d bytes", size, len(data))
+		}
+		decoded := reflect.MakeSlice(sliceType, int(size), int(size))
+		for i := range decoded.Len() {
+			data, err = decodeElem(data, decoded.Index(i))
+			if err != nil {
+				return nil, err
+			}
+		}
+		value.Set(decoded)
+		return data, nil
+	}
+	return encode, decode, nil
+}
+
+// getPointerCodec returns the codec of a pointer, serialized as the value it points to.
+func getPointerCodec(pointerType reflect.Type) (encodeFunc, decodeFunc, error) {
+	encodeElem, decodeElem, err := getValueCodec(pointerType.Elem())
+	if err != nil {
+		return nil, nil, err
+	}
+	encode := func(data []byte, value reflect.Value) ([]byte, error) {
+		if value.IsNil() {
+			return nil, fmt.Errorf("nil pointer of type %s", pointerType)
+		}
+		return encodeElem(data, value.Elem())
+	}
+	decode := func(data []byte, value reflect.Value) ([]byte, error) {
+		decoded := reflect.New(pointerType.Elem())
+		data, err := decodeElem(data, decoded.Elem())
+		if err != nil {
+			return nil, err
+		}
+		value.Set(decoded)
+		return data, nil
+	}
+	return encode, decode, nil
+}
+
+// getNestedStructCodec returns the codec of a nested struct, serialized prefixed by its length.
+func getNestedStructCodec(structType reflect.Type) (encodeFunc, decodeFunc, error) {
+	encode := func(data []byte, value reflect.Value) ([]byte, error) {
+		codec, err := getStructCodec(structType)
+		if err != nil {
+			return nil, err
+		}
+		payload, err := codec.encode(nil, value)
+		if err != nil {
+			return nil, err
+		}
+		return SerializeDelimitedBytes(data, payload, PacketNullByte)
+	}
+	decode := func(data []byte, value reflect.Value) ([]byte, error) {
+		codec, err := getStructCodec(structType)
+		if err != nil {
+			return nil, err
+		}
+		payload, data, err := DeserializeDelimitedBytes(data, PacketNullByte)
+		if err != nil {
+			return nil, fmt.Errorf("missing data for struct")
+		}
+		if _, err = codec.decode(payload, value); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	return encode, decode, nil
+}
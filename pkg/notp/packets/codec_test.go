@@ -0,0 +1,170 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// codecNestedPacket represents a nested struct serialized by the codec.
+type codecNestedPacket struct {
+	Name   string   `notp:"1"`
+	Values []uint16 `notp:"2"`
+}
+
+// codecSamplePacket represents a packet serialized by the codec.
+type codecSamplePacket struct {
+	Text      string               `notp:"2"`
+	ID        uint64               `notp:"1"`
+	Enabled   bool                 `notp:"3"`
+	Small     int8                 `notp:"4"`
+	Byte      uint8                `notp:"5"`
+	Signed    int32                `notp:"6"`
+	Ratio     float64              `notp:"7"`
+	CreatedAt time.Time            `notp:"8"`
+	UUID      [16]byte             `notp:"9"`
+	Data      []byte               `notp:"10"`
+	Tags      []string             `notp:"11"`
+	Nested    codecNestedPacket    `notp:"12"`
+	Children  []*codecNestedPacket `notp:"13"`
+	Protocol  ProtocolPacket       `notp:"14"`
+	Ignored   string
+	Skipped   string `notp:"-"`
+}
+
+// GetType returns the type of the packet.
+func (p *codecSamplePacket) GetType() uint64 {
+	return CombineUint32toUint64(100, 0)
+}
+
+// Serialize serializes the packet.
+func (p *codecSamplePacket) Serialize() ([]byte, error) {
+	return Marshal(p)
+}
+
+// Deserialize deserializes the packet.
+func (p *codecSamplePacket) Deserialize(data []byte) error {
+	return Unmarshal(data, p)
+}
+
+// TestCodecRoundTrip tests that the codec deserializes the packets it serializes.
+func TestCodecRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	inPacket := &codecSamplePacket{
+		Text:      "sample",
+		ID:        0xFFFFFFFFFFFFFFFF,
+		Enabled:   true,
+		Small:     -8,
+		Byte:      0xFF,
+		Signed:    -123456,
+		Ratio:     0.25,
+		CreatedAt: time.Date(2024, time.March, 10, 12, 30, 45, 123456789, time.UTC),
+		UUID:      [16]byte{0xFF, 0x01, 0x02},
+		Data:      []byte{0x00, 0xFF},
+		Tags:      []string{"first", ""},
+		Nested:    codecNestedPacket{Name: "nested", Values: []uint16{1, 0xFFFF}},
+		Children:  []*codecNestedPacket{{Name: "child", Values: []uint16{}}},
+		Protocol:  ProtocolPacket{Version: ProtocolVersion2, MinVersion: ProtocolVersion1, MaxVersion: ProtocolVersion2},
+		Ignored:   "ignored",
+		Skipped:   "skipped",
+	}
+	data, err := inPacket.Serialize()
+	assert.Nil(err)
+
+	outPacket := &codecSamplePacket{}
+	err = outPacket.Deserialize(data)
+	assert.Nil(err)
+	inPacket.Ignored, inPacket.Skipped = "", ""
+	assert.Equal(inPacket, outPacket)
+
+	for size := range len(data) {
+		assert.NotNil(Unmarshal(data[:size], &codecSamplePacket{}))
+	}
+}
+
+// TestCodecCompatibility tests that the codec serializes the same bytes as the hand-written serializers.
+func TestCodecCompatibility(t *testing.T) {
+	assert := assert.New(t)
+
+	sample := &SamplePacket{Text: "sample"}
+	expected, err := sample.Serialize()
+	assert.Nil(err)
+	data, err := Marshal(struct {
+		Text string `notp:"1"`
+	}{Text: "sample"})
+	assert.Nil(err)
+	assert.Equal(expected, data)
+
+	createdAt := time.Date(2024, time.March, 10, 12, 30, 45, 0, time.UTC)
+	protocol := &ProtocolPacket{Version: ProtocolVersion1}
+	expected = SerializeUint16(nil, 0xFF00, PacketNullByte)
+	expected = SerializeInt64(expected, -1, PacketNullByte)
+	expected = SerializeFloat32(expected, 1.5, PacketNullByte)
+	expected = SerializeTime(expected, createdAt, PacketNullByte)
+	expected = SerializeSlice(expected, []uint32{1, 2}, PacketNullByte, SerializeUint32)
+	expected, err = SerializePacketable(expected, protocol, PacketNullByte)
+	assert.Nil(err)
+	data, err = Marshal(&struct {
+		Code      uint16          `notp:"1"`
+		Value     int64           `notp:"2"`
+		Ratio     float32         `notp:"3"`
+		CreatedAt time.Time       `notp:"4"`
+		Values    []uint32        `notp:"5"`
+		Protocol  *ProtocolPacket `notp:"6"`
+	}{Code: 0xFF00, Value: -1, Ratio: 1.5, CreatedAt: createdAt, Values: []uint32{1, 2}, Protocol: protocol})
+	assert.Nil(err)
+	assert.Equal(expected, data)
+}
+
+// TestCodecWithInvalidTypes tests that the codec rejects the types it cannot serialize.
+func TestCodecWithInvalidTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Marshal(struct {
+		First  uint16 `notp:"1"`
+		Second uint16 `notp:"1"`
+	}{})
+	assert.NotNil(err)
+	_, err = Marshal(struct {
+		value uint16 `notp:"1"`
+	}{})
+	assert.NotNil(err)
+	_, err = Marshal(struct {
+		Value uint16 `notp:"first"`
+	}{})
+	assert.NotNil(err)
+	_, err = Marshal(struct {
+		Values map[string]string `notp:"1"`
+	}{})
+	assert.NotNil(err)
+	_, err = Marshal(struct {
+		Nested *codecNestedPacket `notp:"1"`
+	}{})
+	assert.NotNil(err)
+	_, err = Marshal("sample")
+	assert.NotNil(err)
+	_, err = Marshal((*codecSamplePacket)(nil))
+	assert.NotNil(err)
+	assert.NotNil(Unmarshal([]byte{}, codecSamplePacket{}))
+	assert.NotNil(Unmarshal(SerializeUint16(nil, 0x0102, PacketNullByte), &struct {
+		Value int8 `notp:"1"`
+	}{}))
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	// ErrMissingProtocol is returned when the packet does not start with a protocol packet.
+	ErrMissingProtocol = errors.New("notp: missing protocol packet")
+	// ErrTruncated is returned when the packet is shorter than declared by its headers.
+	ErrTruncated = errors.New("notp: truncated packet")
+	// ErrOversized is returned when a payload exceeds the configured maximum size.
+	ErrOversized = errors.New("notp: oversized packet")
+	// ErrBadHeader is returned when a header of the packet is malformed.
+	ErrBadHeader = errors.New("notp: bad packet header")
+	// ErrBadPayload is returned when a payload of the packet cannot be decoded.
+	ErrBadPayload = errors.New("notp: bad packet payload")
+	// ErrTooManyPackets is returned when a data stream exceeds the configured maximum number of data packets.
+	ErrTooManyPackets = errors.New("notp: too many data packets")
+	// ErrChecksumMismatch is returned when a checksum does not match the checksummed data.
+	ErrChecksumMismatch = errors.New("notp: checksum mismatch")
+	// ErrStreamComplete is returned when reading past the last data packet of a data stream.
+	ErrStreamComplete = errors.New("notp: data packet already complete")
+)
+
+// PacketReaderLimits holds the limits enforced while decoding packets, where zero means unlimited.
+type PacketReaderLimits struct {
+	MaxPayloadSize uint64
+	MaxStreamSize  uint64
+}
+
+// checkPayloadSize checks the size of a payload against the limits.
+func (l PacketReaderLimits) checkPayloadSize(size uint64) error {
+	if l.MaxPayloadSize > 0 && size > l.MaxPayloadSize {
+		return fmt.Errorf("%w: payload of %d bytes exceeds the maximum of %d bytes", ErrOversized, size, l.MaxPayloadSize)
+	}
+	return nil
+}
+
+// checkEncodedPayloadSize checks the size of a base64 encoded payload against the limits, rejecting the ones too large to decode within them.
+func (l PacketReaderLimits) checkEncodedPayloadSize(size uint64) error {
+	if l.MaxPayloadSize == 0 || l.MaxPayloadSize > math.MaxInt64/2 {
+		return nil
+	}
+	if size > uint64(base64.StdEncoding.EncodedLen(int(l.MaxPayloadSize))) {
+		return fmt.Errorf("%w: payload of %d encoded bytes exceeds the maximum of %d bytes", ErrOversized, size, l.MaxPayloadSize)
+	}
+	return nil
+}
+
+// decodePayload decodes a base64 encoded payload checking its decoded size against the limits.
+func (l PacketReaderLimits) decodePayload(payload []byte) ([]byte, error) {
+	if err := l.checkEncodedPayloadSize(uint64(len(payload))); err != nil {
+		return nil, err
+	}
+	data, err := decodeDataPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.checkPayloadSize(uint64(len(data))); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// checkStreamSize checks the number of data packets of a data stream against the limits.
+func (l PacketReaderLimits) checkStreamSize(streamSize uint64) error {
+	if streamSize == 0 {
+		return fmt.Errorf("%w: empty data stream", ErrBadHeader)
+	}
+	if l.MaxStreamSize > 0 && streamSize > l.MaxStreamSize {
+		return fmt.Errorf("%w: stream of %d data packets exceeds the maximum of %d", ErrTooManyPackets, streamSize, l.MaxStreamSize)
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// FuzzSplitData fuzzes the split of null byte terminated data.
+func FuzzSplitData(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, PacketNullByte, 0x03}, 2)
+	f.Add([]byte{PacketNullByte}, 0)
+	f.Add([]byte{}, 1)
+	f.Fuzz(func(t *testing.T, data []byte, expectedSize int) {
+		current, left, err := SplitData[[]byte](data, PacketNullByte, expectedSize)
+		if err != nil {
+			return
+		}
+		if len(current) > expectedSize || len(current)+len(left)+1 != len(data) || bytes.IndexByte(current, PacketNullByte) != -1 {
+			t.Fatalf("invalid split of %v: %v %v", data, current, left)
+		}
+	})
+}
+
+// FuzzDeserializeUint16 fuzzes the deserialization of uint16 values.
+func FuzzDeserializeUint16(f *testing.F) {
+	f.Add(SerializeUint16(nil, 0xFFFF, PacketNullByte))
+	f.Add([]byte{0x01, PacketNullByte})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, left, err := DeserializeUint16(data, PacketNullByte)
+		if err != nil {
+			return
+		}
+		if out := SerializeUint16(nil, value, PacketNullByte); !bytes.Equal(append(out, left...), data) {
+			t.Fatalf("round trip mismatch for %v", data)
+		}
+	})
+}
+
+// FuzzDeserializeUint32 fuzzes the deserialization of uint32 values.
+func FuzzDeserializeUint32(f *testing.F) {
+	f.Add(SerializeUint32(nil, 0xFF00FF00, PacketNullByte))
+	f.Add([]byte{0x01, 0x02, PacketNullByte})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, left, err := DeserializeUint32(data, PacketNullByte)
+		if err != nil {
+			return
+		}
+		if out := SerializeUint32(nil, value, PacketNullByte); !bytes.Equal(append(out, left...), data) {
+			t.Fatalf("round trip mismatch for %v", data)
+		}
+	})
+}
+
+// FuzzDeserializeUint64 fuzzes the deserialization of uint64 values.
+func FuzzDeserializeUint64(f *testing.F) {
+	f.Add(SerializeUint64(nil, 0xFFFFFFFFFFFFFFFF, PacketNullByte))
+	f.Add([]byte{0x01, 0x02, 0x03, PacketNullByte})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, left, err := DeserializeUint64(data, PacketNullByte)
+		if err != nil {
+			return
+		}
+		if out := SerializeUint64(nil, value, PacketNullByte); !bytes.Equal(append(out, left...), data) {
+			t.Fatalf("round trip mismatch for %v", data)
+		}
+	})
+}
+
+// FuzzDeserializeBool fuzzes the deserialization of bool values.
+func FuzzDeserializeBool(f *testing.F) {
+	f.Add(SerializeBool(nil, true, PacketNullByte))
+	f.Add([]byte{0x02, PacketNullByte})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, left, err := DeserializeBool(data, PacketNullByte)
+		if err != nil {
+			return
+		}
+		if len(left) != len(data)-2 || value != (data[0] == 1) {
+			t.Fatalf("invalid bool for %v", data)
+		}
+	})
+}
+
+// FuzzDeserializeString fuzzes the serialization and the deserialization of string values.
+func FuzzDeserializeString(f *testing.F) {
+	f.Add("fd1d3938-2988-4df3-9b83-cc278b69cab0", SerializeString(nil, "sample", PacketNullByte))
+	f.Add("", []byte{'*', PacketNullByte})
+	f.Fuzz(func(t *testing.T, value string, data []byte) {
+		out, left, err := DeserializeString(SerializeString(nil, value, PacketNullByte), PacketNullByte)
+		if err != nil || out != value || len(left) != 0 {
+			t.Fatalf("round trip mismatch for %q", value)
+		}
+		DeserializeString(data, PacketNullByte)
+	})
+}
+
+// FuzzDeserializeBytes fuzzes the serialization and the deserialization of bytes values.
+func FuzzDeserializeBytes(f *testing.F) {
+	f.Add([]byte{0xFF, 0x00, 0xFF}, SerializeBytes(nil, []byte("sample"), PacketNullByte))
+	f.Add([]byte{}, []byte{'=', PacketNullByte})
+	f.Fuzz(func(t *testing.T, value []byte, data []byte) {
+		out, left, err := DeserializeBytes(SerializeBytes(nil, value, PacketNullByte), PacketNullByte)
+		if err != nil || !bytes.Equal(out, value) || len(left) != 0 {
+			t.Fatalf("round trip mismatch for %v", value)
+		}
+		DeserializeBytes(data, PacketNullByte)
+	})
+}
+
+// FuzzDeserializeTime fuzzes the deserialization of time values.
+func FuzzDeserializeTime(f *testing.F) {
+	f.Add(SerializeTime(nil, time.Date(2024, time.March, 10, 12, 30, 45, 123456789, time.UTC), PacketNullByte))
+	f.Add([]byte{0x01, 0x02, 0x03, PacketNullByte})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, left, err := DeserializeTime(data, PacketNullByte)
+		if err != nil {
+			return
+		}
+		if out := SerializeTime(nil, value, PacketNullByte); !bytes.Equal(append(out, left...), data) {
+			t.Fatalf("round trip mismatch for %v", data)
+		}
+	})
+}
+
+// FuzzDeserializeSlice fuzzes the deserialization of slices of nested packets.
+func FuzzDeserializeSlice(f *testing.F) {
+	deserialize := func(data []byte, nullByte byte) (*ProtocolPacket, []byte, error) {
+		protocol := &ProtocolPacket{}
+		data, err := DeserializePacketable(data, protocol, nullByte)
+		return protocol, data, err
+	}
+	serialize := func(data []byte, protocol *ProtocolPacket, nullByte byte) []byte {
+		data, _ = SerializePacketable(data, protocol, nullByte)
+		return data
+	}
+	f.Add(SerializeSlice(nil, []*ProtocolPacket{{Version: ProtocolVersion1}, {Version: ProtocolVersion2, MinVersion: ProtocolVersion1, MaxVersion: ProtocolVersion2}}, PacketNullByte, serialize))
+	f.Add(SerializeUint32(nil, 0xFFFFFFFF, PacketNullByte))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		values, _, err := DeserializeSlice(data, PacketNullByte, deserialize)
+		if err != nil {
+			return
+		}
+		out := SerializeSlice(nil, values, PacketNullByte, serialize)
+		other, left, err := DeserializeSlice(out, PacketNullByte, deserialize)
+		if err != nil || len(left) != 0 || len(other) != len(values) {
+			t.Fatalf("round trip mismatch for %v", data)
+		}
+		for i := range values {
+			if *other[i] != *values[i] {
+				t.Fatalf("round trip mismatch for %v", data)
+			}
+		}
+	})
+}
+
+// FuzzUnmarshal fuzzes the deserialization of the packets serialized by the codec.
+func FuzzUnmarshal(f *testing.F) {
+	data, _ := Marshal(&codecSamplePacket{Tags: []string{"sample"}, Children: []*codecNestedPacket{{Name: "child"}}})
+	f.Add(data)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet := &codecSamplePacket{}
+		if err := Unmarshal(data, packet); err != nil {
+			return
+		}
+		out, err := Marshal(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other := &codecSamplePacket{}
+		if err = Unmarshal(out, other); err != nil {
+			t.Fatalf("round trip mismatch for %v", data)
+		}
+		if otherOut, err := Marshal(other); err != nil || !bytes.Equal(out, otherOut) {
+			t.Fatalf("round trip mismatch for %v", data)
+		}
+	})
+}
+
+// FuzzProtocolPacket fuzzes the deserialization of protocol packets.
+func FuzzProtocolPacket(f *testing.F) {
+	data, _ := (&ProtocolPacket{Version: ProtocolVersion1, MinVersion: ProtocolVersion1, MaxVersion: ProtocolVersion2}).Serialize()
+	f.Add(data)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		protocol := &ProtocolPacket{}
+		if err := protocol.Deserialize(data); err != nil {
+			return
+		}
+		out, err := protocol.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		other := &ProtocolPacket{}
+		if err = other.Deserialize(out); err != nil || *other != *protocol {
+			t.Fatalf("round trip mismatch for %v", data)
+		}
+	})
+}
+
+// readAllDataPackets reads all the data packets with the input reader function.
+func readAllDataPackets(read func(*DataPacketState) ([]byte, *DataPacketState, error)) ([][]byte, []uint64, error) {
+	payloads := [][]byte{}
+	types := []uint64{}
+	var state *DataPacketState
+	for state == nil || !state.IsComplete() {
+		var payload []byte
+		var err error
+		payload, state, err = read(state)
+		if err != nil {
+			return nil, nil, err
+		}
+		payloads = append(payloads, payload)
+		types = append(types, state.GetPacketType())
+	}
+	return payloads, types, nil
+}
+
+// FuzzReadNextDataPacket fuzzes the buffered and the streaming packet readers, which must agree on every input.
+func FuzzReadNextDataPacket(f *testing.F) {
+	for _, version := range []uint32{ProtocolVersion1, ProtocolVersion2} {
+		packet := &Packet{}
+		writer, _ := NewPacketWriter(packet)
+		writer.WriteProtocol(&ProtocolPacket{Version: version})
+		writer.AppendDataPacket(&Packet{Data: []byte("sample data")})
+		writer.AppendDataPacket(&Packet{Data: []byte{0xFF, 0x00, 0xFF}})
+		f.Add(packet.Data)
+	}
+	packet := &Packet{}
+	writer, _ := NewPacketWriter(packet)
+	writer.WriteProtocol(&ProtocolPacket{Version: ProtocolVersion2, MinVersion: ProtocolVersion1, MaxVersion: ProtocolVersion2, Flags: DataChecksumFlag | PacketChecksumFlag})
+	writer.AppendDataPacket(&Packet{Data: []byte("sample data")})
+	f.Add(packet.Data)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		limits := PacketReaderLimits{MaxPayloadSize: 1 << 20, MaxStreamSize: 1 << 10}
+		reader, err := NewPacketReaderWithLimits(&Packet{Data: data}, limits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payloads, types, err := readAllDataPackets(reader.ReadNextDataPacket)
+
+		streamReader, streamErr := NewPacketStreamReaderWithLimits(bytes.NewReader(data), limits)
+		if streamErr != nil {
+			t.Fatal(streamErr)
+		}
+		streamPayloads, streamTypes, streamErr := readAllDataPackets(streamReader.ReadNextDataPacket)
+		if (err == nil) != (streamErr == nil) {
+			t.Fatalf("readers disagree on %v: %v %v", data, err, streamErr)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrMissingProtocol) && !errors.Is(err, ErrTruncated) && !errors.Is(err, ErrOversized) &&
+				!errors.Is(err, ErrBadHeader) && !errors.Is(err, ErrBadPayload) && !errors.Is(err, ErrTooManyPackets) &&
+				!errors.Is(err, ErrChecksumMismatch) {
+				t.Fatalf("untyped error for %v: %v", data, err)
+			}
+			return
+		}
+		if len(payloads) != len(streamPayloads) {
+			t.Fatalf("readers disagree on %v", data)
+		}
+		for i := range payloads {
+			if !bytes.Equal(payloads[i], streamPayloads[i]) || types[i] != streamTypes[i] {
+				t.Fatalf("readers disagree on %v", data)
+			}
+		}
+	})
+}
+
+// FuzzPacketWriterRoundTrip fuzzes the packet writer checking that the readers return the written payloads.
+func FuzzPacketWriterRoundTrip(f *testing.F) {
+	f.Add(ProtocolVersion1, []byte("sample data"), []byte{0xFF})
+	f.Add(ProtocolVersion2, []byte{}, []byte{0x00, 0xFF})
+	f.Fuzz(func(t *testing.T, version uint32, first []byte, second []byte) {
+		packet := &Packet{}
+		writer, _ := NewPacketWriter(packet)
+		if err := writer.WriteProtocol(&ProtocolPacket{Version: version}); err != nil {
+			t.Fatal(err)
+		}
+		inPayloads := [][]byte{first, second}
+		for _, payload := range inPayloads {
+			if err := writer.AppendDataPacket(&Packet{Data: payload}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		reader, _ := NewPacketReader(packet)
+		protocol, err := reader.ReadProtocol()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if protocol.Version != version {
+			t.Fatalf("version mismatch: %d %d", protocol.Version, version)
+		}
+		payloads, _, err := readAllDataPackets(reader.ReadNextDataPacket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range inPayloads {
+			if !bytes.Equal(inPayloads[i], payloads[i]) {
+				t.Fatalf("payload mismatch: %v %v", inPayloads[i], payloads[i])
+			}
+		}
+	})
+}
@@ -46,6 +46,17 @@ func (p *Packet) Deserialize(data []byte) error {
 	return nil
 }
 
+// UnknownPacket represents a packet whose type is not registered.
+type UnknownPacket struct {
+	Packet
+	PacketType uint64
+}
+
+// GetType returns the packet type.
+func (p *UnknownPacket) GetType() uint64 {
+	return p.PacketType
+}
+
 // Packetable represents a packet that can be serialized and deserialized.
 type Packetable interface {
 	GetType() uint64
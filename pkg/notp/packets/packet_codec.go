@@ -0,0 +1,102 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// BinaryCodecID represents the identifier of the codec using the binary serialization of the packets.
+	BinaryCodecID = uint32(0)
+	// JSONCodecID represents the identifier of the codec using the JSON serialization of the packets.
+	JSONCodecID = uint32(1)
+)
+
+// PacketCodec encodes and decodes the payloads of the data packets.
+type PacketCodec interface {
+	// GetID returns the identifier of the codec announced in the protocol packet.
+	GetID() uint32
+	// Encode encodes the packet into a payload.
+	Encode(packet Packetable) ([]byte, error)
+	// Decode decodes the payload into the packet.
+	Decode(data []byte, packet Packetable) error
+}
+
+// binaryCodec is the codec using the Serialize and Deserialize methods of the packets.
+type binaryCodec struct{}
+
+// GetID returns the identifier of the codec.
+func (c *binaryCodec) GetID() uint32 {
+	return BinaryCodecID
+}
+
+// Encode encodes the packet into a payload.
+func (c *binaryCodec) Encode(packet Packetable) ([]byte, error) {
+	return packet.Serialize()
+}
+
+// Decode decodes the payload into the packet.
+func (c *binaryCodec) Decode(data []byte, packet Packetable) error {
+	return packet.Deserialize(data)
+}
+
+// NewBinaryCodec creates a codec using the binary serialization of the packets.
+func NewBinaryCodec() PacketCodec {
+	return &binaryCodec{}
+}
+
+// jsonCodec is the codec using the JSON representation of the exported fields of the packets.
+type jsonCodec struct{}
+
+// GetID returns the identifier of the codec.
+func (c *jsonCodec) GetID() uint32 {
+	return JSONCodecID
+}
+
+// Encode encodes the packet into a payload, keeping the payload of the unknown packets as it is.
+func (c *jsonCodec) Encode(packet Packetable) ([]byte, error) {
+	if unknown, ok := packet.(*UnknownPacket); ok {
+		return unknown.Data, nil
+	}
+	data, err := json.Marshal(packet)
+	if err != nil {
+		return nil, fmt.Errorf("notp: failed to encode packet of type %d: %w", packet.GetType(), err)
+	}
+	return data, nil
+}
+
+// Decode decodes the payload into the packet, keeping the payload of the unknown packets as it is.
+func (c *jsonCodec) Decode(data []byte, packet Packetable) error {
+	if unknown, ok := packet.(*UnknownPacket); ok {
+		unknown.Data = data
+		return nil
+	}
+	if err := json.Unmarshal(data, packet); err != nil {
+		return fmt.Errorf("notp: failed to decode packet of type %d: %w", packet.GetType(), err)
+	}
+	return nil
+}
+
+// NewJSONCodec creates a codec using the JSON representation of the packets, meant for debugging and bridging.
+//
+// The packets are decoded into the types of the packet registry, so the packets which are not registered are
+// received as unknown packets holding their JSON payload.
+func NewJSONCodec() PacketCodec {
+	return &jsonCodec{}
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPacketCodecs tests that the packet codecs decode the payloads they encode.
+func TestPacketCodecs(t *testing.T) {
+	assert := assert.New(t)
+
+	registry := NewPacketRegistry()
+	err := registry.Register(func() Packetable { return &ProtocolPacket{} })
+	assert.Nil(err)
+	inPacket := &ProtocolPacket{Version: ProtocolVersion2, MinVersion: ProtocolVersion1, MaxVersion: ProtocolVersion2, Codec: JSONCodecID}
+	for _, codec := range []PacketCodec{NewBinaryCodec(), NewJSONCodec()} {
+		data, err := codec.Encode(inPacket)
+		assert.Nil(err)
+		outPacketable, err := registry.DecodeWithCodec(codec, inPacket.GetType(), data)
+		assert.Nil(err)
+		assert.Equal(inPacket, outPacketable)
+	}
+
+	codec := NewJSONCodec()
+	data, err := codec.Encode(inPacket)
+	assert.Nil(err)
+	assert.JSONEq(`{"Version": 2, "MinVersion": 1, "MaxVersion": 2, "Flags": 0, "Compression": 0, "Compressions": 0, "Codec": 1}`, string(data))
+	_, err = registry.DecodeWithCodec(codec, inPacket.GetType(), []byte("not json"))
+	assert.NotNil(err)
+
+	unknownPacket := &UnknownPacket{Packet: Packet{Data: []byte(`{"Text":"sample"}`)}, PacketType: 100}
+	data, err = codec.Encode(unknownPacket)
+	assert.Nil(err)
+	assert.Equal(unknownPacket.Data, data)
+	outUnknown := &UnknownPacket{}
+	assert.Nil(codec.Decode(data, outUnknown))
+	assert.Equal(unknownPacket.Data, outUnknown.Data)
+}
+
+// TestProtocolPacketWithCodec tests the protocol packet announcing the codec.
+func TestProtocolPacketWithCodec(t *testing.T) {
+	assert := assert.New(t)
+
+	inPacket := &ProtocolPacket{Version: ProtocolVersion2, Codec: JSONCodecID}
+	data, err := inPacket.Serialize()
+	assert.Nil(err)
+	outPacket := &ProtocolPacket{}
+	assert.Nil(outPacket.Deserialize(data))
+	assert.Equal(JSONCodecID, outPacket.Codec)
+	assert.Nil(outPacket.validate())
+
+	legacyPacket := &ProtocolPacket{Version: ProtocolVersion1, Codec: JSONCodecID}
+	assert.ErrorIs(legacyPacket.validate(), ErrBadHeader)
+}
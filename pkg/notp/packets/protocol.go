@@ -16,9 +16,41 @@
 
 package packets
 
+import "fmt"
+
+const (
+	// ProtocolVersion1 represents the protocol version with delimited headers and base64 encoded payloads.
+	ProtocolVersion1 = uint32(1)
+	// ProtocolVersion2 represents the protocol version with length-prefixed headers and raw payloads.
+	ProtocolVersion2 = uint32(2)
+	// LatestProtocolVersion represents the latest supported protocol version.
+	LatestProtocolVersion = ProtocolVersion2
+
+	// DataChecksumFlag indicates that each data packet is followed by a CRC32C checksum.
+	DataChecksumFlag = uint32(1 << 0)
+	// PacketChecksumFlag indicates that the packet ends with a CRC32C checksum of the whole packet.
+	PacketChecksumFlag = uint32(1 << 1)
+	// checksumFlags represents all the checksum flags.
+	checksumFlags = DataChecksumFlag | PacketChecksumFlag
+
+	// NoCompression represents the identifier of the data stream written without compression.
+	NoCompression = uint32(0)
+	// maxCompression represents the highest compression identifier which can be advertised.
+	maxCompression = uint32(31)
+)
+
 // ProtocolPacket represents a protocol packet.
 type ProtocolPacket struct {
-	Version uint32
+	Version    uint32
+	MinVersion uint32
+	MaxVersion uint32
+	Flags      uint32
+	// Compression is the identifier of the codec used to compress the data stream following the protocol packet.
+	Compression uint32
+	// Compressions is the bitmask of the codec identifiers supported by the sender.
+	Compressions uint32
+	// Codec is the identifier of the codec used to encode the payloads of the data packets.
+	Codec uint32
 }
 
 // GetType returns the type of the packet.
@@ -26,19 +58,131 @@ func (p *ProtocolPacket) GetType() uint64 {
 	return CombineUint32toUint64(ProtocolPacketType, 0)
 }
 
+// HasLengthPrefixedFraming returns true if the data packets are written with length-prefixed headers.
+func (p *ProtocolPacket) HasLengthPrefixedFraming() bool {
+	return p.Version >= ProtocolVersion2
+}
+
+// HasDataChecksum returns true if each data packet is followed by a checksum.
+func (p *ProtocolPacket) HasDataChecksum() bool {
+	return p.Flags&DataChecksumFlag != 0
+}
+
+// HasPacketChecksum returns true if the packet ends with a checksum of the whole packet.
+func (p *ProtocolPacket) HasPacketChecksum() bool {
+	return p.Flags&PacketChecksumFlag != 0
+}
+
+// IsCompressed returns true if the data stream following the protocol packet is compressed.
+func (p *ProtocolPacket) IsCompressed() bool {
+	return p.Compression != NoCompression
+}
+
+// SupportsCompression returns true if the sender advertises the support of the compression.
+func (p *ProtocolPacket) SupportsCompression(compression uint32) bool {
+	return compression <= maxCompression && p.Compressions&(1<<compression) != 0
+}
+
+// GetCompressionMask returns the bitmask advertising the input compressions.
+func GetCompressionMask(compressions ...uint32) (uint32, error) {
+	mask := uint32(0)
+	for _, compression := range compressions {
+		if compression > maxCompression {
+			return 0, fmt.Errorf("notp: invalid compression %d", compression)
+		}
+		mask |= 1 << compression
+	}
+	return mask, nil
+}
+
+// validate validates the flags and the compression against the wire format of the protocol version.
+func (p *ProtocolPacket) validate() error {
+	if p.Flags&checksumFlags != 0 && !p.HasLengthPrefixedFraming() {
+		return fmt.Errorf("%w: checksums require the length-prefixed wire format", ErrBadHeader)
+	}
+	if (p.Compression != NoCompression || p.Compressions != 0) && !p.HasLengthPrefixedFraming() {
+		return fmt.Errorf("%w: compression requires the length-prefixed wire format", ErrBadHeader)
+	}
+	if p.Codec != BinaryCodecID && !p.HasLengthPrefixedFraming() {
+		return fmt.Errorf("%w: codecs require the length-prefixed wire format", ErrBadHeader)
+	}
+	if p.Compression > maxCompression {
+		return fmt.Errorf("%w: invalid compression %d", ErrBadHeader, p.Compression)
+	}
+	return nil
+}
+
+// HasVersionRange returns true if the packet advertises the range of supported versions.
+func (p *ProtocolPacket) HasVersionRange() bool {
+	return p.MaxVersion != 0
+}
+
+// GetVersionRange returns the range of supported versions, defaulting to the packet version if it is not advertised.
+func (p *ProtocolPacket) GetVersionRange() (uint32, uint32) {
+	if !p.HasVersionRange() {
+		return p.Version, p.Version
+	}
+	return p.MinVersion, p.MaxVersion
+}
+
+// getOptionalFields returns the fields following the version range, which are serialized up to the last one set.
+func (p *ProtocolPacket) getOptionalFields() []*uint32 {
+	return []*uint32{&p.Flags, &p.Compression, &p.Compressions, &p.Codec}
+}
+
 // Serialize serializes the packet.
 func (p *ProtocolPacket) Serialize() ([]byte, error) {
 	data := SerializeUint32(nil, p.Version, PacketNullByte)
+	fields := p.getOptionalFields()
+	for len(fields) > 0 && *fields[len(fields)-1] == 0 {
+		fields = fields[:len(fields)-1]
+	}
+	if !p.HasVersionRange() && len(fields) == 0 {
+		return data, nil
+	}
+	minVersion, maxVersion := p.GetVersionRange()
+	data = SerializeUint32(data, minVersion, PacketNullByte)
+	data = SerializeUint32(data, maxVersion, PacketNullByte)
+	for _, field := range fields {
+		data = SerializeUint32(data, *field, PacketNullByte)
+	}
 	return data, nil
-
 }
 
-// Deserialize deserializes the packet.
+// Deserialize deserializes the packet, ignoring the trailing fields of later versions.
 func (p *ProtocolPacket) Deserialize(data []byte) error {
 	var err error
 	p.Version, data, err = DeserializeUint32(data, PacketNullByte)
 	if err != nil {
 		return err
 	}
+	p.MinVersion, p.MaxVersion = 0, 0
+	fields := p.getOptionalFields()
+	for _, field := range fields {
+		*field = 0
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	p.MinVersion, data, err = DeserializeUint32(data, PacketNullByte)
+	if err != nil {
+		return err
+	}
+	p.MaxVersion, data, err = DeserializeUint32(data, PacketNullByte)
+	if err != nil {
+		return err
+	}
+	if p.MaxVersion == 0 || p.MinVersion > p.MaxVersion {
+		return fmt.Errorf("invalid protocol version range %d-%d", p.MinVersion, p.MaxVersion)
+	}
+	for _, field := range fields {
+		if len(data) == 0 {
+			break
+		}
+		*field, data, err = DeserializeUint32(data, PacketNullByte)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -38,3 +38,58 @@ func TestProtocolPacket(t *testing.T) {
 
 	assert.Equal(inPacket.Version, outPacket.Version)
 }
+
+// TestProtocolPacketWithVersionRange tests the protocol packet with the range of supported versions.
+func TestProtocolPacketWithVersionRange(t *testing.T) {
+	assert := assert.New(t)
+
+	inPacket := &ProtocolPacket{Version: ProtocolVersion1, MinVersion: ProtocolVersion1, MaxVersion: ProtocolVersion2}
+	data, err := inPacket.Serialize()
+	assert.Nil(err)
+
+	outPacket := &ProtocolPacket{}
+	err = outPacket.Deserialize(data)
+	assert.Nil(err)
+	assert.True(outPacket.HasVersionRange())
+	minVersion, maxVersion := outPacket.GetVersionRange()
+	assert.Equal(ProtocolVersion1, minVersion)
+	assert.Equal(ProtocolVersion2, maxVersion)
+
+	legacyData, err := (&ProtocolPacket{Version: ProtocolVersion1}).Serialize()
+	assert.Nil(err)
+	assert.Equal(data[:len(legacyData)], legacyData)
+
+	legacyPacket := &ProtocolPacket{}
+	err = legacyPacket.Deserialize(legacyData)
+	assert.Nil(err)
+	assert.False(legacyPacket.HasVersionRange())
+	minVersion, maxVersion = legacyPacket.GetVersionRange()
+	assert.Equal(ProtocolVersion1, minVersion)
+	assert.Equal(ProtocolVersion1, maxVersion)
+}
+
+// TestProtocolPacketWithCompression tests the protocol packet with the compression fields.
+func TestProtocolPacketWithCompression(t *testing.T) {
+	assert := assert.New(t)
+
+	mask, err := GetCompressionMask(NoCompression, 2)
+	assert.Nil(err)
+	inPacket := &ProtocolPacket{Version: ProtocolVersion2, MinVersion: ProtocolVersion1, MaxVersion: ProtocolVersion2, Compression: 2, Compressions: mask}
+	data, err := inPacket.Serialize()
+	assert.Nil(err)
+
+	outPacket := &ProtocolPacket{}
+	err = outPacket.Deserialize(data)
+	assert.Nil(err)
+	assert.Nil(outPacket.validate())
+	assert.True(outPacket.IsCompressed())
+	assert.True(outPacket.SupportsCompression(NoCompression))
+	assert.True(outPacket.SupportsCompression(2))
+	assert.False(outPacket.SupportsCompression(1))
+	assert.Equal(*inPacket, *outPacket)
+
+	legacyPacket := &ProtocolPacket{Version: ProtocolVersion1, Compression: 2}
+	assert.ErrorIs(legacyPacket.validate(), ErrBadHeader)
+	_, err = GetCompressionMask(32)
+	assert.NotNil(err)
+}
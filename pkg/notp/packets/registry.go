@@ -0,0 +1,103 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrUnknownPacketType is returned when a packet type is not registered.
+	ErrUnknownPacketType = errors.New("notp: unknown packet type")
+	// ErrPacketTypeAlreadyRegistered is returned when a packet type is registered twice.
+	ErrPacketTypeAlreadyRegistered = errors.New("notp: packet type already registered")
+)
+
+// PacketFactory defines a function creating a new empty instance of a packet.
+type PacketFactory func() Packetable
+
+// PacketRegistry maps the packet types to the factories of the packets.
+type PacketRegistry struct {
+	factories map[uint64]PacketFactory
+	mutex     sync.RWMutex
+}
+
+// NewPacketRegistry creates a new packet registry with the generic packet already registered.
+func NewPacketRegistry() *PacketRegistry {
+	registry := &PacketRegistry{
+		factories: map[uint64]PacketFactory{},
+	}
+	registry.factories[CombineUint32toUint64(PacketType, 0)] = func() Packetable { return &Packet{} }
+	return registry
+}
+
+// Register registers the factory for the type of the packets it creates.
+func (r *PacketRegistry) Register(factory PacketFactory) error {
+	if factory == nil {
+		return errors.New("notp: nil packet factory")
+	}
+	packet := factory()
+	if packet == nil {
+		return errors.New("notp: packet factory returned a nil packet")
+	}
+	packetType := packet.GetType()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exists := r.factories[packetType]; exists {
+		return fmt.Errorf("%w: %d", ErrPacketTypeAlreadyRegistered, packetType)
+	}
+	r.factories[packetType] = factory
+	return nil
+}
+
+// IsRegistered returns true if the packet type is registered.
+func (r *PacketRegistry) IsRegistered(packetType uint64) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	_, exists := r.factories[packetType]
+	return exists
+}
+
+// New creates a new empty packet for the packet type.
+func (r *PacketRegistry) New(packetType uint64) (Packetable, error) {
+	r.mutex.RLock()
+	factory, exists := r.factories[packetType]
+	r.mutex.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownPacketType, packetType)
+	}
+	return factory(), nil
+}
+
+// Decode creates a new packet for the packet type and deserializes the data into it.
+func (r *PacketRegistry) Decode(packetType uint64, data []byte) (Packetable, error) {
+	return r.DecodeWithCodec(NewBinaryCodec(), packetType, data)
+}
+
+// DecodeWithCodec creates a new packet for the packet type and decodes the data into it with the codec.
+func (r *PacketRegistry) DecodeWithCodec(codec PacketCodec, packetType uint64, data []byte) (Packetable, error) {
+	packet, err := r.New(packetType)
+	if err != nil {
+		return nil, err
+	}
+	if err = codec.Decode(data, packet); err != nil {
+		return nil, fmt.Errorf("notp: failed to deserialize packet of type %d: %w", packetType, err)
+	}
+	return packet, nil
+}
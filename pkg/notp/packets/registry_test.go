@@ -0,0 +1,72 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPacketRegistry tests the registration and the decoding of packets.
+func TestPacketRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	registry := NewPacketRegistry()
+	assert.True(registry.IsRegistered(CombineUint32toUint64(PacketType, 0)))
+
+	protocolType := CombineUint32toUint64(ProtocolPacketType, 0)
+	_, err := registry.New(protocolType)
+	assert.ErrorIs(err, ErrUnknownPacketType)
+
+	err = registry.Register(func() Packetable { return &ProtocolPacket{} })
+	assert.Nil(err)
+	err = registry.Register(func() Packetable { return &ProtocolPacket{} })
+	assert.ErrorIs(err, ErrPacketTypeAlreadyRegistered)
+
+	data, err := (&ProtocolPacket{Version: ProtocolVersion2}).Serialize()
+	assert.Nil(err)
+	packet, err := registry.Decode(protocolType, data)
+	assert.Nil(err)
+	protocol, ok := packet.(*ProtocolPacket)
+	assert.True(ok)
+	assert.Equal(ProtocolVersion2, protocol.Version)
+
+	_, err = registry.Decode(protocolType, []byte{})
+	assert.NotNil(err)
+	assert.NotErrorIs(err, ErrUnknownPacketType)
+}
+
+// TestSerializersWithNullByteValues tests that fixed size values containing the null byte are deserialized.
+func TestSerializersWithNullByteValues(t *testing.T) {
+	assert := assert.New(t)
+
+	data := SerializeUint16(nil, 0xFFFF, PacketNullByte)
+	data = SerializeUint32(data, 0xFF00FF00, PacketNullByte)
+	data = SerializeUint64(data, 0xFFFFFFFFFFFFFFFF, PacketNullByte)
+
+	value16, data, err := DeserializeUint16(data, PacketNullByte)
+	assert.Nil(err)
+	assert.Equal(uint16(0xFFFF), value16)
+	value32, data, err := DeserializeUint32(data, PacketNullByte)
+	assert.Nil(err)
+	assert.Equal(uint32(0xFF00FF00), value32)
+	value64, data, err := DeserializeUint64(data, PacketNullByte)
+	assert.Nil(err)
+	assert.Equal(uint64(0xFFFFFFFFFFFFFFFF), value64)
+	assert.Empty(data)
+}
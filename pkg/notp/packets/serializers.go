@@ -21,6 +21,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"time"
 )
 
 // SplitData splits the data.
@@ -38,6 +39,23 @@ func SplitData[T any](data []byte, nullByte byte, expectedSize int) ([]byte, []b
 	return currentData, leftData, nil
 }
 
+// splitFixedData splits fixed size data terminated by the null byte, which may also occur inside the value.
+func splitFixedData(data []byte, nullByte byte, size int) ([]byte, []byte, error) {
+	if len(data) < size+1 || data[size] != nullByte {
+		return nil, nil, fmt.Errorf("invalid data: missing or invalid data")
+	}
+	return data[:size], data[size+1:], nil
+}
+
+// splitVariableData splits variable size data, which may be empty, terminated by the null byte.
+func splitVariableData(data []byte, nullByte byte) ([]byte, []byte, error) {
+	index := bytes.IndexByte(data, nullByte)
+	if index == -1 {
+		return nil, nil, fmt.Errorf("missing null byte")
+	}
+	return data[:index], data[index+1:], nil
+}
+
 // SerializeString serializes a string.
 func SerializeString(data []byte, value string, nullByte byte) []byte {
 	if data == nil {
@@ -49,11 +67,15 @@ func SerializeString(data []byte, value string, nullByte byte) []byte {
 
 // DeserializeString deserializes a string.
 func DeserializeString(data []byte, nullByte byte) (string, []byte, error) {
-	currentBuffer, leftBuffer, err := SplitData[string](data, PacketNullByte, math.MaxInt64)
+	currentBuffer, leftBuffer, err := splitVariableData(data, nullByte)
 	if err != nil {
 		return "", nil, fmt.Errorf("missing data for string")
 	}
-	return string(DecodeByteArray(currentBuffer)), leftBuffer, nil
+	value, err := decodeDataPayload(currentBuffer)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid data for string: %w", err)
+	}
+	return string(value), leftBuffer, nil
 }
 
 // SerializeBytes serializes bytes.
@@ -67,11 +89,15 @@ func SerializeBytes(data []byte, value []byte, nullByte byte) []byte {
 
 // DeserializeBytes deserializes a bytes.
 func DeserializeBytes(data []byte, nullByte byte) ([]byte, []byte, error) {
-	currentBuffer, leftBuffer, err := SplitData[[]byte](data, PacketNullByte, math.MaxInt64)
+	currentBuffer, leftBuffer, err := splitVariableData(data, nullByte)
 	if err != nil {
 		return nil, nil, fmt.Errorf("missing data for bytes")
 	}
-	return DecodeByteArray(currentBuffer), leftBuffer, nil
+	value, err := decodeDataPayload(currentBuffer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid data for bytes: %w", err)
+	}
+	return value, leftBuffer, nil
 }
 
 // SerializeBool serializes a bool.
@@ -91,10 +117,7 @@ func SerializeBool(data []byte, value bool, nullByte byte) []byte {
 
 // DeserializeBool deserializes a bool.
 func DeserializeBool(data []byte, nullByte byte) (bool, []byte, error) {
-	currentBuffer, leftBuffer, err := SplitData[bool](data, PacketNullByte, 1)
-	if len(currentBuffer) != 1 {
-		return false, nil, fmt.Errorf("missing data for bool")
-	}
+	currentBuffer, leftBuffer, err := splitFixedData(data, nullByte, 1)
 	if err != nil {
 		return false, nil, fmt.Errorf("missing data for bool")
 	}
@@ -115,10 +138,7 @@ func SerializeUint16(data []byte, value uint16, nullByte byte) []byte {
 
 // DeserializeUint16 deserializes a uint16.
 func DeserializeUint16(data []byte, nullByte byte) (uint16, []byte, error) {
-	currentBuffer, leftBuffer, err := SplitData[bool](data, PacketNullByte, 2)
-	if len(currentBuffer) != 2 {
-		return 0, nil, fmt.Errorf("missing data for uint16")
-	}
+	currentBuffer, leftBuffer, err := splitFixedData(data, nullByte, 2)
 	if err != nil {
 		return 0, nil, fmt.Errorf("missing data for uint16")
 	}
@@ -139,10 +159,7 @@ func SerializeUint32(data []byte, value uint32, nullByte byte) []byte {
 
 // DeserializeUint32 deserializes a uint32.
 func DeserializeUint32(data []byte, nullByte byte) (uint32, []byte, error) {
-	currentBuffer, leftBuffer, err := SplitData[bool](data, PacketNullByte, 4)
-	if len(currentBuffer) != 4 {
-		return 0, nil, fmt.Errorf("missing data for uint32")
-	}
+	currentBuffer, leftBuffer, err := splitFixedData(data, nullByte, 4)
 	if err != nil {
 		return 0, nil, fmt.Errorf("missing data for uint32")
 	}
@@ -163,12 +180,220 @@ func SerializeUint64(data []byte, value uint64, nullByte byte) []byte {
 
 // DeserializeUint64 deserializes a uint64.
 func DeserializeUint64(data []byte, nullByte byte) (uint64, []byte, error) {
-	currentBuffer, leftBuffer, err := SplitData[bool](data, PacketNullByte, 8)
-	if len(currentBuffer) != 8 {
-		return 0, nil, fmt.Errorf("missing data for uint64")
-	}
+	currentBuffer, leftBuffer, err := splitFixedData(data, nullByte, 8)
 	if err != nil {
 		return 0, nil, fmt.Errorf("missing data for uint64")
 	}
 	return binary.BigEndian.Uint64(currentBuffer), leftBuffer, nil
 }
+
+// SerializeInt8 serializes an int8.
+func SerializeInt8(data []byte, value int8, nullByte byte) []byte {
+	if data == nil {
+		data = make([]byte, 0)
+	}
+	data = append(data, byte(value))
+	return append(data, nullByte)
+}
+
+// DeserializeInt8 deserializes an int8.
+func DeserializeInt8(data []byte, nullByte byte) (int8, []byte, error) {
+	currentBuffer, leftBuffer, err := splitFixedData(data, nullByte, 1)
+	if err != nil {
+		return 0, nil, fmt.Errorf("missing data for int8")
+	}
+	return int8(currentBuffer[0]), leftBuffer, nil
+}
+
+// SerializeInt16 serializes an int16.
+func SerializeInt16(data []byte, value int16, nullByte byte) []byte {
+	return SerializeUint16(data, uint16(value), nullByte)
+}
+
+// DeserializeInt16 deserializes an int16.
+func DeserializeInt16(data []byte, nullByte byte) (int16, []byte, error) {
+	value, leftBuffer, err := DeserializeUint16(data, nullByte)
+	if err != nil {
+		return 0, nil, fmt.Errorf("missing data for int16")
+	}
+	return int16(value), leftBuffer, nil
+}
+
+// SerializeInt32 serializes an int32.
+func SerializeInt32(data []byte, value int32, nullByte byte) []byte {
+	return SerializeUint32(data, uint32(value), nullByte)
+}
+
+// DeserializeInt32 deserializes an int32.
+func DeserializeInt32(data []byte, nullByte byte) (int32, []byte, error) {
+	value, leftBuffer, err := DeserializeUint32(data, nullByte)
+	if err != nil {
+		return 0, nil, fmt.Errorf("missing data for int32")
+	}
+	return int32(value), leftBuffer, nil
+}
+
+// SerializeInt64 serializes an int64.
+func SerializeInt64(data []byte, value int64, nullByte byte) []byte {
+	return SerializeUint64(data, uint64(value), nullByte)
+}
+
+// DeserializeInt64 deserializes an int64.
+func DeserializeInt64(data []byte, nullByte byte) (int64, []byte, error) {
+	value, leftBuffer, err := DeserializeUint64(data, nullByte)
+	if err != nil {
+		return 0, nil, fmt.Errorf("missing data for int64")
+	}
+	return int64(value), leftBuffer, nil
+}
+
+// SerializeFloat32 serializes a float32.
+func SerializeFloat32(data []byte, value float32, nullByte byte) []byte {
+	return SerializeUint32(data, math.Float32bits(value), nullByte)
+}
+
+// DeserializeFloat32 deserializes a float32.
+func DeserializeFloat32(data []byte, nullByte byte) (float32, []byte, error) {
+	value, leftBuffer, err := DeserializeUint32(data, nullByte)
+	if err != nil {
+		return 0, nil, fmt.Errorf("missing data for float32")
+	}
+	return math.Float32frombits(value), leftBuffer, nil
+}
+
+// SerializeFloat64 serializes a float64.
+func SerializeFloat64(data []byte, value float64, nullByte byte) []byte {
+	return SerializeUint64(data, math.Float64bits(value), nullByte)
+}
+
+// DeserializeFloat64 deserializes a float64.
+func DeserializeFloat64(data []byte, nullByte byte) (float64, []byte, error) {
+	value, leftBuffer, err := DeserializeUint64(data, nullByte)
+	if err != nil {
+		return 0, nil, fmt.Errorf("missing data for float64")
+	}
+	return math.Float64frombits(value), leftBuffer, nil
+}
+
+// SerializeTime serializes a time as the seconds and the nanoseconds since the Unix epoch.
+func SerializeTime(data []byte, value time.Time, nullByte byte) []byte {
+	if data == nil {
+		data = make([]byte, 0)
+	}
+	data = binary.BigEndian.AppendUint64(data, uint64(value.Unix()))
+	data = binary.BigEndian.AppendUint32(data, uint32(value.Nanosecond()))
+	return append(data, nullByte)
+}
+
+// DeserializeTime deserializes a time in UTC.
+func DeserializeTime(data []byte, nullByte byte) (time.Time, []byte, error) {
+	currentBuffer, leftBuffer, err := splitFixedData(data, nullByte, 12)
+	if err != nil {
+		return time.Time{}, nil, fmt.Errorf("missing data for time")
+	}
+	seconds := int64(binary.BigEndian.Uint64(currentBuffer))
+	nanoseconds := binary.BigEndian.Uint32(currentBuffer[8:])
+	if nanoseconds >= uint32(time.Second) {
+		return time.Time{}, nil, fmt.Errorf("invalid data for time: nanoseconds out of range")
+	}
+	return time.Unix(seconds, int64(nanoseconds)).UTC(), leftBuffer, nil
+}
+
+// SerializeUUID serializes a UUID.
+func SerializeUUID(data []byte, value [16]byte, nullByte byte) []byte {
+	if data == nil {
+		data = make([]byte, 0)
+	}
+	data = append(data, value[:]...)
+	return append(data, nullByte)
+}
+
+// DeserializeUUID deserializes a UUID.
+func DeserializeUUID(data []byte, nullByte byte) ([16]byte, []byte, error) {
+	currentBuffer, leftBuffer, err := splitFixedData(data, nullByte, 16)
+	if err != nil {
+		return [16]byte{}, nil, fmt.Errorf("missing data for uuid")
+	}
+	return [16]byte(currentBuffer), leftBuffer, nil
+}
+
+// SerializeSlice serializes a slice as the number of values followed by the values serialized with the input function.
+func SerializeSlice[T any](data []byte, values []T, nullByte byte, serialize func([]byte, T, byte) []byte) []byte {
+	data = SerializeUint32(data, uint32(len(values)), nullByte)
+	for _, value := range values {
+		data = serialize(data, value, nullByte)
+	}
+	return data
+}
+
+// DeserializeSlice deserializes a slice with the input function.
+func DeserializeSlice[T any](data []byte, nullByte byte, deserialize func([]byte, byte) (T, []byte, error)) ([]T, []byte, error) {
+	size, leftBuffer, err := DeserializeUint32(data, nullByte)
+	if err != nil {
+		return nil, nil, fmt.Errorf("missing data for slice")
+	}
+	if uint64(size) > uint64(len(leftBuffer)) {
+		return nil, nil, fmt.Errorf("invalid data for slice: %d values exceed the remaining %d bytes", size, len(leftBuffer))
+	}
+	values := make([]T, 0, size)
+	for range size {
+		var value T
+		value, leftBuffer, err = deserialize(leftBuffer, nullByte)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid data for slice: %w", err)
+		}
+		values = append(values, value)
+	}
+	return values, leftBuffer, nil
+}
+
+// SerializeDelimitedBytes serializes raw bytes prefixed by their length.
+func SerializeDelimitedBytes(data []byte, value []byte, nullByte byte) ([]byte, error) {
+	if uint64(len(value)) > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid data for delimited bytes: %d bytes are too many", len(value))
+	}
+	data = SerializeUint32(data, uint32(len(value)), nullByte)
+	data = append(data, value...)
+	return append(data, nullByte), nil
+}
+
+// DeserializeDelimitedBytes deserializes raw bytes prefixed by their length.
+func DeserializeDelimitedBytes(data []byte, nullByte byte) ([]byte, []byte, error) {
+	size, leftBuffer, err := DeserializeUint32(data, nullByte)
+	if err != nil || uint64(size) >= uint64(len(leftBuffer)) {
+		return nil, nil, fmt.Errorf("missing data for delimited bytes")
+	}
+	currentBuffer, leftBuffer, err := splitFixedData(leftBuffer, nullByte, int(size))
+	if err != nil {
+		return nil, nil, fmt.Errorf("missing data for delimited bytes")
+	}
+	return currentBuffer, leftBuffer, nil
+}
+
+// SerializePacketable serializes a nested packet prefixed by its length.
+func SerializePacketable(data []byte, value Packetable, nullByte byte) ([]byte, error) {
+	if value == nil {
+		return nil, fmt.Errorf("invalid data for packetable: nil packet")
+	}
+	payload, err := value.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("invalid data for packetable: %w", err)
+	}
+	data, err = SerializeDelimitedBytes(data, payload, nullByte)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data for packetable: %w", err)
+	}
+	return data, nil
+}
+
+// DeserializePacketable deserializes a nested packet into the input value.
+func DeserializePacketable(data []byte, value Packetable, nullByte byte) ([]byte, error) {
+	currentBuffer, leftBuffer, err := DeserializeDelimitedBytes(data, nullByte)
+	if err != nil {
+		return nil, fmt.Errorf("missing data for packetable")
+	}
+	if err = value.Deserialize(currentBuffer); err != nil {
+		return nil, fmt.Errorf("invalid data for packetable: %w", err)
+	}
+	return leftBuffer, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSignedAndFloatSerializers tests the round trip of the signed integers and floats.
+func TestSignedAndFloatSerializers(t *testing.T) {
+	assert := assert.New(t)
+
+	data := SerializeInt8(nil, math.MinInt8, PacketNullByte)
+	data = SerializeInt8(data, -1, PacketNullByte)
+	data = SerializeInt16(data, math.MinInt16, PacketNullByte)
+	data = SerializeInt32(data, -123456, PacketNullByte)
+	data = SerializeInt64(data, math.MaxInt64, PacketNullByte)
+	data = SerializeFloat32(data, -3.25, PacketNullByte)
+	data = SerializeFloat64(data, math.Inf(-1), PacketNullByte)
+	data = SerializeFloat64(data, math.SmallestNonzeroFloat64, PacketNullByte)
+
+	value8, data, err := DeserializeInt8(data, PacketNullByte)
+	assert.Nil(err)
+	assert.Equal(int8(math.MinInt8), value8)
+	value8, data, err = DeserializeInt8(data, PacketNullByte)
+	assert.Nil(err)
+	assert.Equal(int8(-1), value8)
+	value16, data, err := DeserializeInt16(data, PacketNullByte)
+	assert.Nil(err)
+	assert.Equal(int16(math.MinInt16), value16)
+	value32, data, err := DeserializeInt32(data, PacketNullByte)
+	assert.Nil(err)
+	assert.Equal(int32(-123456), value32)
+	value64, data, err := DeserializeInt64(data, PacketNullByte)
+	assert.Nil(err)
+	assert.Equal(int64(math.MaxInt64), value64)
+	float32Value, data, err := DeserializeFloat32(data, PacketNullByte)
+	assert.Nil(err)
+	assert.Equal(float32(-3.25), float32Value)
+	float64Value, data, err := DeserializeFloat64(data, PacketNullByte)
+	assert.Nil(err)
+	assert.True(math.IsInf(float64Value, -1))
+	float64Value, data, err = DeserializeFloat64(data, PacketNullByte)
+	assert.Nil(err)
+	assert.Equal(math.SmallestNonzeroFloat64, float64Value)
+	assert.Empty(data)
+
+	_, _, err = DeserializeInt32([]byte{0x01, 0x02}, PacketNullByte)
+	assert.NotNil(err)
+}
+
+// TestTimeAndUUIDSerializers tests the round trip of times and UUIDs.
+func TestTimeAndUUIDSerializers(t *testing.T) {
+	assert := assert.New(t)
+
+	location := time.FixedZone("CET", 3600)
+	inTime := time.Date(2024, time.March, 10, 12, 30, 45, 123456789, location)
+	inUUID := [16]byte{0xFF, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0xFF}
+	data := SerializeTime(nil, inTime, PacketNullByte)
+	data = SerializeTime(data, time.Time{}, PacketNullByte)
+	data = SerializeUUID(data, inUUID, PacketNullByte)
+
+	outTime, data, err := DeserializeTime(data, PacketNullByte)
+	assert.Nil(err)
+	assert.True(inTime.Equal(outTime))
+	assert.Equal(time.UTC, outTime.Location())
+	zeroTime, data, err := DeserializeTime(data, PacketNullByte)
+	assert.Nil(err)
+	assert.True(zeroTime.IsZero())
+	outUUID, data, err := DeserializeUUID(data, PacketNullByte)
+	assert.Nil(err)
+	assert.Equal(inUUID, outUUID)
+	assert.Empty(data)
+
+	invalidTime := SerializeTime(nil, inTime, PacketNullByte)
+	invalidTime[8] = 0xFF
+	_, _, err = DeserializeTime(invalidTime, PacketNullByte)
+	assert.NotNil(err)
+}
+
+// TestSliceAndPacketableSerializers tests the round trip of slices and nested packets.
+func TestSliceAndPacketableSerializers(t *testing.T) {
+	assert := assert.New(t)
+
+	inValues := []string{"first", "", "third"}
+	inProtocol := &ProtocolPacket{Version: ProtocolVersion2, MinVersion: ProtocolVersion1, MaxVersion: ProtocolVersion2}
+	data := SerializeSlice(nil, inValues, PacketNullByte, SerializeString)
+	data = SerializeSlice(data, []int64{}, PacketNullByte, SerializeInt64)
+	data, err := SerializePacketable(data, inProtocol, PacketNullByte)
+	assert.Nil(err)
+	data = SerializeBool(data, true, PacketNullByte)
+
+	outValues, data, err := DeserializeSlice(data, PacketNullByte, DeserializeString)
+	assert.Nil(err)
+	assert.Equal(inValues, outValues)
+	emptyValues, data, err := DeserializeSlice(data, PacketNullByte, DeserializeInt64)
+	assert.Nil(err)
+	assert.Empty(emptyValues)
+	outProtocol := &ProtocolPacket{}
+	data, err = DeserializePacketable(data, outProtocol, PacketNullByte)
+	assert.Nil(err)
+	assert.Equal(*inProtocol, *outProtocol)
+	value, data, err := DeserializeBool(data, PacketNullByte)
+	assert.Nil(err)
+	assert.True(value)
+	assert.Empty(data)
+
+	oversizedSlice := SerializeUint32(nil, math.MaxUint32, PacketNullByte)
+	_, _, err = DeserializeSlice(oversizedSlice, PacketNullByte, DeserializeUint64)
+	assert.NotNil(err)
+	truncatedPacketable := SerializeUint32(nil, 100, PacketNullByte)
+	_, err = DeserializePacketable(append(truncatedPacketable, 0x01, PacketNullByte), &Packet{}, PacketNullByte)
+	assert.NotNil(err)
+	_, err = SerializePacketable(nil, nil, PacketNullByte)
+	assert.NotNil(err)
+}
@@ -20,7 +20,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/binary"
-	"errors"
+	"fmt"
 	"unsafe"
 )
 
@@ -73,71 +73,126 @@ func writeDataPacket(data []byte, packetType uint64, payload []byte) ([]byte, er
 	return writeStreamDataPacket(data, packetType, nil, payload)
 }
 
-// indexDataStreamPacket indexes a stream data packet in the buffer.
-func indexDataStreamPacket(offset int, data []byte) (int, int, uint64, uint64, error) {
-	data = data[offset:]
-	delimiterIndex := bytes.IndexByte(data, PacketNullByte)
-	if delimiterIndex == -1 {
-		return -1, -1, 0, 0, errors.New("notp: delimiter not found")
-	}
-	headerData := data[:delimiterIndex]
+// readUint64Header reads a header made of big endian uint64 values from the buffer.
+func readUint64Header(offset int, data []byte, count int) (int, []uint64, error) {
 	idSize := int(unsafe.Sizeof(uint64(0)))
-	if len(headerData) != idSize*3 {
-		return -1, -1, 0, 0, errors.New("notp: invalid data: missing or invalid header")
-	}
-	dataOffset := delimiterIndex + 1
-	values := []uint64{0, 0, 0}
-	for count := range values {
-		start := idSize * count
-		end := (idSize * count) + idSize
-		values[count] = uint64(binary.BigEndian.Uint64(headerData[start:end]))
-	}
-	packetStream := values[0]
-	packetType := values[1]
-	size := int(values[2])
-	return offset + dataOffset, size, packetType, packetStream, nil
+	if offset < 0 || offset > len(data) || len(data)-offset < idSize*count {
+		return -1, nil, fmt.Errorf("%w: missing header", ErrTruncated)
+	}
+	values := make([]uint64, count)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint64(data[offset+idSize*i:])
+	}
+	return offset + idSize*count, values, nil
+}
+
+// readDelimitedHeader reads a header made of big endian uint64 values terminated by the null byte from the buffer.
+func readDelimitedHeader(offset int, data []byte, count int) (int, []uint64, error) {
+	offset, values, err := readUint64Header(offset, data, count)
+	if err != nil {
+		return -1, nil, err
+	}
+	if offset >= len(data) {
+		return -1, nil, fmt.Errorf("%w: delimiter not found", ErrTruncated)
+	}
+	if data[offset] != PacketNullByte {
+		return -1, nil, fmt.Errorf("%w: delimiter not found", ErrBadHeader)
+	}
+	return offset + 1, values, nil
+}
+
+// readPayload reads a payload of the given size from the buffer.
+func readPayload(offset int, data []byte, size uint64) ([]byte, error) {
+	if size > uint64(len(data)-offset) {
+		return nil, fmt.Errorf("%w: payload of %d bytes exceeds the remaining %d bytes", ErrTruncated, size, len(data)-offset)
+	}
+	return data[offset : offset+int(size)], nil
 }
 
 // readStreamDataPacket reads a stream data packet from the buffer.
 func readStreamDataPacket(offset int, data []byte) ([]byte, int, int, uint64, uint64, error) {
-	offset, size, packetType, packetStream, err := indexDataStreamPacket(offset, data)
+	offset, values, err := readDelimitedHeader(offset, data, 3)
 	if err != nil {
 		return nil, -1, -1, 0, 0, err
 	}
-	payload := data[offset : offset+size]
-	return payload, offset, size, packetType, packetStream, nil
-}
-
-// indexDataPacket indexes a data packet in the buffer.
-func indexDataPacket(offset int, data []byte) (int, int, uint64, error) {
-	data = data[offset:]
-	delimiterIndex := bytes.IndexByte(data, PacketNullByte)
-	if delimiterIndex == -1 {
-		return -1, -1, 0, errors.New("notp: delimiter not found")
+	payload, err := readPayload(offset, data, values[2])
+	if err != nil {
+		return nil, -1, -1, 0, 0, err
 	}
-	headerData := data[:delimiterIndex]
-	idSize := int(unsafe.Sizeof(uint64(0)))
-	if len(headerData) != idSize*2 {
-		return -1, -1, 0, errors.New("notp: invalid data: missing or invalid header")
-	}
-	dataOffset := delimiterIndex + 1
-	values := []uint64{0, 0}
-	for count := range values {
-		start := idSize * count
-		end := (idSize * count) + idSize
-		values[count] = uint64(binary.BigEndian.Uint64(headerData[start:end]))
-	}
-	packetType := values[0]
-	size := int(values[1])
-	return offset + dataOffset, size, packetType, nil
+	return payload, offset, len(payload), values[1], values[0], nil
 }
 
 // readDataPacket reads a data packet from the buffer.
 func readDataPacket(offset int, data []byte) ([]byte, int, int, uint64, error) {
-	offset, size, packetType, err := indexDataPacket(offset, data)
+	offset, values, err := readDelimitedHeader(offset, data, 2)
 	if err != nil {
 		return nil, -1, -1, 0, err
 	}
-	payload := data[offset : offset+size]
-	return payload, offset, size, packetType, nil
+	payload, err := readPayload(offset, data, values[1])
+	if err != nil {
+		return nil, -1, -1, 0, err
+	}
+	return payload, offset, len(payload), values[0], nil
+}
+
+// decodeDataPayload decodes a base64 encoded data payload.
+func decodeDataPayload(payload []byte) ([]byte, error) {
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(payload)))
+	size, err := base64.StdEncoding.Decode(data, payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadPayload, err)
+	}
+	return data[:size], nil
+}
+
+// writeFramedStreamSize writes the stream size prefix of a length-prefixed stream to the buffer.
+func writeFramedStreamSize(data []byte, packetStream uint64) []byte {
+	return binary.BigEndian.AppendUint64(data, packetStream)
+}
+
+// writeFramedDataPacket writes a length-prefixed data packet, followed by its checksum if requested, to the buffer.
+func writeFramedDataPacket(data []byte, packetType uint64, payload []byte, hasChecksum bool) []byte {
+	start := len(data)
+	data = binary.BigEndian.AppendUint64(data, packetType)
+	data = binary.BigEndian.AppendUint64(data, uint64(len(payload)))
+	data = append(data, payload...)
+	if hasChecksum {
+		data = appendChecksum(data, start)
+	}
+	return data
+}
+
+// readFramedStreamSize reads the stream size prefix of a length-prefixed stream from the buffer.
+func readFramedStreamSize(offset int, data []byte) (int, uint64, error) {
+	offset, values, err := readUint64Header(offset, data, 1)
+	if err != nil {
+		return -1, 0, err
+	}
+	return offset, values[0], nil
+}
+
+// readFramedDataPacket reads a length-prefixed data packet, verifying its checksum if present, from the buffer.
+func readFramedDataPacket(offset int, data []byte, hasChecksum bool) ([]byte, int, int, uint64, error) {
+	start := offset
+	offset, values, err := readUint64Header(offset, data, 2)
+	if err != nil {
+		return nil, -1, -1, 0, err
+	}
+	payload, err := readPayload(offset, data, values[1])
+	if err != nil {
+		return nil, -1, -1, 0, err
+	}
+	size := len(payload)
+	if hasChecksum {
+		end := offset + size
+		checksum, err := readPayload(end, data, checksumSize)
+		if err != nil {
+			return nil, -1, -1, 0, err
+		}
+		if err = verifyChecksum(data[start:end], checksum); err != nil {
+			return nil, -1, -1, 0, err
+		}
+		size += checksumSize
+	}
+	return payload, offset, size, values[0], nil
 }
@@ -0,0 +1,250 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// checksumReader is a reader computing the checksums of the consumed data.
+type checksumReader struct {
+	reader         *bufio.Reader
+	packetChecksum uint32
+	dataChecksum   uint32
+}
+
+// Read reads data updating the checksums.
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.update(p[:n])
+	return n, err
+}
+
+// ReadByte reads a byte updating the checksums.
+func (r *checksumReader) ReadByte() (byte, error) {
+	b, err := r.reader.ReadByte()
+	if err == nil {
+		r.update([]byte{b})
+	}
+	return b, err
+}
+
+// update updates the checksums with the consumed data.
+func (r *checksumReader) update(data []byte) {
+	r.packetChecksum = crc32.Update(r.packetChecksum, checksumTable, data)
+	r.dataChecksum = crc32.Update(r.dataChecksum, checksumTable, data)
+}
+
+// PacketStreamReader is a reader of packets from the NOTP protocol decoding incrementally from an io.Reader.
+type PacketStreamReader struct {
+	reader   *checksumReader
+	protocol *ProtocolPacket
+	limits   PacketReaderLimits
+}
+
+// NewPacketStreamReader creates a new packet stream reader.
+func NewPacketStreamReader(reader io.Reader) (*PacketStreamReader, error) {
+	return NewPacketStreamReaderWithLimits(reader, PacketReaderLimits{})
+}
+
+// NewPacketStreamReaderWithLimits creates a new packet stream reader enforcing the input limits.
+func NewPacketStreamReaderWithLimits(reader io.Reader, limits PacketReaderLimits) (*PacketStreamReader, error) {
+	if reader == nil {
+		return nil, errors.New("notp: nil reader")
+	}
+	return &PacketStreamReader{
+		reader: &checksumReader{reader: bufio.NewReader(reader)},
+		limits: limits,
+	}, nil
+}
+
+// ReadProtocol read a protocol packet.
+func (r *PacketStreamReader) ReadProtocol() (*ProtocolPacket, error) {
+	if r.protocol != nil {
+		return nil, errors.New("notp: protocol packet already read")
+	}
+	values, err := r.readDelimitedHeader(2)
+	if err != nil {
+		return nil, err
+	}
+	protocol := &ProtocolPacket{}
+	if values[0] != protocol.GetType() {
+		return nil, fmt.Errorf("%w: unexpected packet type %d", ErrMissingProtocol, values[0])
+	}
+	payload, err := r.readPayload(values[1])
+	if err != nil {
+		return nil, err
+	}
+	if err = protocol.Deserialize(payload); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadHeader, err)
+	}
+	if err = protocol.validate(); err != nil {
+		return nil, err
+	}
+	r.protocol = protocol
+	return protocol, nil
+}
+
+// ReadNextDataPacket read next data packet.
+func (r *PacketStreamReader) ReadNextDataPacket(state *DataPacketState) ([]byte, *DataPacketState, error) {
+	if state != nil && state.IsComplete() {
+		return nil, state, ErrStreamComplete
+	}
+	if r.protocol == nil {
+		if _, err := r.ReadProtocol(); err != nil {
+			return nil, state, err
+		}
+	}
+	if state == nil {
+		state = &DataPacketState{}
+		header, err := r.readUint64s(1)
+		if err != nil {
+			return nil, nil, err
+		}
+		state.packetStreamSize = header[0]
+		if err = r.limits.checkStreamSize(state.packetStreamSize); err != nil {
+			return nil, nil, err
+		}
+		payload, packetType, err := r.readDataPacket()
+		if err != nil {
+			return nil, nil, err
+		}
+		state.packetType = packetType
+		state.size = len(payload)
+		return payload, state, r.verifyPacketChecksum(state)
+	}
+	payload, packetType, err := r.readDataPacket()
+	if err != nil {
+		return nil, state, err
+	}
+	state.packetType = packetType
+	state.size = len(payload)
+	state.packetStreamIndex++
+	return payload, state, r.verifyPacketChecksum(state)
+}
+
+// readDataPacket reads the header and the payload of a data packet, verifying its checksum if present.
+func (r *PacketStreamReader) readDataPacket() ([]byte, uint64, error) {
+	r.reader.dataChecksum = 0
+	var header []uint64
+	var err error
+	if r.protocol.HasLengthPrefixedFraming() {
+		header, err = r.readUint64s(2)
+	} else {
+		header, err = r.readDelimitedHeader(2)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	payload, err := r.readDataPayload(header[1])
+	if err != nil {
+		return nil, 0, err
+	}
+	if r.protocol.HasDataChecksum() {
+		if err = r.verifyChecksum(r.reader.dataChecksum); err != nil {
+			return nil, 0, err
+		}
+	}
+	return payload, header[0], nil
+}
+
+// verifyPacketChecksum verifies the checksum of the whole packet, if present, once the data stream is complete.
+func (r *PacketStreamReader) verifyPacketChecksum(state *DataPacketState) error {
+	if !r.protocol.HasPacketChecksum() || !state.IsComplete() {
+		return nil
+	}
+	return r.verifyChecksum(r.reader.packetChecksum)
+}
+
+// verifyChecksum reads a checksum and verifies it against the computed one.
+func (r *PacketStreamReader) verifyChecksum(computed uint32) error {
+	checksum := make([]byte, checksumSize)
+	if _, err := io.ReadFull(r.reader.reader, checksum); err != nil {
+		return fmt.Errorf("%w: missing checksum: %w", ErrTruncated, err)
+	}
+	r.reader.update(checksum)
+	if expected := binary.BigEndian.Uint32(checksum); expected != computed {
+		return fmt.Errorf("%w: expected %08x, computed %08x", ErrChecksumMismatch, expected, computed)
+	}
+	return nil
+}
+
+// readUint64s reads big endian uint64 values.
+func (r *PacketStreamReader) readUint64s(count int) ([]uint64, error) {
+	buffer := make([]byte, 8*count)
+	if _, err := io.ReadFull(r.reader, buffer); err != nil {
+		return nil, fmt.Errorf("%w: missing header: %w", ErrTruncated, err)
+	}
+	values := make([]uint64, count)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint64(buffer[i*8:])
+	}
+	return values, nil
+}
+
+// readDelimitedHeader reads big endian uint64 values terminated by the null byte.
+func (r *PacketStreamReader) readDelimitedHeader(count int) ([]uint64, error) {
+	values, err := r.readUint64s(count)
+	if err != nil {
+		return nil, err
+	}
+	delimiter, err := r.reader.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("%w: delimiter not found: %w", ErrTruncated, err)
+	}
+	if delimiter != PacketNullByte {
+		return nil, fmt.Errorf("%w: delimiter not found", ErrBadHeader)
+	}
+	return values, nil
+}
+
+// readPayload reads a payload of the given size growing the buffer only as the data arrives.
+func (r *PacketStreamReader) readPayload(size uint64) ([]byte, error) {
+	if size > math.MaxInt64 {
+		return nil, fmt.Errorf("%w: payload of %d bytes", ErrOversized, size)
+	}
+	var buffer bytes.Buffer
+	if _, err := io.CopyN(&buffer, r.reader, int64(size)); err != nil {
+		return nil, fmt.Errorf("%w: payload of %d bytes: %w", ErrTruncated, size, err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// readDataPayload reads a data payload enforcing the limits and decoding it for the protocol wire format.
+func (r *PacketStreamReader) readDataPayload(size uint64) ([]byte, error) {
+	if !r.protocol.HasLengthPrefixedFraming() {
+		if err := r.limits.checkEncodedPayloadSize(size); err != nil {
+			return nil, err
+		}
+		payload, err := r.readPayload(size)
+		if err != nil {
+			return nil, err
+		}
+		return r.limits.decodePayload(payload)
+	}
+	if err := r.limits.checkPayloadSize(size); err != nil {
+		return nil, err
+	}
+	return r.readPayload(size)
+}
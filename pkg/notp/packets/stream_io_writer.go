@@ -0,0 +1,132 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// PacketStreamWriter is a writer of packets from the NOTP protocol encoding directly into an io.Writer.
+type PacketStreamWriter struct {
+	writer      io.Writer
+	protocol    *ProtocolPacket
+	streamSize  uint64
+	streamIndex uint64
+	buffer      []byte
+	checksum    uint32
+}
+
+// NewPacketStreamWriter creates a new packet stream writer.
+func NewPacketStreamWriter(writer io.Writer) (*PacketStreamWriter, error) {
+	if writer == nil {
+		return nil, errors.New("notp: nil writer")
+	}
+	return &PacketStreamWriter{
+		writer: writer,
+	}, nil
+}
+
+// WriteProtocol write a protocol packet.
+func (w *PacketStreamWriter) WriteProtocol(protocol *ProtocolPacket) error {
+	if protocol == nil {
+		return errors.New("notp: nil protocol packet")
+	}
+	if w.protocol != nil {
+		return errors.New("notp: protocol packet already written")
+	}
+	if err := protocol.validate(); err != nil {
+		return err
+	}
+	data, err := protocol.Serialize()
+	if err != nil {
+		return err
+	}
+	if w.buffer, err = writeDataPacket(w.buffer[:0], protocol.GetType(), data); err != nil {
+		return err
+	}
+	if err = w.write(w.buffer); err != nil {
+		return err
+	}
+	w.protocol = protocol
+	return nil
+}
+
+// BeginDataStream declares the number of data packets which are going to be written.
+func (w *PacketStreamWriter) BeginDataStream(streamSize uint64) error {
+	if w.protocol == nil {
+		return errors.New("notp: missing protocol packet")
+	}
+	if w.streamSize > 0 {
+		return errors.New("notp: data stream already started")
+	}
+	if streamSize == 0 {
+		return errors.New("notp: empty data stream")
+	}
+	w.streamSize = streamSize
+	return nil
+}
+
+// WriteDataPacket writes the next data packet of the data stream.
+func (w *PacketStreamWriter) WriteDataPacket(packet Packetable) error {
+	if packet == nil {
+		return errors.New("notp: nil data packet")
+	}
+	if w.streamSize == 0 {
+		return errors.New("notp: data stream not started")
+	}
+	if w.streamIndex == w.streamSize {
+		return errors.New("notp: data stream already complete")
+	}
+	data, err := packet.Serialize()
+	if err != nil {
+		return err
+	}
+	if w.streamIndex == 0 {
+		w.buffer, err = writeFirstDataPacket(w.buffer[:0], w.protocol, packet.GetType(), w.streamSize, data)
+	} else {
+		w.buffer, err = writeNextDataPacket(w.buffer[:0], w.protocol, packet.GetType(), data)
+	}
+	if err != nil {
+		return err
+	}
+	if err = w.write(w.buffer); err != nil {
+		return err
+	}
+	w.streamIndex++
+	if w.IsComplete() && w.protocol.HasPacketChecksum() {
+		w.buffer = binary.BigEndian.AppendUint32(w.buffer[:0], w.checksum)
+		if _, err = w.writer.Write(w.buffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes the data updating the checksum of the whole packet.
+func (w *PacketStreamWriter) write(data []byte) error {
+	w.checksum = crc32.Update(w.checksum, checksumTable, data)
+	_, err := w.writer.Write(data)
+	return err
+}
+
+// IsComplete returns true if all the data packets of the data stream have been written.
+func (w *PacketStreamWriter) IsComplete() bool {
+	return w.streamSize > 0 && w.streamIndex == w.streamSize
+}
@@ -18,15 +18,23 @@ package packets
 
 import (
 	"errors"
+	"fmt"
 )
 
 // PacketReader is a readr of packets from the NOTP protocol.
 type PacketReader struct {
-	packet *Packet
+	packet   *Packet
+	protocol *ProtocolPacket
+	limits   PacketReaderLimits
 }
 
 // NewPacketReader creates a new packet readr.
 func NewPacketReader(packet *Packet) (*PacketReader, error) {
+	return NewPacketReaderWithLimits(packet, PacketReaderLimits{})
+}
+
+// NewPacketReaderWithLimits creates a new packet reader enforcing the input limits.
+func NewPacketReaderWithLimits(packet *Packet, limits PacketReaderLimits) (*PacketReader, error) {
 	if packet == nil {
 		return nil, errors.New("notp: nil packet")
 	}
@@ -35,25 +43,47 @@ func NewPacketReader(packet *Packet) (*PacketReader, error) {
 	}
 	return &PacketReader{
 		packet: packet,
+		limits: limits,
 	}, nil
 }
 
 // ReadProtocol read a protocol packet.
 func (w *PacketReader) ReadProtocol() (*ProtocolPacket, error) {
+	protocol, _, err := w.readProtocol()
+	if err != nil {
+		return nil, err
+	}
+	return protocol, nil
+}
+
+// ReadProtocolHeader read a protocol packet and returns the size of the protocol header preceding the data stream.
+func (w *PacketReader) ReadProtocolHeader() (*ProtocolPacket, int, error) {
+	return w.readProtocol()
+}
+
+// readProtocol read a protocol packet and returns the offset of the data packets.
+func (w *PacketReader) readProtocol() (*ProtocolPacket, int, error) {
 	data := w.packet.Data
 	if len(data) == 0 {
-		return nil, errors.New("notp: missing protocol packet")
+		return nil, -1, ErrMissingProtocol
 	}
-	payload, _, _, _, err := readDataPacket(0, data)
+	payload, offset, size, packetType, err := readDataPacket(0, data)
 	if err != nil {
-		return nil, err
+		return nil, -1, err
 	}
 	protocol := &ProtocolPacket{}
+	if packetType != protocol.GetType() {
+		return nil, -1, fmt.Errorf("%w: unexpected packet type %d", ErrMissingProtocol, packetType)
+	}
 	err = protocol.Deserialize(payload)
 	if err != nil {
-		return nil, err
+		return nil, -1, fmt.Errorf("%w: %w", ErrBadHeader, err)
 	}
-	return protocol, nil
+	if err = protocol.validate(); err != nil {
+		return nil, -1, err
+	}
+	w.protocol = protocol
+	return protocol, offset + size, nil
 }
 
 // DataPacketState is the state of a data packet.
@@ -70,6 +100,11 @@ func (p *DataPacketState) GetPacketType() uint64 {
 	return p.packetType
 }
 
+// GetStreamSize returns the number of data packets of the data stream.
+func (p *DataPacketState) GetStreamSize() uint64 {
+	return p.packetStreamSize
+}
+
 // IsComplete returns true if the data packet is complete.
 func (p *DataPacketState) IsComplete() bool {
 	return p.packetStreamSize-1 == p.packetStreamIndex
@@ -78,18 +113,40 @@ func (p *DataPacketState) IsComplete() bool {
 // ReadNextDataPacket read next data packet.
 func (w *PacketReader) ReadNextDataPacket(state *DataPacketState) ([]byte, *DataPacketState, error) {
 	if state != nil && state.IsComplete() {
-		return nil, state, errors.New("notp: data packet already complete")
+		return nil, state, ErrStreamComplete
 	}
 	data := w.packet.Data
-	if len(data) == 0 {
-		return nil, state, errors.New("notp: missing protocol packet")
-	}
 	if state == nil {
-		offset, size, _, err := indexDataPacket(0, data)
+		protocol, offset, err := w.readProtocol()
+		if err != nil {
+			return nil, state, err
+		}
+		if err = w.verifyPacketChecksum(); err != nil {
+			return nil, state, err
+		}
+		data = w.getDataStream()
+		var payload []byte
+		var size int
+		var packetType, packetStreamSize uint64
+		if protocol.HasLengthPrefixedFraming() {
+			offset, packetStreamSize, err = readFramedStreamSize(offset, data)
+			if err == nil {
+				err = w.limits.checkStreamSize(packetStreamSize)
+			}
+			if err != nil {
+				return nil, state, err
+			}
+			payload, offset, size, packetType, err = readFramedDataPacket(offset, data, w.protocol.HasDataChecksum())
+		} else {
+			payload, offset, size, packetType, packetStreamSize, err = readStreamDataPacket(offset, data)
+			if err == nil {
+				err = w.limits.checkStreamSize(packetStreamSize)
+			}
+		}
 		if err != nil {
 			return nil, state, err
 		}
-		data, offset, size, packetType, packetStreamSize, err := readStreamDataPacket(offset+size, data)
+		payload, err = w.decodePayload(payload)
 		if err != nil {
 			return nil, state, err
 		}
@@ -100,10 +157,26 @@ func (w *PacketReader) ReadNextDataPacket(state *DataPacketState) ([]byte, *Data
 			packetStreamSize:  packetStreamSize,
 			packetStreamIndex: uint64(0),
 		}
-		return DecodeByteArray(data), state, nil
+		return payload, state, nil
 	}
+	if w.protocol == nil {
+		return nil, state, ErrMissingProtocol
+	}
+	data = w.getDataStream()
 	offset := state.offeset + state.size
-	payload, offset, size, packetType, err := readDataPacket(offset, data)
+	var payload []byte
+	var size int
+	var packetType uint64
+	var err error
+	if w.protocol.HasLengthPrefixedFraming() {
+		payload, offset, size, packetType, err = readFramedDataPacket(offset, data, w.protocol.HasDataChecksum())
+	} else {
+		payload, offset, size, packetType, err = readDataPacket(offset, data)
+	}
+	if err != nil {
+		return nil, state, err
+	}
+	payload, err = w.decodePayload(payload)
 	if err != nil {
 		return nil, state, err
 	}
@@ -111,5 +184,37 @@ func (w *PacketReader) ReadNextDataPacket(state *DataPacketState) ([]byte, *Data
 	state.packetType = packetType
 	state.size = size
 	state.packetStreamIndex++
-	return DecodeByteArray(payload), state, nil
+	return payload, state, nil
+}
+
+// verifyPacketChecksum verifies the checksum of the whole packet if present.
+func (w *PacketReader) verifyPacketChecksum() error {
+	if !w.protocol.HasPacketChecksum() {
+		return nil
+	}
+	data := w.packet.Data
+	if len(data) < checksumSize {
+		return fmt.Errorf("%w: missing packet checksum", ErrTruncated)
+	}
+	return verifyChecksum(data[:len(data)-checksumSize], data[len(data)-checksumSize:])
+}
+
+// getDataStream returns the data of the packet excluding the checksum of the whole packet.
+func (w *PacketReader) getDataStream() []byte {
+	data := w.packet.Data
+	if w.protocol.HasPacketChecksum() && len(data) >= checksumSize {
+		return data[:len(data)-checksumSize]
+	}
+	return data
+}
+
+// decodePayload checks the payload against the limits and decodes it for the protocol wire format.
+func (w *PacketReader) decodePayload(payload []byte) ([]byte, error) {
+	if !w.protocol.HasLengthPrefixedFraming() {
+		return w.limits.decodePayload(payload)
+	}
+	if err := w.limits.checkPayloadSize(uint64(len(payload))); err != nil {
+		return nil, err
+	}
+	return payload, nil
 }
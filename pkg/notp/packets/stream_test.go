@@ -17,6 +17,8 @@
 package packets
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -119,3 +121,254 @@ func TestPacketWriterAndReader(t *testing.T) {
 	assert.NotNil(state)
 	assert.NotNil(err)
 }
+
+// TestPacketWriterAndReaderWithVersions tests the packet writer and reader with all the wire formats.
+func TestPacketWriterAndReaderWithVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint32
+	}{
+		{name: "Version1", version: ProtocolVersion1},
+		{name: "Version2", version: ProtocolVersion2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			packet := &Packet{}
+			writer, err := NewPacketWriter(packet)
+			assert.Nil(err)
+			err = writer.WriteProtocol(&ProtocolPacket{Version: test.version})
+			assert.Nil(err)
+
+			inData := []*Packet{
+				{Data: []byte{0xFF, 0x00, 0xFF}},
+				{Data: []byte("sample data")},
+				{Data: []byte{}},
+			}
+			for _, in := range inData {
+				assert.Nil(writer.AppendDataPacket(in))
+			}
+
+			reader, err := NewPacketReader(packet)
+			assert.Nil(err)
+			outProtocol, err := reader.ReadProtocol()
+			assert.Nil(err)
+			assert.Equal(test.version, outProtocol.Version)
+
+			var state *DataPacketState
+			for i, in := range inData {
+				var data []byte
+				data, state, err = reader.ReadNextDataPacket(state)
+				assert.Nil(err)
+				assert.Equal(in.GetType(), state.GetPacketType())
+				assert.Equal(uint64(len(inData)), state.packetStreamSize)
+				assert.Equal(uint64(i), state.packetStreamIndex)
+				assert.Equal(in.Data, data)
+			}
+			assert.True(state.IsComplete())
+		})
+	}
+}
+
+// TestPacketWriterWithLengthPrefixedFraming tests that the length-prefixed wire format does not encode the payloads.
+func TestPacketWriterWithLengthPrefixedFraming(t *testing.T) {
+	assert := assert.New(t)
+
+	payload := make([]byte, 300)
+	sizes := map[uint32]int{}
+	for _, version := range []uint32{ProtocolVersion1, ProtocolVersion2} {
+		packet := &Packet{}
+		writer, err := NewPacketWriter(packet)
+		assert.Nil(err)
+		assert.Nil(writer.WriteProtocol(&ProtocolPacket{Version: version}))
+		assert.Nil(writer.AppendDataPacket(&Packet{Data: payload}))
+		sizes[version] = len(packet.Data)
+	}
+	assert.Equal(sizes[ProtocolVersion1]-len(EncodeByteArray(payload))-1, sizes[ProtocolVersion2]-len(payload))
+}
+
+// TestPacketWriterRewriteProtocol tests that rewriting the protocol packet is equivalent to writing it upfront.
+func TestPacketWriterRewriteProtocol(t *testing.T) {
+	payloads := [][]byte{[]byte("sample data"), {0xFF, 0x00, 0xFF}}
+	for _, flags := range []uint32{0, DataChecksumFlag | PacketChecksumFlag} {
+		t.Run(fmt.Sprintf("Flags%d", flags), func(t *testing.T) {
+			assert := assert.New(t)
+			protocol := &ProtocolPacket{Version: ProtocolVersion2, Flags: flags}
+			rewrittenProtocol := &ProtocolPacket{Version: ProtocolVersion2, MinVersion: ProtocolVersion1, MaxVersion: ProtocolVersion2, Flags: flags, Compression: 1}
+
+			packet := &Packet{}
+			writer, err := NewPacketWriter(packet)
+			assert.Nil(err)
+			assert.Nil(writer.WriteProtocol(protocol))
+			for _, payload := range payloads {
+				assert.Nil(writer.AppendDataPacket(&Packet{Data: payload}))
+			}
+			assert.Nil(writer.RewriteProtocol(rewrittenProtocol))
+			assert.Nil(writer.AppendDataPacket(&Packet{Data: payloads[0]}))
+
+			expectedPacket := &Packet{}
+			expectedWriter, err := NewPacketWriter(expectedPacket)
+			assert.Nil(err)
+			assert.Nil(expectedWriter.WriteProtocol(rewrittenProtocol))
+			for _, payload := range append(payloads, payloads[0]) {
+				assert.Nil(expectedWriter.AppendDataPacket(&Packet{Data: payload}))
+			}
+			assert.Equal(expectedPacket.Data, packet.Data)
+			assert.Equal(expectedWriter.GetProtocolSize(), writer.GetProtocolSize())
+
+			assert.NotNil(writer.RewriteProtocol(&ProtocolPacket{Version: ProtocolVersion1}))
+		})
+	}
+}
+
+// TestPacketStreamWriterAndReader tests that the streaming writer and reader are compatible with the buffered ones.
+func TestPacketStreamWriterAndReader(t *testing.T) {
+	for _, version := range []uint32{ProtocolVersion1, ProtocolVersion2} {
+		t.Run(fmt.Sprintf("Version%d", version), func(t *testing.T) {
+			assert := assert.New(t)
+
+			inData := []Packetable{
+				&SamplePacket{Text: "fd1d3938-2988-4df3-9b83-cc278b69cab0"},
+				&Packet{Data: bytes.Repeat([]byte{0xFF}, 1024)},
+				&SamplePacket{Text: "83ce2f5b-f5c4-4bd7-85de-69291f1f80d4"},
+			}
+			protocol := &ProtocolPacket{Version: version, MinVersion: ProtocolVersion1, MaxVersion: ProtocolVersion2}
+
+			packet := &Packet{}
+			writer, err := NewPacketWriter(packet)
+			assert.Nil(err)
+			assert.Nil(writer.WriteProtocol(protocol))
+			for _, in := range inData {
+				assert.Nil(writer.AppendDataPacket(in))
+			}
+
+			var buffer bytes.Buffer
+			streamWriter, err := NewPacketStreamWriter(&buffer)
+			assert.Nil(err)
+			assert.NotNil(streamWriter.WriteDataPacket(inData[0]))
+			assert.Nil(streamWriter.WriteProtocol(protocol))
+			assert.Nil(streamWriter.BeginDataStream(uint64(len(inData))))
+			for _, in := range inData {
+				assert.False(streamWriter.IsComplete())
+				assert.Nil(streamWriter.WriteDataPacket(in))
+			}
+			assert.True(streamWriter.IsComplete())
+			assert.NotNil(streamWriter.WriteDataPacket(inData[0]))
+			assert.Equal(packet.Data, buffer.Bytes())
+
+			streamReader, err := NewPacketStreamReader(bytes.NewReader(buffer.Bytes()))
+			assert.Nil(err)
+			outProtocol, err := streamReader.ReadProtocol()
+			assert.Nil(err)
+			assert.Equal(protocol.Version, outProtocol.Version)
+
+			var state *DataPacketState
+			for i, in := range inData {
+				var data []byte
+				data, state, err = streamReader.ReadNextDataPacket(state)
+				assert.Nil(err)
+				assert.Equal(in.GetType(), state.GetPacketType())
+				assert.Equal(uint64(i), state.packetStreamIndex)
+				expected, err := in.Serialize()
+				assert.Nil(err)
+				assert.Equal(expected, data)
+			}
+			assert.True(state.IsComplete())
+			_, _, err = streamReader.ReadNextDataPacket(state)
+			assert.NotNil(err)
+		})
+	}
+}
+
+// TestPacketStreamReaderWithTruncatedData tests that the streaming reader fails on truncated data.
+func TestPacketStreamReaderWithTruncatedData(t *testing.T) {
+	assert := assert.New(t)
+
+	packet := &Packet{}
+	writer, err := NewPacketWriter(packet)
+	assert.Nil(err)
+	assert.Nil(writer.WriteProtocol(&ProtocolPacket{Version: ProtocolVersion2}))
+	assert.Nil(writer.AppendDataPacket(&Packet{Data: []byte("sample data")}))
+
+	streamReader, err := NewPacketStreamReader(bytes.NewReader(packet.Data[:len(packet.Data)-1]))
+	assert.Nil(err)
+	_, _, err = streamReader.ReadNextDataPacket(nil)
+	assert.NotNil(err)
+}
+
+// buildSamplePacketData builds the data of a packet with the input protocol version and payloads.
+func buildSamplePacketData(assert *assert.Assertions, version uint32, payloads ...[]byte) []byte {
+	packet := &Packet{}
+	writer, err := NewPacketWriter(packet)
+	assert.Nil(err)
+	assert.Nil(writer.WriteProtocol(&ProtocolPacket{Version: version}))
+	for _, payload := range payloads {
+		assert.Nil(writer.AppendDataPacket(&Packet{Data: payload}))
+	}
+	return packet.Data
+}
+
+// TestPacketReaderWithMalformedData tests that the packet reader fails with typed errors on malformed data.
+func TestPacketReaderWithMalformedData(t *testing.T) {
+	setup := assert.New(t)
+
+	v1Data := buildSamplePacketData(setup, ProtocolVersion1, []byte("sample data"))
+	v2Data := buildSamplePacketData(setup, ProtocolVersion2, []byte("sample data"), []byte("more sample data"))
+	v2Protocol := buildSamplePacketData(setup, ProtocolVersion2)
+
+	oversizedSize := bytes.Clone(v2Data)
+	copy(oversizedSize[len(v2Protocol)+16:], []byte{0x7F, 0, 0, 0, 0, 0, 0, 0})
+
+	badDelimiter := bytes.Clone(v1Data)
+	badDelimiter[16] = 0x00
+
+	badBase64 := bytes.Clone(v1Data)
+	badBase64[len(badBase64)-2] = '*'
+
+	emptyStream := append(bytes.Clone(v2Protocol), make([]byte, 24)...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		limits   PacketReaderLimits
+		expected error
+	}{
+		{name: "Empty", data: []byte{}, expected: ErrMissingProtocol},
+		{name: "TruncatedProtocol", data: v2Data[:10], expected: ErrTruncated},
+		{name: "TruncatedV1Payload", data: v1Data[:len(v1Data)-1], expected: ErrTruncated},
+		{name: "TruncatedV2Payload", data: v2Data[:len(v2Data)-1], expected: ErrTruncated},
+		{name: "TruncatedV2Header", data: v2Data[:len(v2Protocol)+4], expected: ErrTruncated},
+		{name: "HugeDeclaredSize", data: oversizedSize, expected: ErrTruncated},
+		{name: "BadDelimiter", data: badDelimiter, expected: ErrBadHeader},
+		{name: "BadBase64", data: badBase64, expected: ErrBadPayload},
+		{name: "EmptyStream", data: emptyStream, expected: ErrBadHeader},
+		{name: "OversizedPayload", data: v2Data, limits: PacketReaderLimits{MaxPayloadSize: 4}, expected: ErrOversized},
+		{name: "OversizedV1Payload", data: v1Data, limits: PacketReaderLimits{MaxPayloadSize: 4}, expected: ErrOversized},
+		{name: "TooManyPackets", data: v2Data, limits: PacketReaderLimits{MaxStreamSize: 1}, expected: ErrTooManyPackets},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			reader, err := NewPacketReaderWithLimits(&Packet{Data: test.data}, test.limits)
+			assert.Nil(err)
+			var state *DataPacketState
+			for err == nil && (state == nil || !state.IsComplete()) {
+				_, state, err = reader.ReadNextDataPacket(state)
+			}
+			assert.ErrorIs(err, test.expected)
+
+			streamReader, err := NewPacketStreamReaderWithLimits(bytes.NewReader(test.data), test.limits)
+			assert.Nil(err)
+			state = nil
+			for err == nil && (state == nil || !state.IsComplete()) {
+				_, state, err = streamReader.ReadNextDataPacket(state)
+			}
+			if test.expected == ErrMissingProtocol {
+				assert.ErrorIs(err, ErrTruncated)
+			} else {
+				assert.ErrorIs(err, test.expected)
+			}
+		})
+	}
+}
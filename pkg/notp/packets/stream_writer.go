@@ -24,8 +24,10 @@ import (
 // PacketWriter is a writer of packets from the NOTP protocol.
 type PacketWriter struct {
 	packet           *Packet
+	protocol         *ProtocolPacket
 	protocolEndIndex int
 	streamEndIndex   int
+	streamSize       uint64
 }
 
 // NewPacketWriter creates a new packet writer.
@@ -51,6 +53,9 @@ func (w *PacketWriter) WriteProtocol(protocol *ProtocolPacket) error {
 	if w.protocolEndIndex > -1 || len(w.packet.Data) > 0 {
 		return errors.New("notp: protocol packet already written")
 	}
+	if err := protocol.validate(); err != nil {
+		return err
+	}
 	data, err := protocol.Serialize()
 	if err != nil {
 		return err
@@ -58,10 +63,57 @@ func (w *PacketWriter) WriteProtocol(protocol *ProtocolPacket) error {
 	if w.packet.Data, err = writeDataPacket(w.packet.Data, protocol.GetType(), data); err != nil {
 		return err
 	}
+	w.protocol = protocol
 	w.protocolEndIndex = len(w.packet.Data) - 1
 	return nil
 }
 
+// RewriteProtocol replaces the protocol packet already written keeping the data packets, which requires the same version and flags.
+func (w *PacketWriter) RewriteProtocol(protocol *ProtocolPacket) error {
+	if protocol == nil {
+		return errors.New("notp: nil protocol packet")
+	}
+	if w.protocolEndIndex == -1 {
+		return errors.New("notp: missing protocol packet")
+	}
+	if protocol.Version != w.protocol.Version || protocol.Flags != w.protocol.Flags {
+		return errors.New("notp: protocol packet with a different wire format")
+	}
+	if err := protocol.validate(); err != nil {
+		return err
+	}
+	data, err := protocol.Serialize()
+	if err != nil {
+		return err
+	}
+	header, err := writeDataPacket(nil, protocol.GetType(), data)
+	if err != nil {
+		return err
+	}
+	stream := w.packet.Data[w.protocolEndIndex+1:]
+	hasPacketChecksum := w.protocol.HasPacketChecksum() && w.streamEndIndex > -1
+	if hasPacketChecksum {
+		stream = stream[:len(stream)-checksumSize]
+	}
+	packetData := make([]byte, 0, len(header)+len(stream)+checksumSize)
+	packetData = append(append(packetData, header...), stream...)
+	if hasPacketChecksum {
+		packetData = appendChecksum(packetData, 0)
+	}
+	if w.streamEndIndex > -1 {
+		w.streamEndIndex += len(header) - (w.protocolEndIndex + 1)
+	}
+	w.packet.Data = packetData
+	w.protocol = protocol
+	w.protocolEndIndex = len(header) - 1
+	return nil
+}
+
+// GetProtocolSize returns the size of the protocol packet preceding the data stream.
+func (w *PacketWriter) GetProtocolSize() int {
+	return w.protocolEndIndex + 1
+}
+
 // AppendDataPacket appends a data packet.
 func (w *PacketWriter) AppendDataPacket(packet Packetable) error {
 	if packet == nil {
@@ -72,24 +124,40 @@ func (w *PacketWriter) AppendDataPacket(packet Packetable) error {
 	}
 	dataType := packet.GetType()
 	data, err := packet.Serialize()
-	data = EncodeByteArray(data)
 	if err != nil {
 		return err
 	}
+	w.streamSize++
 	if w.streamEndIndex == -1 {
-		streamSize := uint64(1)
-		if w.packet.Data, err = writeStreamDataPacket(w.packet.Data, dataType, &streamSize, data); err != nil {
-			return err
-		}
+		w.packet.Data, err = writeFirstDataPacket(w.packet.Data, w.protocol, dataType, w.streamSize, data)
 	} else {
-		if w.packet.Data, err = writeDataPacket(w.packet.Data, dataType, data); err != nil {
-			return err
-		}
-		start := w.protocolEndIndex + 1
-		_, _, _, packetStream, _ := indexDataStreamPacket(start, w.packet.Data)
-		packetStream++
-		binary.BigEndian.PutUint64(w.packet.Data[start:], packetStream)
+		w.packet.Data = w.packet.Data[:w.streamEndIndex+1]
+		w.packet.Data, err = writeNextDataPacket(w.packet.Data, w.protocol, dataType, data)
+		binary.BigEndian.PutUint64(w.packet.Data[w.protocolEndIndex+1:], w.streamSize)
+	}
+	if err != nil {
+		return err
 	}
 	w.streamEndIndex = len(w.packet.Data) - 1
+	if w.protocol.HasPacketChecksum() {
+		w.packet.Data = appendChecksum(w.packet.Data, 0)
+	}
 	return nil
 }
+
+// writeFirstDataPacket writes the first data packet of a stream, including the stream size, for the protocol wire format.
+func writeFirstDataPacket(data []byte, protocol *ProtocolPacket, dataType uint64, streamSize uint64, payload []byte) ([]byte, error) {
+	if protocol.HasLengthPrefixedFraming() {
+		data = writeFramedStreamSize(data, streamSize)
+		return writeFramedDataPacket(data, dataType, payload, protocol.HasDataChecksum()), nil
+	}
+	return writeStreamDataPacket(data, dataType, &streamSize, EncodeByteArray(payload))
+}
+
+// writeNextDataPacket writes a data packet following the first one of a stream for the protocol wire format.
+func writeNextDataPacket(data []byte, protocol *ProtocolPacket, dataType uint64, payload []byte) ([]byte, error) {
+	if protocol.HasLengthPrefixedFraming() {
+		return writeFramedDataPacket(data, dataType, payload, protocol.HasDataChecksum()), nil
+	}
+	return writeDataPacket(data, dataType, EncodeByteArray(payload))
+}
@@ -0,0 +1,43 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package statemachines
+
+import (
+	"slices"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+	notpsmpackets "github.com/permguard/permguard-notp-protocol/pkg/notp/statemachines/packets"
+	notptransport "github.com/permguard/permguard-notp-protocol/pkg/notp/transport"
+)
+
+// MatchMessageCodes returns a matcher selecting the packets whose state packet has one of the message codes, to target them with fault rules.
+func MatchMessageCodes(messageCodes ...uint16) notptransport.PacketMatcher {
+	return notptransport.MatchDataPacket(func(codecID uint32, packetType uint64, data []byte) bool {
+		statePacket := &notpsmpackets.StatePacket{}
+		if packetType != statePacket.GetType() {
+			return false
+		}
+		codec := notppackets.NewBinaryCodec()
+		if codecID == notppackets.JSONCodecID {
+			codec = notppackets.NewJSONCodec()
+		}
+		if err := codec.Decode(data, statePacket); err != nil {
+			return false
+		}
+		return slices.Contains(messageCodes, statePacket.MessageCode)
+	})
+}
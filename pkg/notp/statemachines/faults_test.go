@@ -0,0 +1,245 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package statemachines
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+	notpsmpackets "github.com/permguard/permguard-notp-protocol/pkg/notp/statemachines/packets"
+	notptransport "github.com/permguard/permguard-notp-protocol/pkg/notp/transport"
+)
+
+// faultyRunResult holds the outcome of a pull flow run through fault injectors.
+type faultyRunResult struct {
+	followerErr error
+	leaderErr   error
+	followerIDs []uint16
+	leaderIDs   []uint16
+}
+
+// buildFaultyTransportLayer initializes a transport layer over the endpoint whose sent packets go through the fault injector.
+func buildFaultyTransportLayer(assert *assert.Assertions, endpoint *notptransport.InMemoryPipeEndpoint, injector *notptransport.FaultInjector) *notptransport.TransportLayer {
+	sender := endpoint.TransmitPacketContext
+	if injector != nil {
+		sender = injector.WrapSender(sender)
+	}
+	transportLayer, err := notptransport.NewContextTransportLayer(sender, endpoint.ReceivePacketContext, nil,
+		notptransport.WithChecksums(), notptransport.WithStreamClosers(endpoint.Close, endpoint.CloseWrite))
+	assert.Nil(err, "Failed to initialize the transport layer")
+	return transportLayer
+}
+
+// runFaultyPullFlow runs a pull flow streaming the chunks between a follower and a leader whose sent packets go through the fault injectors.
+func runFaultyPullFlow(assert *assert.Assertions, followerInjector, leaderInjector *notptransport.FaultInjector, chunks int) *faultyRunResult {
+	followerEndpoint, leaderEndpoint, err := notptransport.NewInMemoryPipe(notptransport.WithPipeTimeout(200 * time.Millisecond))
+	assert.Nil(err, "Failed to initialize the in-memory pipe")
+	return runPullFlow(assert, buildFaultyTransportLayer(assert, followerEndpoint, followerInjector), buildFaultyTransportLayer(assert, leaderEndpoint, leaderInjector), chunks)
+}
+
+// runPullFlow runs a pull flow streaming the chunks between a follower and a leader over the transport layers.
+func runPullFlow(assert *assert.Assertions, followerTransport, leaderTransport *notptransport.TransportLayer, chunks int) *faultyRunResult {
+	result := &faultyRunResult{}
+	ackValue := notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.UnknownValue)
+	followerHandler := func(handlerCtx *HandlerContext, statePacket *notpsmpackets.StatePacket, packets []notppackets.Packetable) (*HostHandlerReturn, error) {
+		result.followerIDs = append(result.followerIDs, handlerCtx.GetCurrentStateID())
+		if handlerCtx.GetCurrentStateID() == SubscriberDataStreamStateID {
+			return &HostHandlerReturn{MessageValue: statePacket.MessageValue}, nil
+		}
+		return &HostHandlerReturn{MessageValue: ackValue}, nil
+	}
+	remaining := chunks
+	leaderHandler := func(handlerCtx *HandlerContext, statePacket *notpsmpackets.StatePacket, packets []notppackets.Packetable) (*HostHandlerReturn, error) {
+		result.leaderIDs = append(result.leaderIDs, handlerCtx.GetCurrentStateID())
+		if handlerCtx.GetCurrentStateID() == PublisherDataStreamStateID {
+			remaining--
+			if remaining > 0 {
+				return &HostHandlerReturn{
+					MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.ActiveDataStreamValue),
+					HasMore:      true,
+				}, nil
+			}
+			return &HostHandlerReturn{MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.CompletedDataStreamValue)}, nil
+		}
+		return &HostHandlerReturn{MessageValue: ackValue}, nil
+	}
+
+	follower, err := NewFollowerStateMachine(followerHandler, followerTransport)
+	assert.Nil(err, "Failed to initialize the follower state machine")
+	leader, err := NewLeaderStateMachine(leaderHandler, leaderTransport)
+	assert.Nil(err, "Failed to initialize the leader state machine")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, result.followerErr = follower.Run(nil, PullFlowType)
+	}()
+	go func() {
+		defer wg.Done()
+		_, result.leaderErr = leader.Run(nil, UnknownFlowType)
+	}()
+	wg.Wait()
+	return result
+}
+
+// TestPullProtocolWithFaults verifies the outcome of the pull flow when the packets are lost, duplicated, reordered, delayed or corrupted.
+func TestPullProtocolWithFaults(t *testing.T) {
+	tests := []struct {
+		name          string
+		followerRules []notptransport.FaultRule
+		leaderRules   []notptransport.FaultRule
+		followerErr   string
+		leaderErr     string
+	}{
+		{
+			name:          "DropCommit",
+			followerRules: []notptransport.FaultRule{{Fault: notptransport.DropFault, Nth: 1, Match: MatchMessageCodes(notpsmpackets.CommitMessage)}},
+			leaderErr:     "notp: subscriber commit failed to receive and handle respond current state packet",
+		},
+		{
+			name:          "DropNegotiationRequest",
+			followerRules: []notptransport.FaultRule{{Fault: notptransport.DropFault, Nth: 3}},
+			followerErr:   "notp: subscribe negotiation failed to receive and handle respond current state packet",
+			leaderErr:     "notp: publusher negotiation failed to receive and handle notify current state packet",
+		},
+		{
+			name:          "DuplicateStartFlow",
+			followerRules: []notptransport.FaultRule{{Fault: notptransport.DuplicateFault, Nth: 1, Match: MatchMessageCodes(notpsmpackets.StartFlowMessage)}},
+			followerErr:   "notp: request object failed to receive and handle respond current state packet",
+			leaderErr:     "notp: received unexpected state code: 100",
+		},
+		{
+			name:        "ReorderActionResponse",
+			leaderRules: []notptransport.FaultRule{{Fault: notptransport.ReorderFault, Nth: 1, Match: MatchMessageCodes(notpsmpackets.ActionResponseMessage)}},
+			followerErr: "notp: start flow failed to receive and handle action response packet",
+			leaderErr:   "notp: process request failed to receive and handle request current state packet",
+		},
+		{
+			name:          "CorruptRequestObjects",
+			followerRules: []notptransport.FaultRule{{Fault: notptransport.CorruptFault, Nth: 1, Match: MatchMessageCodes(notpsmpackets.RequestCurrentObjectsStateMessage)}},
+			followerErr:   "notp: request object failed to receive and handle respond current state packet",
+			leaderErr:     "notp: process request failed to receive and handle request current state packet",
+		},
+		{
+			name:        "DelayDataStream",
+			leaderRules: []notptransport.FaultRule{{Fault: notptransport.DelayFault, Probability: 1, Delay: 20 * time.Millisecond, Match: MatchMessageCodes(notpsmpackets.ExchangeDataStreamMessage)}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			var followerInjector, leaderInjector *notptransport.FaultInjector
+			var err error
+			if test.followerRules != nil {
+				followerInjector, err = notptransport.NewFaultInjector(1, test.followerRules...)
+				assert.Nil(err)
+			}
+			if test.leaderRules != nil {
+				leaderInjector, err = notptransport.NewFaultInjector(1, test.leaderRules...)
+				assert.Nil(err)
+			}
+			result := runFaultyPullFlow(assert, followerInjector, leaderInjector, 3)
+			for _, check := range []struct {
+				err      error
+				expected string
+			}{{result.followerErr, test.followerErr}, {result.leaderErr, test.leaderErr}} {
+				if check.expected == "" {
+					assert.Nil(check.err)
+				} else {
+					assert.ErrorContains(check.err, check.expected)
+				}
+			}
+		})
+	}
+}
+
+// TestPullProtocolWithSeededFaults verifies that the random faults are reproducible from their seed.
+func TestPullProtocolWithSeededFaults(t *testing.T) {
+	assert := assert.New(t)
+	run := func() *faultyRunResult {
+		rules := []notptransport.FaultRule{
+			{Fault: notptransport.DropFault, Probability: 0.2},
+			{Fault: notptransport.DuplicateFault, Probability: 0.2},
+		}
+		followerInjector, err := notptransport.NewFaultInjector(3, rules...)
+		assert.Nil(err)
+		leaderInjector, err := notptransport.NewFaultInjector(5, rules...)
+		assert.Nil(err)
+		return runFaultyPullFlow(assert, followerInjector, leaderInjector, 5)
+	}
+	first := run()
+	second := run()
+	assert.Equal(first.followerIDs, second.followerIDs)
+	assert.Equal(first.leaderIDs, second.leaderIDs)
+	assert.Equal(first.followerErr == nil, second.followerErr == nil)
+	assert.Equal(first.leaderErr == nil, second.leaderErr == nil)
+	if first.followerErr != nil {
+		assert.EqualError(second.followerErr, first.followerErr.Error())
+	}
+	if first.leaderErr != nil {
+		assert.EqualError(second.leaderErr, first.leaderErr.Error())
+	}
+}
+
+// buildReliableTransportLayer initializes a transport layer over a reliable layer on the endpoint whose sent packets go through the fault injector.
+func buildReliableTransportLayer(assert *assert.Assertions, endpoint *notptransport.InMemoryPipeEndpoint, injector *notptransport.FaultInjector) *notptransport.TransportLayer {
+	reliable, err := notptransport.NewReliableLayer(injector.WrapSender(endpoint.TransmitPacketContext), endpoint.ReceivePacketContext,
+		notptransport.WithRetransmission(5*time.Millisecond, 50*time.Millisecond, 20), notptransport.WithReliableCloser(endpoint.Close))
+	assert.Nil(err, "Failed to initialize the reliable layer")
+	transportLayer, err := notptransport.NewStreamTransportLayer(reliable, nil)
+	assert.Nil(err, "Failed to initialize the transport layer")
+	return transportLayer
+}
+
+// TestPullProtocolWithReliableLayer verifies that the pull flow succeeds over reliable layers when the packets are lost, duplicated, reordered or corrupted.
+func TestPullProtocolWithReliableLayer(t *testing.T) {
+	assert := assert.New(t)
+	rules := []notptransport.FaultRule{
+		{Fault: notptransport.DropFault, Probability: 0.1},
+		{Fault: notptransport.DuplicateFault, Probability: 0.1},
+		{Fault: notptransport.ReorderFault, Probability: 0.1},
+		{Fault: notptransport.CorruptFault, Probability: 0.1},
+	}
+	followerInjector, err := notptransport.NewFaultInjector(3, rules...)
+	assert.Nil(err)
+	leaderInjector, err := notptransport.NewFaultInjector(5, rules...)
+	assert.Nil(err)
+	followerEndpoint, leaderEndpoint, err := notptransport.NewInMemoryPipe()
+	assert.Nil(err, "Failed to initialize the in-memory pipe")
+	followerTransport := buildReliableTransportLayer(assert, followerEndpoint, followerInjector)
+	defer followerTransport.Close()
+	leaderTransport := buildReliableTransportLayer(assert, leaderEndpoint, leaderInjector)
+	defer leaderTransport.Close()
+
+	result := runPullFlow(assert, followerTransport, leaderTransport, 20)
+	assert.Nil(result.followerErr)
+	assert.Nil(result.leaderErr)
+	chunks := 0
+	for _, stateID := range result.leaderIDs {
+		if stateID == PublisherDataStreamStateID {
+			chunks++
+		}
+	}
+	assert.Equal(20, chunks)
+	stats := followerInjector.GetStats()
+	assert.NotZero(stats.Dropped + stats.Duplicated + stats.Reordered + stats.Corrupted)
+}
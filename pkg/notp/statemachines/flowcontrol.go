@@ -0,0 +1,168 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package statemachines
+
+import (
+	"errors"
+	"fmt"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+	notpsmpackets "github.com/permguard/permguard-notp-protocol/pkg/notp/statemachines/packets"
+)
+
+// FlowControlWindow represents the credits the subscriber grants to the publisher of the data stream, as the number of chunks
+// and of bytes the publisher sends before waiting for a grant, where zero means unlimited.
+type FlowControlWindow struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// isZero returns true if the window does not limit anything.
+func (w FlowControlWindow) isZero() bool {
+	return w.Packets == 0 && w.Bytes == 0
+}
+
+// isExhausted returns true if the outstanding chunks and bytes exhaust the window, so that the publisher waits for a grant.
+func (w FlowControlWindow) isExhausted(packets, bytes uint64) bool {
+	return (w.Packets > 0 && packets >= w.Packets) || (w.Bytes > 0 && bytes >= w.Bytes)
+}
+
+// setWindowExtensions sets the extensions of the state packet holding the window.
+func setWindowExtensions(statePacket *notpsmpackets.StatePacket, window FlowControlWindow) {
+	statePacket.SetUint64Extension(notpsmpackets.WindowPacketsExtension, window.Packets)
+	statePacket.SetUint64Extension(notpsmpackets.WindowBytesExtension, window.Bytes)
+}
+
+// getWindowExtensions returns the window held by the extensions of the state packet and whether they are set.
+func getWindowExtensions(statePacket *notpsmpackets.StatePacket) (FlowControlWindow, bool) {
+	packets, hasPackets := statePacket.GetUint64Extension(notpsmpackets.WindowPacketsExtension)
+	bytes, hasBytes := statePacket.GetUint64Extension(notpsmpackets.WindowBytesExtension)
+	return FlowControlWindow{Packets: packets, Bytes: bytes}, hasPackets && hasBytes
+}
+
+// WithFlowControl sets the window the state machine grants as subscriber of the data stream, so that a fast publisher
+// waits for the state machine to handle the chunks instead of overwhelming it.
+func WithFlowControl(window FlowControlWindow) StateMachineOption {
+	return func(m *StateMachine) error {
+		if window.isZero() {
+			return errors.New("notp: flow control window cannot be unlimited")
+		}
+		m.runtime.flowControlWindow = window
+		return nil
+	}
+}
+
+// flowControlState holds the flow control of the data stream negotiated for a run of the state machine.
+//
+// The subscriber requests a window in the negotiation request and the publisher enables the flow control by echoing it.
+// Both sides count the outstanding chunks whose state packet holds an active data stream, along with their declared sizes,
+// and evaluate the same predicate: once the window is exhausted the publisher waits for a grant, which the subscriber
+// sends after handling the chunk.
+type flowControlState struct {
+	enabled            bool
+	window             FlowControlWindow
+	outstandingPackets uint64
+	outstandingBytes   uint64
+}
+
+// negotiate enables the flow control if the negotiation packet holds a window.
+func (f *flowControlState) negotiate(statePacket *notpsmpackets.StatePacket) {
+	if f == nil {
+		return
+	}
+	window, ok := getWindowExtensions(statePacket)
+	if !ok || window.isZero() {
+		return
+	}
+	f.enabled = true
+	f.window = window
+}
+
+// prepare sets the flow control extensions of the state packet about to be sent and returns the packets to send along with it,
+// which are encoded by the transport layer to declare the size of a data stream chunk.
+func (f *flowControlState) prepare(runtime *StateMachineRuntimeContext, statePacket *notpsmpackets.StatePacket, packetables []notppackets.Packetable) ([]notppackets.Packetable, error) {
+	if f == nil {
+		return packetables, nil
+	}
+	switch statePacket.MessageCode {
+	case notpsmpackets.NegotiationRequestMessage:
+		if !runtime.flowControlWindow.isZero() {
+			setWindowExtensions(statePacket, runtime.flowControlWindow)
+		}
+	case notpsmpackets.RespondNegotiationRequestMessage:
+		if f.enabled {
+			setWindowExtensions(statePacket, f.window)
+		}
+	case notpsmpackets.ExchangeDataStreamMessage:
+		if !f.enabled || f.window.Bytes == 0 {
+			return packetables, nil
+		}
+		encodedPacketables, err := runtime.transportLayer.EncodePacketables(packetables)
+		if err != nil {
+			return nil, fmt.Errorf("notp: failed to measure data stream chunk: %w", err)
+		}
+		size := uint64(0)
+		for _, packetable := range encodedPacketables {
+			size += uint64(len(packetable.(*notppackets.UnknownPacket).Data))
+		}
+		statePacket.SetUint64Extension(notpsmpackets.ChunkSizeExtension, size)
+		return encodedPacketables, nil
+	}
+	return packetables, nil
+}
+
+// count counts the chunk as outstanding and returns true if the window is exhausted, unless the flow control is disabled
+// or the state packet ends the data stream.
+func (f *flowControlState) count(statePacket *notpsmpackets.StatePacket) bool {
+	if f == nil || !f.enabled || statePacket.MessageCode != notpsmpackets.ExchangeDataStreamMessage || !statePacket.HasActiveDataStream() {
+		return false
+	}
+	size, _ := statePacket.GetUint64Extension(notpsmpackets.ChunkSizeExtension)
+	f.outstandingPackets++
+	f.outstandingBytes += size
+	return f.window.isExhausted(f.outstandingPackets, f.outstandingBytes)
+}
+
+// awaitGrant waits for a grant once the chunk sent by the publisher exhausts the window, unless it is the last one.
+func (f *flowControlState) awaitGrant(runtime *StateMachineRuntimeContext, statePacket *notpsmpackets.StatePacket) (bool, error) {
+	if !f.count(statePacket) {
+		return false, nil
+	}
+	grantPacket, _, terminate, err := receiveAndHandleStatePacket(runtime, notpsmpackets.GrantDataStreamMessage)
+	if terminate || err != nil {
+		return terminate, err
+	}
+	grant, ok := getWindowExtensions(grantPacket)
+	if !ok {
+		return false, errors.New("notp: received grant without credits")
+	}
+	f.outstandingPackets -= min(grant.Packets, f.outstandingPackets)
+	f.outstandingBytes -= min(grant.Bytes, f.outstandingBytes)
+	return false, nil
+}
+
+// grant sends a grant once the chunk handled by the subscriber exhausts the window, unless it is the last one.
+func (f *flowControlState) grant(runtime *StateMachineRuntimeContext, statePacket *notpsmpackets.StatePacket) error {
+	if !f.count(statePacket) {
+		return nil
+	}
+	grantPacket := &notpsmpackets.StatePacket{MessageCode: notpsmpackets.GrantDataStreamMessage}
+	setWindowExtensions(grantPacket, FlowControlWindow{Packets: f.outstandingPackets, Bytes: f.outstandingBytes})
+	f.outstandingPackets = 0
+	f.outstandingBytes = 0
+	return runtime.Send(grantPacket)
+}
@@ -0,0 +1,274 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package statemachines
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+	notpsmpackets "github.com/permguard/permguard-notp-protocol/pkg/notp/statemachines/packets"
+	notptransport "github.com/permguard/permguard-notp-protocol/pkg/notp/transport"
+)
+
+// countMessages counts the packets holding a state packet with the message code.
+func countMessages(packets []notppackets.Packet, messageCode uint16) int {
+	match := MatchMessageCodes(messageCode)
+	count := 0
+	for _, packet := range packets {
+		if match(&packet) {
+			count++
+		}
+	}
+	return count
+}
+
+// TestDataStreamFlowControl verifies that the publisher waits for the grants of the subscriber once the window is exhausted.
+func TestDataStreamFlowControl(t *testing.T) {
+	tests := []struct {
+		name     string
+		flowType FlowType
+		window   *FlowControlWindow
+		grants   int
+		ahead    int64
+	}{
+		{name: "Disabled", flowType: PullFlowType},
+		{name: "Packets", flowType: PullFlowType, window: &FlowControlWindow{Packets: 2}, grants: 4, ahead: 2},
+		{name: "Bytes", flowType: PullFlowType, window: &FlowControlWindow{Bytes: 5000}, grants: 4, ahead: 2},
+		{name: "PacketsAndBytes", flowType: PullFlowType, window: &FlowControlWindow{Packets: 3, Bytes: 20000}, grants: 3, ahead: 3},
+		{name: "PushFlow", flowType: PushFlowType, window: &FlowControlWindow{Packets: 1}, grants: 9, ahead: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			const chunks = 10
+			var produced, consumed atomic.Int64
+			var maxAhead atomic.Int64
+			ackValue := notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.UnknownValue)
+			handler := func(handlerCtx *HandlerContext, statePacket *notpsmpackets.StatePacket, packets []notppackets.Packetable) (*HostHandlerReturn, error) {
+				switch handlerCtx.GetCurrentStateID() {
+				case SubscriberDataStreamStateID:
+					consumed.Add(1)
+					return &HostHandlerReturn{MessageValue: statePacket.MessageValue}, nil
+				case PublisherDataStreamStateID:
+					ahead := produced.Load() - consumed.Load()
+					if ahead > maxAhead.Load() {
+						maxAhead.Store(ahead)
+					}
+					if produced.Add(1) < chunks {
+						return &HostHandlerReturn{
+							MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.ActiveDataStreamValue),
+							Packetables:  []notppackets.Packetable{&notppackets.Packet{Data: make([]byte, 4096)}},
+							HasMore:      true,
+						}, nil
+					}
+					return &HostHandlerReturn{MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.CompletedDataStreamValue)}, nil
+				}
+				return &HostHandlerReturn{MessageValue: ackValue}, nil
+			}
+			var opts []StateMachineOption
+			if test.window != nil {
+				opts = append(opts, WithFlowControl(*test.window))
+			}
+			sMInfo := buildCommitStateMachines(assert, handler, handler, opts...)
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runtime, err := sMInfo.leader.Run(nil, UnknownFlowType)
+				if assert.Nil(err, "Failed to run the leader state machine") {
+					assert.True(runtime.IsFinal())
+				}
+			}()
+			runtime, err := sMInfo.follower.Run(nil, test.flowType)
+			if assert.Nil(err, "Failed to run the follower state machine") {
+				assert.True(runtime.IsFinal())
+			}
+			wg.Wait()
+
+			assert.Equal(int64(chunks), produced.Load())
+			assert.Equal(int64(chunks), consumed.Load())
+			subscriberEndpoint := sMInfo.followerEndpoint
+			if test.flowType == PushFlowType {
+				subscriberEndpoint = sMInfo.leaderEndpoint
+			}
+			assert.Equal(test.grants, countMessages(subscriberEndpoint.GetSentPackets(), notpsmpackets.GrantDataStreamMessage))
+			if test.window != nil {
+				assert.LessOrEqual(maxAhead.Load(), test.ahead)
+			}
+		})
+	}
+}
+
+// TestDataStreamFlowControlChunkSize verifies that the declared size of the chunks is the size of their payloads as encoded by the transport layer.
+func TestDataStreamFlowControlChunkSize(t *testing.T) {
+	assert := assert.New(t)
+	chunk := &notppackets.Packet{Data: make([]byte, 4096)}
+	codec := notppackets.NewJSONCodec()
+	encoded, err := codec.Encode(chunk)
+	assert.Nil(err)
+
+	var sizes []uint64
+	var produced atomic.Int64
+	handler := func(handlerCtx *HandlerContext, statePacket *notpsmpackets.StatePacket, packets []notppackets.Packetable) (*HostHandlerReturn, error) {
+		ackValue := notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.UnknownValue)
+		switch handlerCtx.GetCurrentStateID() {
+		case SubscriberDataStreamStateID:
+			if size, ok := statePacket.GetUint64Extension(notpsmpackets.ChunkSizeExtension); ok {
+				sizes = append(sizes, size)
+			}
+			return &HostHandlerReturn{MessageValue: statePacket.MessageValue}, nil
+		case PublisherDataStreamStateID:
+			if produced.Add(1) == 1 {
+				return &HostHandlerReturn{
+					MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.ActiveDataStreamValue),
+					Packetables:  []notppackets.Packetable{chunk},
+					HasMore:      true,
+				}, nil
+			}
+			return &HostHandlerReturn{MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.CompletedDataStreamValue)}, nil
+		}
+		return &HostHandlerReturn{MessageValue: ackValue}, nil
+	}
+	followerEndpoint, leaderEndpoint, err := notptransport.NewInMemoryPipe()
+	assert.Nil(err)
+	followerTransport, err := notptransport.NewStreamTransportLayer(followerEndpoint, nil, notptransport.WithPacketCodec(codec))
+	assert.Nil(err)
+	leaderTransport, err := notptransport.NewStreamTransportLayer(leaderEndpoint, nil, notptransport.WithPacketCodec(codec))
+	assert.Nil(err)
+	window := WithFlowControl(FlowControlWindow{Bytes: 1 << 20})
+	follower, err := NewFollowerStateMachine(handler, followerTransport, window)
+	assert.Nil(err)
+	leader, err := NewLeaderStateMachine(handler, leaderTransport, window)
+	assert.Nil(err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := leader.Run(nil, UnknownFlowType)
+		assert.Nil(err)
+	}()
+	_, err = follower.Run(nil, PullFlowType)
+	assert.Nil(err)
+	wg.Wait()
+	assert.Equal([]uint64{uint64(len(encoded)), 0}, sizes)
+}
+
+// TestDataStreamFlowControlPrepareFailure verifies that a chunk which cannot be measured stops the data stream with the error.
+func TestDataStreamFlowControlPrepareFailure(t *testing.T) {
+	assert := assert.New(t)
+	ackValue := notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.UnknownValue)
+	handler := func(handlerCtx *HandlerContext, statePacket *notpsmpackets.StatePacket, packets []notppackets.Packetable) (*HostHandlerReturn, error) {
+		if handlerCtx.GetCurrentStateID() == PublisherDataStreamStateID {
+			return &HostHandlerReturn{
+				MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.ActiveDataStreamValue),
+				Packetables:  []notppackets.Packetable{nil},
+				HasMore:      true,
+			}, nil
+		}
+		return &HostHandlerReturn{MessageValue: ackValue}, nil
+	}
+	sMInfo := buildCommitStateMachines(assert, handler, handler, WithFlowControl(FlowControlWindow{Bytes: 1 << 20}))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := sMInfo.leader.Run(nil, UnknownFlowType)
+		assert.ErrorContains(err, "notp: failed to prepare packet")
+		assert.ErrorContains(err, "notp: cannot send a nil packet")
+	}()
+	runtime, err := sMInfo.follower.Run(nil, PullFlowType)
+	wg.Wait()
+	if assert.Nil(err) {
+		assert.True(runtime.IsFinal())
+	}
+	assert.Equal(0, countMessages(sMInfo.leaderEndpoint.GetSentPackets(), notpsmpackets.ExchangeDataStreamMessage))
+	assert.Equal(1, countMessages(sMInfo.leaderEndpoint.GetSentPackets(), notpsmpackets.TerminateMessage))
+}
+
+// TestDataStreamHasMoreMismatch verifies that a handler whose more data disagrees with the data stream state stops the data stream with an error.
+func TestDataStreamHasMoreMismatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		window *FlowControlWindow
+	}{
+		{name: "Disabled"},
+		{name: "Enabled", window: &FlowControlWindow{Packets: 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			var produced atomic.Int64
+			ackValue := notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.UnknownValue)
+			handler := func(handlerCtx *HandlerContext, statePacket *notpsmpackets.StatePacket, packets []notppackets.Packetable) (*HostHandlerReturn, error) {
+				switch handlerCtx.GetCurrentStateID() {
+				case SubscriberDataStreamStateID:
+					return &HostHandlerReturn{MessageValue: statePacket.MessageValue}, nil
+				case PublisherDataStreamStateID:
+					produced.Add(1)
+					return &HostHandlerReturn{
+						MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.ActiveDataStreamValue),
+						Packetables:  []notppackets.Packetable{&notppackets.Packet{Data: make([]byte, 4096)}},
+						HasMore:      false,
+					}, nil
+				}
+				return &HostHandlerReturn{MessageValue: ackValue}, nil
+			}
+			var opts []StateMachineOption
+			if test.window != nil {
+				opts = append(opts, WithFlowControl(*test.window))
+			}
+			sMInfo := buildCommitStateMachines(assert, handler, handler, opts...)
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := sMInfo.leader.Run(nil, UnknownFlowType)
+				assert.ErrorContains(err, "notp: handler more data disagrees with the data stream state")
+			}()
+			runtime, err := sMInfo.follower.Run(nil, PullFlowType)
+			wg.Wait()
+			if assert.Nil(err) {
+				assert.True(runtime.IsFinal())
+			}
+			assert.Equal(int64(1), produced.Load())
+			assert.Equal(0, countMessages(sMInfo.leaderEndpoint.GetSentPackets(), notpsmpackets.ExchangeDataStreamMessage))
+			assert.Equal(1, countMessages(sMInfo.leaderEndpoint.GetSentPackets(), notpsmpackets.TerminateMessage))
+		})
+	}
+}
+
+// TestDataStreamFlowControlInvalidWindow verifies that an unlimited window is rejected.
+func TestDataStreamFlowControlInvalidWindow(t *testing.T) {
+	assert := assert.New(t)
+	endpoint, _, err := notptransport.NewInMemoryPipe()
+	assert.Nil(err)
+	transportLayer, err := notptransport.NewStreamTransportLayer(endpoint, nil)
+	assert.Nil(err)
+	handler := func(*HandlerContext, *notpsmpackets.StatePacket, []notppackets.Packetable) (*HostHandlerReturn, error) {
+		return &HostHandlerReturn{}, nil
+	}
+	_, err = NewFollowerStateMachine(handler, transportLayer, WithFlowControl(FlowControlWindow{}))
+	assert.ErrorContains(err, "notp: flow control window cannot be unlimited")
+}
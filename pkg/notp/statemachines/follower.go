@@ -23,8 +23,8 @@ import (
 )
 
 // NewFollowerStateMachine creates and configures a new follower state machine for the given operation.
-func NewFollowerStateMachine(hostHandler HostHandler, transportLayer *notptransport.TransportLayer) (*StateMachine, error) {
-	stateMachine, err := NewStateMachine(defaultStateMap, StartFlowStateID, hostHandler, transportLayer)
+func NewFollowerStateMachine(hostHandler HostHandler, transportLayer *notptransport.TransportLayer, opts ...StateMachineOption) (*StateMachine, error) {
+	stateMachine, err := NewStateMachine(defaultStateMap, StartFlowStateID, hostHandler, transportLayer, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("notp: failed to create follower state machine: %w", err)
 	}
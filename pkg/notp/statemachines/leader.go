@@ -23,8 +23,8 @@ import (
 )
 
 // NewLeaderStateMachine creates and configures a new leader state machine for the given operation.
-func NewLeaderStateMachine(hostHandler HostHandler, transportLayer *notptransport.TransportLayer) (*StateMachine, error) {
-	stateMachine, err := NewStateMachine(defaultStateMap, ProcessStartFlowStateID, hostHandler, transportLayer)
+func NewLeaderStateMachine(hostHandler HostHandler, transportLayer *notptransport.TransportLayer, opts ...StateMachineOption) (*StateMachine, error) {
+	stateMachine, err := NewStateMachine(defaultStateMap, ProcessStartFlowStateID, hostHandler, transportLayer, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("notp: failed to create leader state machine: %w", err)
 	}
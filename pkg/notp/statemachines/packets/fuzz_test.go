@@ -0,0 +1,53 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packets
+
+import (
+	"bytes"
+	"maps"
+	"testing"
+)
+
+// FuzzStatePacketDeserialize fuzzes the deserialization of state packets.
+func FuzzStatePacketDeserialize(f *testing.F) {
+	data, _ := (&StatePacket{MessageCode: StartFlowMessage, MessageValue: 0xFFFFFFFFFFFFFFFF, ErrorCode: 0}).Serialize()
+	f.Add(data)
+	f.Add([]byte{0x00, 0x64, 0xFF})
+	statePacket := &StatePacket{MessageCode: ActionResponseMessage}
+	statePacket.SetUint64Extension(SequenceNumberExtension, 1)
+	statePacket.SetStringExtension(ReasonExtension, "reason")
+	data, _ = statePacket.Serialize()
+	f.Add(data)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		statePacket := &StatePacket{}
+		if err := statePacket.Deserialize(data); err != nil {
+			return
+		}
+		out, err := statePacket.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		other := &StatePacket{}
+		if err = other.Deserialize(out); err != nil {
+			t.Fatal(err)
+		}
+		if other.MessageCode != statePacket.MessageCode || other.MessageValue != statePacket.MessageValue || other.ErrorCode != statePacket.ErrorCode ||
+			!maps.EqualFunc(other.Extensions, statePacket.Extensions, bytes.Equal) {
+			t.Fatalf("round trip mismatch for %v", data)
+		}
+	})
+}
@@ -17,6 +17,11 @@
 package packets
 
 import (
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+
 	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
 )
 
@@ -59,9 +64,22 @@ const (
 
 	// ExchangeDataStreamMessage represents the exchange of data stream.
 	ExchangeDataStreamMessage = uint16(170)
+	// GrantDataStreamMessage represents the grant of credits to the publisher of the data stream.
+	GrantDataStreamMessage = uint16(171)
 
 	// CommitMessage represents the commit message.
 	CommitMessage = uint16(200)
+
+	// SequenceNumberExtension represents the extension holding the sequence number of the packet.
+	SequenceNumberExtension = uint16(1)
+	// ReasonExtension represents the extension holding the reason of the packet.
+	ReasonExtension = uint16(2)
+	// WindowPacketsExtension represents the extension holding the number of chunks of the flow control window or grant.
+	WindowPacketsExtension = uint16(3)
+	// WindowBytesExtension represents the extension holding the number of bytes of the flow control window or grant.
+	WindowBytesExtension = uint16(4)
+	// ChunkSizeExtension represents the extension holding the size in bytes of a data stream chunk.
+	ChunkSizeExtension = uint16(5)
 )
 
 // StatePacket encapsulates the data structure for a base packet used in the protocol.
@@ -69,6 +87,8 @@ type StatePacket struct {
 	MessageCode  uint16
 	MessageValue uint64
 	ErrorCode    uint16
+	// Extensions holds the values of the optional extensions by tag, which decoders not knowing a tag skip.
+	Extensions map[uint16][]byte
 }
 
 // GetType returns the packet type.
@@ -96,11 +116,72 @@ func (p *StatePacket) HasError() bool {
 	return p.ErrorCode != 0
 }
 
+// SetExtension sets the value of the extension.
+func (p *StatePacket) SetExtension(tag uint16, value []byte) {
+	if p.Extensions == nil {
+		p.Extensions = map[uint16][]byte{}
+	}
+	p.Extensions[tag] = value
+}
+
+// GetExtension returns the value of the extension and whether it is set.
+func (p *StatePacket) GetExtension(tag uint16) ([]byte, bool) {
+	value, ok := p.Extensions[tag]
+	return value, ok
+}
+
+// HasExtension returns true if the extension is set.
+func (p *StatePacket) HasExtension(tag uint16) bool {
+	_, ok := p.Extensions[tag]
+	return ok
+}
+
+// RemoveExtension removes the extension.
+func (p *StatePacket) RemoveExtension(tag uint16) {
+	delete(p.Extensions, tag)
+	if len(p.Extensions) == 0 {
+		p.Extensions = nil
+	}
+}
+
+// SetUint64Extension sets the value of the extension to a uint64.
+func (p *StatePacket) SetUint64Extension(tag uint16, value uint64) {
+	p.SetExtension(tag, binary.BigEndian.AppendUint64(nil, value))
+}
+
+// GetUint64Extension returns the uint64 value of the extension and whether it is set with a uint64.
+func (p *StatePacket) GetUint64Extension(tag uint16) (uint64, bool) {
+	value, ok := p.GetExtension(tag)
+	if !ok || len(value) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(value), true
+}
+
+// SetStringExtension sets the value of the extension to a string.
+func (p *StatePacket) SetStringExtension(tag uint16, value string) {
+	p.SetExtension(tag, []byte(value))
+}
+
+// GetStringExtension returns the string value of the extension and whether it is set.
+func (p *StatePacket) GetStringExtension(tag uint16) (string, bool) {
+	value, ok := p.GetExtension(tag)
+	return string(value), ok
+}
+
 // Serialize serializes the packet into bytes.
 func (p *StatePacket) Serialize() ([]byte, error) {
 	data := notppackets.SerializeUint16(nil, p.MessageCode, notppackets.PacketNullByte)
 	data = notppackets.SerializeUint64(data, p.MessageValue, notppackets.PacketNullByte)
 	data = notppackets.SerializeUint16(data, p.ErrorCode, notppackets.PacketNullByte)
+	var err error
+	for _, tag := range slices.Sorted(maps.Keys(p.Extensions)) {
+		data = notppackets.SerializeUint16(data, tag, notppackets.PacketNullByte)
+		data, err = notppackets.SerializeDelimitedBytes(data, p.Extensions[tag], notppackets.PacketNullByte)
+		if err != nil {
+			return nil, fmt.Errorf("notp: invalid extension %d: %w", tag, err)
+		}
+	}
 	return data, nil
 }
 
@@ -119,5 +200,22 @@ func (p *StatePacket) Deserialize(data []byte) error {
 	if err != nil {
 		return err
 	}
+	p.Extensions = nil
+	for len(data) > 0 {
+		var tag uint16
+		var value []byte
+		tag, data, err = notppackets.DeserializeUint16(data, notppackets.PacketNullByte)
+		if err != nil {
+			return err
+		}
+		value, data, err = notppackets.DeserializeDelimitedBytes(data, notppackets.PacketNullByte)
+		if err != nil {
+			return fmt.Errorf("notp: invalid extension %d: %w", tag, err)
+		}
+		if p.HasExtension(tag) {
+			return fmt.Errorf("notp: duplicated extension %d", tag)
+		}
+		p.SetExtension(tag, slices.Clone(value))
+	}
 	return nil
 }
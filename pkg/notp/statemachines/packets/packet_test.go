@@ -20,6 +20,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
 )
 
 // TestStatePacket tests the state packet.
@@ -43,3 +45,81 @@ func TestStatePacket(t *testing.T) {
 	assert.Equal(stateInput.MessageValue, stateOutput.MessageValue)
 	assert.Equal(stateInput.ErrorCode, stateOutput.ErrorCode)
 }
+
+// TestStatePacketWithCodec tests that the codec serializes the state packet as the hand-written serializer.
+func TestStatePacketWithCodec(t *testing.T) {
+	assert := assert.New(t)
+
+	type taggedStatePacket struct {
+		MessageCode  uint16 `notp:"1"`
+		MessageValue uint64 `notp:"2"`
+		ErrorCode    uint16 `notp:"3"`
+	}
+	stateInput := &StatePacket{
+		MessageCode:  111,
+		MessageValue: 0xFFFFFFFFFFFFFFFF,
+		ErrorCode:    333,
+	}
+	expected, err := stateInput.Serialize()
+	assert.NoError(err)
+	data, err := notppackets.Marshal(&taggedStatePacket{MessageCode: 111, MessageValue: 0xFFFFFFFFFFFFFFFF, ErrorCode: 333})
+	assert.NoError(err)
+	assert.Equal(expected, data)
+
+	tagged := &taggedStatePacket{}
+	assert.NoError(notppackets.Unmarshal(expected, tagged))
+	assert.Equal(stateInput.MessageValue, tagged.MessageValue)
+}
+
+// TestStatePacketWithExtensions tests the state packet with the extensions.
+func TestStatePacketWithExtensions(t *testing.T) {
+	assert := assert.New(t)
+
+	stateInput := &StatePacket{
+		MessageCode:  StartFlowMessage,
+		MessageValue: 222,
+		ErrorCode:    0,
+	}
+	legacyData, err := stateInput.Serialize()
+	assert.NoError(err)
+	stateInput.SetUint64Extension(SequenceNumberExtension, 0xFFFFFFFFFFFFFFFF)
+	stateInput.SetStringExtension(ReasonExtension, "flow rejected")
+	stateInput.SetExtension(1000, []byte{})
+	data, err := stateInput.Serialize()
+	assert.NoError(err)
+	assert.Equal(legacyData, data[:len(legacyData)])
+
+	stateOutput := &StatePacket{}
+	err = stateOutput.Deserialize(data)
+	assert.NoError(err)
+	assert.Equal(stateInput, stateOutput)
+	sequenceNumber, ok := stateOutput.GetUint64Extension(SequenceNumberExtension)
+	assert.True(ok)
+	assert.Equal(uint64(0xFFFFFFFFFFFFFFFF), sequenceNumber)
+	reason, ok := stateOutput.GetStringExtension(ReasonExtension)
+	assert.True(ok)
+	assert.Equal("flow rejected", reason)
+	assert.True(stateOutput.HasExtension(1000))
+	_, ok = stateOutput.GetUint64Extension(ReasonExtension)
+	assert.False(ok)
+	stateOutput.RemoveExtension(1000)
+	assert.False(stateOutput.HasExtension(1000))
+
+	legacyOutput := &struct {
+		MessageCode  uint16 `notp:"1"`
+		MessageValue uint64 `notp:"2"`
+		ErrorCode    uint16 `notp:"3"`
+	}{}
+	err = notppackets.Unmarshal(data, legacyOutput)
+	assert.NoError(err)
+	assert.Equal(stateInput.MessageValue, legacyOutput.MessageValue)
+
+	stateOutput = &StatePacket{}
+	err = stateOutput.Deserialize(legacyData)
+	assert.NoError(err)
+	assert.Nil(stateOutput.Extensions)
+
+	duplicatedData := append(data, data[len(legacyData):]...)
+	assert.Error((&StatePacket{}).Deserialize(duplicatedData))
+	assert.Error((&StatePacket{}).Deserialize(data[:len(data)-1]))
+}
@@ -149,10 +149,11 @@ func processStartFlowState(runtime *StateMachineRuntimeContext) (*StateTransitio
 	if err != nil {
 		return nil, fmt.Errorf("notp: process start flow failed to receive and handle start flow packet: %w", err)
 	}
-	flowPacket := &notpsmpackets.StatePacket{}
-	data, err := packetables[0].Serialize()
-	flowPacket.Deserialize(data)
-	if flowPacket.MessageCode != notpsmpackets.FlowIDValue {
+	if len(packetables) == 0 {
+		return nil, fmt.Errorf("notp: process start flow failed to receive flow packet")
+	}
+	flowPacket, err := convertStatePacket(packetables[0])
+	if err != nil || flowPacket.MessageCode != notpsmpackets.FlowIDValue {
 		return nil, fmt.Errorf("notp: process start flow failed to deserialize flow packet")
 	}
 	runtime.Set(FlowIDKey, flowPacket.MessageValue)
@@ -316,6 +317,7 @@ func subscriberNegotiationState(runtime *StateMachineRuntimeContext) (*StateTran
 	if !statePacket.HasAck() {
 		return nil, fmt.Errorf("notp: subscribe negotiation failed to receive ack in respond negotiation request packet")
 	}
+	runtime.flowControl.negotiate(statePacket)
 	stateID := SubscriberDataStreamStateID
 	return &StateTransitionInfo{
 		Runtime: runtime,
@@ -325,13 +327,14 @@ func subscriberNegotiationState(runtime *StateMachineRuntimeContext) (*StateTran
 
 // submitNegotiationResponse state to submit negotiation response.
 func publisherNegotiationState(runtime *StateMachineRuntimeContext) (*StateTransitionInfo, error) {
-	_, packetables, terminate, err := receiveAndHandleStatePacket(runtime, notpsmpackets.NegotiationRequestMessage)
+	statePacket, packetables, terminate, err := receiveAndHandleStatePacket(runtime, notpsmpackets.NegotiationRequestMessage)
 	if terminate {
 		return terminateWithFinal(runtime)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("notp: publusher negotiation failed to receive and handle notify current state packet: %w", err)
 	}
+	runtime.flowControl.negotiate(statePacket)
 	_, terminate, err = createAndHandleAndStreamStatePacket(runtime, notpsmpackets.RespondNegotiationRequestMessage, packetables)
 	if terminate {
 		return terminateWithFinal(runtime)
@@ -358,6 +361,9 @@ func subscriberDataStreamState(runtime *StateMachineRuntimeContext) (*StateTrans
 			return nil, fmt.Errorf("notp: subscriber data stream failed to receive and handle exchange data stream packet: %w", err)
 		}
 		hasStream = statePacket.HasActiveDataStream()
+		if err := runtime.flowControl.grant(runtime, statePacket); err != nil && !isClosedError(err) {
+			return nil, fmt.Errorf("notp: subscriber data stream failed to send grant: %w", err)
+		}
 	}
 	return &StateTransitionInfo{
 		Runtime: runtime,
@@ -17,6 +17,7 @@
 package statemachines
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
@@ -28,60 +29,39 @@ import (
 	notptransport "github.com/permguard/permguard-notp-protocol/pkg/notp/transport"
 )
 
-// stateMachinesInfo represents the state machines and their respective packet logs.
+// stateMachinesInfo represents the state machines and their respective endpoints and transport layers.
 type stateMachinesInfo struct {
-	follower         *StateMachine
-	followerSent     []notppackets.Packet
-	followerReceived []notppackets.Packet
+	followerEndpoint  *notptransport.InMemoryPipeEndpoint
+	followerTransport *notptransport.TransportLayer
+	follower          *StateMachine
 
-	leader         *StateMachine
-	leaderSent     []notppackets.Packet
-	leaderReceived []notppackets.Packet
+	leaderEndpoint  *notptransport.InMemoryPipeEndpoint
+	leaderTransport *notptransport.TransportLayer
+	leader          *StateMachine
 }
 
 // buildCommitStateMachines initializes and returns the follower and leader state machines.
-func buildCommitStateMachines(assert *assert.Assertions, followerHandler HostHandler, leaderHandler HostHandler) *stateMachinesInfo {
-	sMInfo := &stateMachinesInfo{
-		followerSent:     []notppackets.Packet{},
-		followerReceived: []notppackets.Packet{},
-		leaderSent:       []notppackets.Packet{},
-		leaderReceived:   []notppackets.Packet{},
-	}
-
-	onFollowerSent := func(packet *notppackets.Packet) {
-		sMInfo.followerSent = append(sMInfo.followerSent, *packet)
-	}
-	onFollowerReceived := func(packet *notppackets.Packet) {
-		sMInfo.followerReceived = append(sMInfo.followerReceived, *packet)
-	}
-
-	onLeaderSent := func(packet *notppackets.Packet) {
-		sMInfo.leaderSent = append(sMInfo.leaderSent, *packet)
-	}
-	onLeaderReceived := func(packet *notppackets.Packet) {
-		sMInfo.leaderReceived = append(sMInfo.leaderReceived, *packet)
-	}
+func buildCommitStateMachines(assert *assert.Assertions, followerHandler HostHandler, leaderHandler HostHandler, opts ...StateMachineOption) *stateMachinesInfo {
+	sMInfo := &stateMachinesInfo{}
 
-	followerStream, err := notptransport.NewInMemoryStream(5 * time.Second)
-	assert.Nil(err, "Failed to initialize the follower transport stream")
-	leaderStream, err := notptransport.NewInMemoryStream(5 * time.Second)
-	assert.Nil(err, "Failed to initialize the leader transport stream")
+	followerEndpoint, leaderEndpoint, err := notptransport.NewInMemoryPipe(notptransport.WithPipeHistory(100))
+	assert.Nil(err, "Failed to initialize the in-memory pipe")
+	sMInfo.followerEndpoint = followerEndpoint
+	sMInfo.leaderEndpoint = leaderEndpoint
 
-	followerPacketLogger, err := notptransport.NewPacketInspector(onFollowerSent, onFollowerReceived)
-	assert.Nil(err, "Failed to initialize the follower packet logger")
-	followerTransport, err := notptransport.NewTransportLayer(leaderStream.TransmitPacket, followerStream.ReceivePacket, followerPacketLogger)
+	followerTransport, err := notptransport.NewStreamTransportLayer(followerEndpoint, nil)
 	assert.Nil(err, "Failed to initialize the follower transport layer")
+	sMInfo.followerTransport = followerTransport
 
-	leaderPacketLogger, err := notptransport.NewPacketInspector(onLeaderSent, onLeaderReceived)
-	assert.Nil(err, "Failed to initialize the leader packet logger")
-	leaderTransport, err := notptransport.NewTransportLayer(followerStream.TransmitPacket, leaderStream.ReceivePacket, leaderPacketLogger)
+	leaderTransport, err := notptransport.NewStreamTransportLayer(leaderEndpoint, nil)
 	assert.Nil(err, "Failed to initialize the leader transport layer")
+	sMInfo.leaderTransport = leaderTransport
 
-	followerSMachine, err := NewFollowerStateMachine(followerHandler, followerTransport)
+	followerSMachine, err := NewFollowerStateMachine(followerHandler, followerTransport, opts...)
 	assert.Nil(err, "Failed to initialize the follower state machine")
 	sMInfo.follower = followerSMachine
 
-	leaderSMachine, err := NewLeaderStateMachine(leaderHandler, leaderTransport)
+	leaderSMachine, err := NewLeaderStateMachine(leaderHandler, leaderTransport, opts...)
 	assert.Nil(err, "Failed to initialize the leader state machine")
 	sMInfo.leader = leaderSMachine
 
@@ -177,6 +157,7 @@ func TestPullProtocolExecution(t *testing.T) {
 			followerHandler := func(handlerCtx *HandlerContext, statePacket *notpsmpackets.StatePacket, packets []notppackets.Packetable) (*HostHandlerReturn, error) {
 				currentStateID := handlerCtx.GetCurrentStateID()
 				followerIDs = append(followerIDs, currentStateID)
+				assert.Equal(notppackets.LatestProtocolVersion, handlerCtx.GetProtocolVersion(), "Follower protocol version")
 				packet := &notppackets.Packet{
 					Data: []byte("sample data"),
 				}
@@ -203,6 +184,7 @@ func TestPullProtocolExecution(t *testing.T) {
 			leaderHandler := func(handlerCtx *HandlerContext, statePacket *notpsmpackets.StatePacket, packets []notppackets.Packetable) (*HostHandlerReturn, error) {
 				currentStateID := handlerCtx.GetCurrentStateID()
 				leaderIDs = append(leaderIDs, currentStateID)
+				assert.Equal(notppackets.LatestProtocolVersion, handlerCtx.GetProtocolVersion(), "Leader protocol version")
 				handlerReturn := &HostHandlerReturn{
 					Packetables: packets,
 				}
@@ -243,10 +225,10 @@ func TestPullProtocolExecution(t *testing.T) {
 
 			wg.Wait()
 
-			assert.Len(sMInfo.followerSent, test.followerSent, "Follower sent packets")
-			assert.Len(sMInfo.followerReceived, test.followerReceived, "Follower received packets")
-			assert.Len(sMInfo.leaderSent, test.leaderSent, "Leader sent packets")
-			assert.Len(sMInfo.leaderReceived, test.leaderReceived, "Leader received packets")
+			assert.Len(sMInfo.followerEndpoint.GetSentPackets(), test.followerSent, "Follower sent packets")
+			assert.Len(sMInfo.followerEndpoint.GetReceivedPackets(), test.followerReceived, "Follower received packets")
+			assert.Len(sMInfo.leaderEndpoint.GetSentPackets(), test.leaderSent, "Leader sent packets")
+			assert.Len(sMInfo.leaderEndpoint.GetReceivedPackets(), test.leaderReceived, "Leader received packets")
 
 			for i, id := range followerIDs {
 				assert.Equal(test.expectedFollowerIDs[i], id, "Follower state ID")
@@ -257,3 +239,187 @@ func TestPullProtocolExecution(t *testing.T) {
 		})
 	}
 }
+
+// TestRunContextCancellation verifies that a cancelled follower stops promptly and notifies the termination to the leader.
+func TestRunContextCancellation(t *testing.T) {
+	assert := assert.New(t)
+
+	handler := func(handlerCtx *HandlerContext, statePacket *notpsmpackets.StatePacket, packets []notppackets.Packetable) (*HostHandlerReturn, error) {
+		assert.NotNil(handlerCtx.GetContext())
+		return &HostHandlerReturn{
+			MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.UnknownValue),
+		}, nil
+	}
+	sMInfo := buildCommitStateMachines(assert, handler, handler)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := sMInfo.follower.RunContext(ctx, nil, PullFlowType)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Less(time.Since(start), time.Second)
+	assert.Len(sMInfo.followerEndpoint.GetSentPackets(), 2, "Follower sent packets")
+
+	runtime, err := sMInfo.leader.Run(nil, UnknownFlowType)
+	assert.Nil(err, "Failed to run the leader state machine")
+	assert.True(runtime.IsFinal())
+	assert.Len(sMInfo.leaderEndpoint.GetReceivedPackets(), 2, "Leader received packets")
+}
+
+// TestClosedTransportTermination verifies that closing the transport terminates both state machines cleanly.
+func TestClosedTransportTermination(t *testing.T) {
+	assert := assert.New(t)
+
+	handler := func(handlerCtx *HandlerContext, statePacket *notpsmpackets.StatePacket, packets []notppackets.Packetable) (*HostHandlerReturn, error) {
+		return &HostHandlerReturn{
+			MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.UnknownValue),
+		}, nil
+	}
+	sMInfo := buildCommitStateMachines(assert, handler, handler)
+
+	time.AfterFunc(20*time.Millisecond, func() { _ = sMInfo.followerTransport.Close() })
+	start := time.Now()
+	runtime, err := sMInfo.follower.Run(nil, PullFlowType)
+	assert.Nil(err, "Failed to run the follower state machine")
+	assert.True(runtime.IsFinal())
+	assert.Less(time.Since(start), time.Second)
+
+	runtime, err = sMInfo.leader.Run(nil, UnknownFlowType)
+	assert.Nil(err, "Failed to run the leader state machine")
+	assert.True(runtime.IsFinal())
+	assert.Len(sMInfo.leaderEndpoint.GetReceivedPackets(), 1, "Leader received packets")
+	assert.Empty(sMInfo.leaderEndpoint.GetSentPackets(), "Leader sent packets")
+}
+
+// TestMultiplexedFlows verifies that several flows run concurrently over multiplexed streams of a single connection.
+func TestMultiplexedFlows(t *testing.T) {
+	assert := assert.New(t)
+
+	followerEndpoint, leaderEndpoint, err := notptransport.NewInMemoryPipe()
+	assert.Nil(err, "Failed to initialize the in-memory pipe")
+	followerMux, err := notptransport.NewStreamMultiplexer(followerEndpoint, true)
+	assert.Nil(err, "Failed to initialize the follower multiplexer")
+	defer followerMux.Close()
+	leaderMux, err := notptransport.NewStreamMultiplexer(leaderEndpoint, false)
+	assert.Nil(err, "Failed to initialize the leader multiplexer")
+	defer leaderMux.Close()
+
+	buildHandler := func(chunks int) HostHandler {
+		return func(handlerCtx *HandlerContext, statePacket *notpsmpackets.StatePacket, packets []notppackets.Packetable) (*HostHandlerReturn, error) {
+			switch handlerCtx.GetCurrentStateID() {
+			case SubscriberDataStreamStateID:
+				return &HostHandlerReturn{MessageValue: statePacket.MessageValue}, nil
+			case PublisherDataStreamStateID:
+				chunks--
+				if chunks > 0 {
+					return &HostHandlerReturn{
+						MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.ActiveDataStreamValue),
+						Packetables:  []notppackets.Packetable{&notppackets.Packet{Data: make([]byte, 4096)}},
+						HasMore:      true,
+					}, nil
+				}
+				return &HostHandlerReturn{MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.CompletedDataStreamValue)}, nil
+			}
+			return &HostHandlerReturn{MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.UnknownValue)}, nil
+		}
+	}
+
+	ctx := context.Background()
+	flows := []FlowType{PullFlowType, PushFlowType, PullFlowType, PushFlowType}
+	var wg sync.WaitGroup
+	for i, flow := range flows {
+		followerStream, err := followerMux.Open(ctx)
+		assert.Nil(err, "Failed to open the follower stream")
+		leaderStream, err := leaderMux.Accept(ctx)
+		assert.Nil(err, "Failed to accept the leader stream")
+
+		followerTransport, err := notptransport.NewStreamTransportLayer(followerStream, nil)
+		assert.Nil(err, "Failed to initialize the follower transport layer")
+		follower, err := NewFollowerStateMachine(buildHandler(10*(i+1)), followerTransport)
+		assert.Nil(err, "Failed to initialize the follower state machine")
+		leaderTransport, err := notptransport.NewStreamTransportLayer(leaderStream, nil)
+		assert.Nil(err, "Failed to initialize the leader transport layer")
+		leader, err := NewLeaderStateMachine(buildHandler(10*(i+1)), leaderTransport)
+		assert.Nil(err, "Failed to initialize the leader state machine")
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			runtime, err := follower.RunContext(ctx, nil, flow)
+			if assert.Nil(err, "Failed to run the follower state machine") {
+				assert.True(runtime.IsFinal())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			runtime, err := leader.RunContext(ctx, nil, UnknownFlowType)
+			if assert.Nil(err, "Failed to run the leader state machine") {
+				assert.True(runtime.IsFinal())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestKeepaliveFlows verifies that the keepalive layer tells a leader busy in its handler apart from a dead one.
+func TestKeepaliveFlows(t *testing.T) {
+	tests := []struct {
+		name       string
+		deadLeader bool
+	}{
+		{name: "BusyLeader"},
+		{name: "DeadLeader", deadLeader: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			handler := func(handlerCtx *HandlerContext, statePacket *notpsmpackets.StatePacket, packets []notppackets.Packetable) (*HostHandlerReturn, error) {
+				if handlerCtx.GetCurrentStateID() == PublisherDataStreamStateID {
+					time.Sleep(100 * time.Millisecond)
+				}
+				return &HostHandlerReturn{
+					MessageValue: notppackets.CombineUint32toUint64(notpsmpackets.AcknowledgedValue, notpsmpackets.UnknownValue),
+				}, nil
+			}
+
+			followerEndpoint, leaderEndpoint, err := notptransport.NewInMemoryPipe(notptransport.WithPipeBufferSize(100))
+			assert.Nil(err, "Failed to initialize the in-memory pipe")
+			keepalive := notptransport.WithKeepalive(5*time.Millisecond, 40*time.Millisecond)
+			followerKeepalive, err := notptransport.NewStreamKeepaliveLayer(followerEndpoint, keepalive)
+			assert.Nil(err, "Failed to initialize the follower keepalive layer")
+			defer followerKeepalive.Close()
+			followerTransport, err := notptransport.NewStreamTransportLayer(followerKeepalive, nil)
+			assert.Nil(err, "Failed to initialize the follower transport layer")
+			follower, err := NewFollowerStateMachine(handler, followerTransport)
+			assert.Nil(err, "Failed to initialize the follower state machine")
+
+			var wg sync.WaitGroup
+			if !test.deadLeader {
+				leaderKeepalive, err := notptransport.NewStreamKeepaliveLayer(leaderEndpoint, keepalive)
+				assert.Nil(err, "Failed to initialize the leader keepalive layer")
+				defer leaderKeepalive.Close()
+				leaderTransport, err := notptransport.NewStreamTransportLayer(leaderKeepalive, nil)
+				assert.Nil(err, "Failed to initialize the leader transport layer")
+				leader, err := NewLeaderStateMachine(handler, leaderTransport)
+				assert.Nil(err, "Failed to initialize the leader state machine")
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := leader.Run(nil, UnknownFlowType)
+					assert.Nil(err, "Failed to run the leader state machine")
+				}()
+			}
+
+			runtime, err := follower.Run(nil, PullFlowType)
+			wg.Wait()
+			if test.deadLeader {
+				assert.ErrorIs(err, notptransport.ErrIdleTimeout)
+				return
+			}
+			if assert.Nil(err, "Failed to run the follower state machine") {
+				assert.True(runtime.IsFinal())
+			}
+			assert.Positive(followerKeepalive.GetRTT())
+		})
+	}
+}
@@ -17,7 +17,10 @@
 package statemachines
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
 	notpsmpackets "github.com/permguard/permguard-notp-protocol/pkg/notp/statemachines/packets"
@@ -27,13 +30,23 @@ import (
 const (
 	FinalStateID   = uint16(1)
 	InitialStateID = uint16(2)
+
+	// terminationTimeout represents the time granted to notify the termination to the peer once the context is done.
+	terminationTimeout = time.Second
 )
 
 // HandlerContext holds the context of the handler.
 type HandlerContext struct {
-	flow           FlowType
-	currentStateID uint16
-	bag            map[string]interface{}
+	ctx             context.Context
+	flow            FlowType
+	currentStateID  uint16
+	protocolVersion uint32
+	bag             map[string]interface{}
+}
+
+// GetContext returns the context the state machine is running with.
+func (h *HandlerContext) GetContext() context.Context {
+	return h.ctx
 }
 
 // GetFlowType returns the flow type of the handler context.
@@ -46,6 +59,11 @@ func (h *HandlerContext) GetCurrentStateID() uint16 {
 	return h.currentStateID
 }
 
+// GetProtocolVersion returns the protocol version negotiated with the peer.
+func (h *HandlerContext) GetProtocolVersion() uint32 {
+	return h.protocolVersion
+}
+
 // Set stores a key-value pair in the runtime context of the state machine.
 func (h *HandlerContext) Set(key string, value interface{}) {
 	if h.bag == nil {
@@ -105,77 +123,118 @@ func FinalState(runtime *StateMachineRuntimeContext) (*StateTransitionInfo, erro
 
 // StateMachineRuntimeContext holds the runtime context of the state machine.
 type StateMachineRuntimeContext struct {
-	inputValue     uint64
-	isFinal        bool
-	flowType       FlowType
-	transportLayer *notptransport.TransportLayer
-	statemap       map[uint16]StateTransitionFunc
-	initialStateID uint16
-	currentStateID uint16
-	hostHandler    HostHandler
-	bag            map[string]interface{}
+	ctx               context.Context
+	inputValue        uint64
+	isFinal           bool
+	flowType          FlowType
+	transportLayer    *notptransport.TransportLayer
+	statemap          map[uint16]StateTransitionFunc
+	initialStateID    uint16
+	currentStateID    uint16
+	hostHandler       HostHandler
+	bag               map[string]interface{}
+	flowControlWindow FlowControlWindow
+	flowControl       *flowControlState
+}
+
+// withContext returns the state machine runtime context with the context.
+func (t *StateMachineRuntimeContext) withContext(ctx context.Context) *StateMachineRuntimeContext {
+	return &StateMachineRuntimeContext{
+		ctx:               ctx,
+		inputValue:        t.inputValue,
+		isFinal:           t.isFinal,
+		flowType:          t.flowType,
+		transportLayer:    t.transportLayer,
+		statemap:          t.statemap,
+		initialStateID:    t.initialStateID,
+		currentStateID:    t.currentStateID,
+		hostHandler:       t.hostHandler,
+		bag:               t.bag,
+		flowControlWindow: t.flowControlWindow,
+		flowControl:       t.flowControl,
+	}
 }
 
 // WithInput returns the state machine runtime context with the input value.
 func (t *StateMachineRuntimeContext) WithInput(inputValue uint64) *StateMachineRuntimeContext {
 	return &StateMachineRuntimeContext{
-		inputValue:     inputValue,
-		isFinal:        t.isFinal,
-		flowType:       t.flowType,
-		transportLayer: t.transportLayer,
-		statemap:       t.statemap,
-		initialStateID: t.initialStateID,
-		currentStateID: t.currentStateID,
-		hostHandler:    t.hostHandler,
-		bag:            t.bag,
+		ctx:               t.ctx,
+		inputValue:        inputValue,
+		isFinal:           t.isFinal,
+		flowType:          t.flowType,
+		transportLayer:    t.transportLayer,
+		statemap:          t.statemap,
+		initialStateID:    t.initialStateID,
+		currentStateID:    t.currentStateID,
+		hostHandler:       t.hostHandler,
+		bag:               t.bag,
+		flowControlWindow: t.flowControlWindow,
+		flowControl:       t.flowControl,
 	}
 }
 
 // WithFlow returns the state machine runtime context with the flow type.
 func (t *StateMachineRuntimeContext) WithFlow(flowType FlowType) *StateMachineRuntimeContext {
 	return &StateMachineRuntimeContext{
-		inputValue:     t.inputValue,
-		isFinal:        t.isFinal,
-		flowType:       flowType,
-		transportLayer: t.transportLayer,
-		statemap:       t.statemap,
-		initialStateID: t.initialStateID,
-		currentStateID: t.currentStateID,
-		hostHandler:    t.hostHandler,
-		bag:            t.bag,
+		ctx:               t.ctx,
+		inputValue:        t.inputValue,
+		isFinal:           t.isFinal,
+		flowType:          flowType,
+		transportLayer:    t.transportLayer,
+		statemap:          t.statemap,
+		initialStateID:    t.initialStateID,
+		currentStateID:    t.currentStateID,
+		hostHandler:       t.hostHandler,
+		bag:               t.bag,
+		flowControlWindow: t.flowControlWindow,
+		flowControl:       t.flowControl,
 	}
 }
 
 // withCurrentState returns the state machine runtime context with the current state.
 func (t *StateMachineRuntimeContext) withCurrentState(currentStateID uint16) *StateMachineRuntimeContext {
 	return &StateMachineRuntimeContext{
-		inputValue:     t.inputValue,
-		isFinal:        t.isFinal,
-		flowType:       t.flowType,
-		transportLayer: t.transportLayer,
-		statemap:       t.statemap,
-		initialStateID: t.initialStateID,
-		currentStateID: currentStateID,
-		hostHandler:    t.hostHandler,
-		bag:            t.bag,
+		ctx:               t.ctx,
+		inputValue:        t.inputValue,
+		isFinal:           t.isFinal,
+		flowType:          t.flowType,
+		transportLayer:    t.transportLayer,
+		statemap:          t.statemap,
+		initialStateID:    t.initialStateID,
+		currentStateID:    currentStateID,
+		hostHandler:       t.hostHandler,
+		bag:               t.bag,
+		flowControlWindow: t.flowControlWindow,
+		flowControl:       t.flowControl,
 	}
 }
 
 // WithFinal returns the state machine runtime context with the final state.
 func (t *StateMachineRuntimeContext) WithFinal() *StateMachineRuntimeContext {
 	return &StateMachineRuntimeContext{
-		inputValue:     t.inputValue,
-		isFinal:        true,
-		flowType:       t.flowType,
-		transportLayer: t.transportLayer,
-		statemap:       t.statemap,
-		initialStateID: t.initialStateID,
-		currentStateID: t.currentStateID,
-		hostHandler:    t.hostHandler,
-		bag:            t.bag,
+		ctx:               t.ctx,
+		inputValue:        t.inputValue,
+		isFinal:           true,
+		flowType:          t.flowType,
+		transportLayer:    t.transportLayer,
+		statemap:          t.statemap,
+		initialStateID:    t.initialStateID,
+		currentStateID:    t.currentStateID,
+		hostHandler:       t.hostHandler,
+		bag:               t.bag,
+		flowControlWindow: t.flowControlWindow,
+		flowControl:       t.flowControl,
 	}
 }
 
+// GetContext returns the context the state machine is running with.
+func (t *StateMachineRuntimeContext) GetContext() context.Context {
+	if t.ctx == nil {
+		return context.Background()
+	}
+	return t.ctx
+}
+
 // IsFinal returns true if the state machine is in the final state.
 func (t *StateMachineRuntimeContext) IsFinal() bool {
 	return t.isFinal
@@ -191,6 +250,11 @@ func (t *StateMachineRuntimeContext) GetCurrentStateID() uint16 {
 	return t.currentStateID
 }
 
+// GetProtocolVersion returns the protocol version negotiated with the peer.
+func (t *StateMachineRuntimeContext) GetProtocolVersion() uint32 {
+	return t.transportLayer.GetProtocolVersion()
+}
+
 // Set stores a key-value pair in the runtime context of the state machine.
 func (t *StateMachineRuntimeContext) Set(key string, value interface{}) {
 	if t.bag == nil {
@@ -215,7 +279,7 @@ func (t *StateMachineRuntimeContext) Send(packetable notppackets.Packetable) err
 
 // SendStream sends a packets through the transport layer.
 func (t *StateMachineRuntimeContext) SendStream(packetables []notppackets.Packetable) error {
-	return t.transportLayer.TransmitPacket(packetables)
+	return t.transportLayer.TransmitPacketContext(t.GetContext(), packetables)
 }
 
 // Receive retrieves a packet from the transport layer.
@@ -234,7 +298,7 @@ func (t *StateMachineRuntimeContext) Receive() (notppackets.Packetable, error) {
 
 // ReceiveStream retrieves packets from the transport layer.
 func (t *StateMachineRuntimeContext) ReceiveStream() ([]notppackets.Packetable, error) {
-	return t.transportLayer.ReceivePacket()
+	return t.transportLayer.ReceivePacketContext(t.GetContext())
 }
 
 // Handle handles the packet for the state machine.
@@ -257,17 +321,30 @@ type StateMachine struct {
 
 // Run starts and runs the state machine through its states until termination.
 func (m *StateMachine) Run(bag map[string]any, inputValue FlowType) (*StateMachineRuntimeContext, error) {
+	return m.RunContext(context.Background(), bag, inputValue)
+}
+
+// RunContext starts and runs the state machine through its states until termination or until the context is done,
+// in which case the termination is notified to the peer where possible.
+func (m *StateMachine) RunContext(ctx context.Context, bag map[string]any, inputValue FlowType) (*StateMachineRuntimeContext, error) {
 	if bag != nil {
 		m.runtime.bag = bag
 	}
-	runtime := m.runtime
+	runtime := m.runtime.withContext(ctx)
+	runtime.flowControl = &flowControlState{}
 	runtime = runtime.WithFlow(inputValue)
 	stateID := runtime.initialStateID
 	state := m.runtime.statemap[runtime.initialStateID]
 	for state != nil {
+		if ctx.Err() != nil {
+			return nil, cancelRun(runtime)
+		}
 		runtime = runtime.withCurrentState(stateID)
 		nextStateInfo, err := state(runtime)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, cancelRun(runtime)
+			}
 			return nil, err
 		}
 		runtime = nextStateInfo.Runtime
@@ -280,8 +357,19 @@ func (m *StateMachine) Run(bag map[string]any, inputValue FlowType) (*StateMachi
 	return runtime, nil
 }
 
+// cancelRun notifies the termination to the peer on a context detached from the done one and returns the cancellation error.
+func cancelRun(runtime *StateMachineRuntimeContext) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(runtime.ctx), terminationTimeout)
+	defer cancel()
+	err := sendTermination(runtime.withContext(ctx))
+	return errors.Join(fmt.Errorf("notp: state machine interrupted: %w", context.Cause(runtime.ctx)), err)
+}
+
+// StateMachineOption defines a function to configure the state machine.
+type StateMachineOption func(*StateMachine) error
+
 // NewStateMachine creates and initializes a new state machine with the given initial state and transport layer.
-func NewStateMachine(statemap map[uint16]StateTransitionFunc, initialStateID uint16, hostHandler HostHandler, transportLayer *notptransport.TransportLayer) (*StateMachine, error) {
+func NewStateMachine(statemap map[uint16]StateTransitionFunc, initialStateID uint16, hostHandler HostHandler, transportLayer *notptransport.TransportLayer, opts ...StateMachineOption) (*StateMachine, error) {
 	if statemap == nil {
 		return nil, errors.New("notp: state map cannot be nil")
 	}
@@ -294,7 +382,11 @@ func NewStateMachine(statemap map[uint16]StateTransitionFunc, initialStateID uin
 	if transportLayer == nil {
 		return nil, errors.New("notp: transport layer cannot be nil")
 	}
-	return &StateMachine{
+	err := transportLayer.GetPacketRegistry().Register(func() notppackets.Packetable { return &notpsmpackets.StatePacket{} })
+	if err != nil && !errors.Is(err, notppackets.ErrPacketTypeAlreadyRegistered) {
+		return nil, err
+	}
+	stateMachine := &StateMachine{
 		runtime: &StateMachineRuntimeContext{
 			inputValue:     0,
 			isFinal:        false,
@@ -305,5 +397,11 @@ func NewStateMachine(statemap map[uint16]StateTransitionFunc, initialStateID uin
 			currentStateID: initialStateID,
 			hostHandler:    hostHandler,
 		},
-	}, nil
+	}
+	for _, opt := range opts {
+		if err := opt(stateMachine); err != nil {
+			return nil, err
+		}
+	}
+	return stateMachine, nil
 }
@@ -17,18 +17,28 @@
 package statemachines
 
 import (
+	"errors"
 	"fmt"
+	"io"
 
 	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
 	notpsmpackets "github.com/permguard/permguard-notp-protocol/pkg/notp/statemachines/packets"
+	notptransport "github.com/permguard/permguard-notp-protocol/pkg/notp/transport"
 )
 
+// isClosedError checks if the error is caused by the transport being closed locally or by the peer.
+func isClosedError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, notptransport.ErrClosed)
+}
+
 // createStatePacket creates a state packet.
 func createStatePacket(runtime *StateMachineRuntimeContext, messageCode uint16, messageValue uint64) (*notpsmpackets.StatePacket, *HandlerContext, error) {
 	handlerCtx := &HandlerContext{
-		flow:           runtime.GetFlowType(),
-		bag:            runtime.bag,
-		currentStateID: runtime.GetCurrentStateID(),
+		ctx:             runtime.GetContext(),
+		flow:            runtime.GetFlowType(),
+		bag:             runtime.bag,
+		currentStateID:  runtime.GetCurrentStateID(),
+		protocolVersion: runtime.GetProtocolVersion(),
 	}
 	packet := &notpsmpackets.StatePacket{
 		MessageCode:  messageCode,
@@ -38,9 +48,25 @@ func createStatePacket(runtime *StateMachineRuntimeContext, messageCode uint16,
 	return packet, handlerCtx, nil
 }
 
+// convertStatePacket converts a received packet to a state packet.
+func convertStatePacket(packetable notppackets.Packetable) (*notpsmpackets.StatePacket, error) {
+	if statePacket, ok := packetable.(*notpsmpackets.StatePacket); ok {
+		return statePacket, nil
+	}
+	if _, ok := packetable.(*notppackets.UnknownPacket); ok {
+		return nil, fmt.Errorf("notp: received packet of type %d instead of a state packet", packetable.GetType())
+	}
+	statePacket := &notpsmpackets.StatePacket{}
+	if err := notppackets.ConvertPacketable(packetable, statePacket); err != nil {
+		return nil, fmt.Errorf("notp: failed to convert state packet: %w", err)
+	}
+	return statePacket, nil
+}
+
 // shouldHandlePacket checks if the packet should be handled.
 func shouldHandlePacket(packet *notpsmpackets.StatePacket) bool {
-	return packet.MessageCode != notpsmpackets.ActionResponseMessage && packet.MessageCode != notpsmpackets.StartFlowMessage
+	return packet.MessageCode != notpsmpackets.ActionResponseMessage && packet.MessageCode != notpsmpackets.StartFlowMessage &&
+		packet.MessageCode != notpsmpackets.GrantDataStreamMessage
 }
 
 // createAndHandleStatePacket creates a state packet and handles it.
@@ -93,14 +119,32 @@ func createAndHandleAndStreamStatePacketWithValue(runtime *StateMachineRuntimeCo
 			err := sendTermination(runtime)
 			return nil, false, fmt.Errorf("notp: failed to create and handle packet: %w", err)
 		}
+		if messageCode == notpsmpackets.ExchangeDataStreamMessage && handlerHasMore != statePacket.HasActiveDataStream() {
+			// The subscriber goes on with the data stream while its state packets hold an active data stream.
+			return nil, false, errors.Join(errors.New("notp: handler more data disagrees with the data stream state"), sendTermination(runtime))
+		}
 		hasMore = handlerHasMore
 		packet = statePacket
+		packetables, err = runtime.flowControl.prepare(runtime, statePacket, packetables)
+		if err != nil {
+			return nil, false, errors.Join(fmt.Errorf("notp: failed to prepare packet: %w", err), sendTermination(runtime))
+		}
 		streamPacketables := append([]notppackets.Packetable{statePacket}, packetables...)
 		err = runtime.SendStream(streamPacketables)
+		if isClosedError(err) {
+			return nil, true, nil
+		}
 		if err != nil {
 			err := sendTermination(runtime)
 			return nil, false, err
 		}
+		terminate, err = runtime.flowControl.awaitGrant(runtime, statePacket)
+		if terminate {
+			return nil, true, nil
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("notp: failed to receive data stream grant: %w", err)
+		}
 	}
 	return packet, false, nil
 }
@@ -111,28 +155,34 @@ func sendTermination(runtime *StateMachineRuntimeContext) error {
 		MessageCode: notpsmpackets.TerminateMessage,
 	}
 	err := runtime.Send(statePacket)
+	if isClosedError(err) {
+		return nil
+	}
 	return err
 }
 
 // receiveAndHandleStatePacket receives a state packet and handles it.
 func receiveAndHandleStatePacket(runtime *StateMachineRuntimeContext, expectedMessageCode uint16) (*notpsmpackets.StatePacket, []notppackets.Packetable, bool, error) {
-	handlerCtx := &HandlerContext{
-		flow:           runtime.GetFlowType(),
-		bag:            runtime.bag,
-		currentStateID: runtime.GetCurrentStateID(),
-	}
 	packetsStream, err := runtime.ReceiveStream()
+	if isClosedError(err) {
+		return nil, nil, true, nil
+	}
 	if err != nil {
 		return nil, nil, false, fmt.Errorf("notp: failed to receive packets: %w", err)
 	}
-	statePacket := &notpsmpackets.StatePacket{}
-	data, err := packetsStream[0].Serialize()
-	if err != nil {
-		return nil, nil, false, fmt.Errorf("notp: failed to serialize packet: %w", err)
+	handlerCtx := &HandlerContext{
+		ctx:             runtime.GetContext(),
+		flow:            runtime.GetFlowType(),
+		bag:             runtime.bag,
+		currentStateID:  runtime.GetCurrentStateID(),
+		protocolVersion: runtime.GetProtocolVersion(),
+	}
+	if len(packetsStream) == 0 {
+		return nil, nil, false, errors.New("notp: received an empty packet stream")
 	}
-	err = statePacket.Deserialize(data)
+	statePacket, err := convertStatePacket(packetsStream[0])
 	if err != nil {
-		return nil, nil, false, fmt.Errorf("notp: failed to deserialize state packet: %w", err)
+		return nil, nil, false, err
 	}
 	if statePacket.HasError() {
 		return nil, nil, false, fmt.Errorf("notp: received state packet with error: %d", statePacket.ErrorCode)
@@ -0,0 +1,168 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transport implements the transport layer of the NOTP protocol.
+package transport
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+const (
+	// NoCompression represents the identifier of the compressor which leaves the data unchanged.
+	NoCompression = notppackets.NoCompression
+	// FlateCompression represents the identifier of the DEFLATE compressor.
+	FlateCompression = uint32(1)
+	// GzipCompression represents the identifier of the gzip compressor.
+	GzipCompression = uint32(2)
+
+	// DefaultCompressionThreshold represents the default size in bytes below which packets are sent uncompressed.
+	DefaultCompressionThreshold = 256
+)
+
+// Compressor defines a codec used to compress the data stream of the packets.
+type Compressor interface {
+	// GetID returns the identifier of the compressor announced in the protocol packet.
+	GetID() uint32
+	// Compress compresses the data.
+	Compress(data []byte) ([]byte, error)
+	// Decompress decompresses the data failing with ErrLimitExceeded beyond the limit in bytes, where zero means unlimited.
+	Decompress(data []byte, limit uint64) ([]byte, error)
+}
+
+// noneCompressor is a compressor which leaves the data unchanged.
+type noneCompressor struct{}
+
+// GetID returns the identifier of the compressor.
+func (c *noneCompressor) GetID() uint32 {
+	return NoCompression
+}
+
+// Compress returns the data unchanged.
+func (c *noneCompressor) Compress(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// Decompress returns the data unchanged.
+func (c *noneCompressor) Decompress(data []byte, limit uint64) ([]byte, error) {
+	if limit > 0 && uint64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: decompressed packet exceeds the maximum of %d bytes", ErrLimitExceeded, limit)
+	}
+	return data, nil
+}
+
+// NewNoneCompressor creates a compressor which leaves the data unchanged.
+func NewNoneCompressor() Compressor {
+	return &noneCompressor{}
+}
+
+// flateCompressor is a compressor using the DEFLATE format.
+type flateCompressor struct {
+	level int
+}
+
+// GetID returns the identifier of the compressor.
+func (c *flateCompressor) GetID() uint32 {
+	return FlateCompression
+}
+
+// Compress compresses the data.
+func (c *flateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses the data.
+func (c *flateCompressor) Decompress(data []byte, limit uint64) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(data))
+	defer reader.Close()
+	return readAllLimited(reader, limit)
+}
+
+// NewFlateCompressor creates a compressor using the DEFLATE format with the input compression level.
+func NewFlateCompressor(level int) (Compressor, error) {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return nil, fmt.Errorf("notp: invalid flate compression level %d", level)
+	}
+	return &flateCompressor{level: level}, nil
+}
+
+// gzipCompressor is a compressor using the gzip format.
+type gzipCompressor struct {
+	level int
+}
+
+// GetID returns the identifier of the compressor.
+func (c *gzipCompressor) GetID() uint32 {
+	return GzipCompression
+}
+
+// Compress compresses the data.
+func (c *gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses the data.
+func (c *gzipCompressor) Decompress(data []byte, limit uint64) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readAllLimited(reader, limit)
+}
+
+// NewGzipCompressor creates a compressor using the gzip format with the input compression level.
+func NewGzipCompressor(level int) (Compressor, error) {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return nil, fmt.Errorf("notp: invalid gzip compression level %d", level)
+	}
+	return &gzipCompressor{level: level}, nil
+}
+
+// defaultCompressors returns the compressors supported by default in order of preference.
+func defaultCompressors() []Compressor {
+	return []Compressor{
+		&flateCompressor{level: flate.DefaultCompression},
+		&gzipCompressor{level: gzip.DefaultCompression},
+	}
+}
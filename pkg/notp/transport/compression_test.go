@@ -0,0 +1,143 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// TestCompressors tests that the built-in compressors decompress the data they compress.
+func TestCompressors(t *testing.T) {
+	assert := assert.New(t)
+
+	flateCompressor, err := NewFlateCompressor(9)
+	assert.Nil(err)
+	gzipCompressor, err := NewGzipCompressor(1)
+	assert.Nil(err)
+	data := bytes.Repeat([]byte("notp compression "), 64)
+	for _, compressor := range []Compressor{NewNoneCompressor(), flateCompressor, gzipCompressor} {
+		compressedData, err := compressor.Compress(data)
+		assert.Nil(err)
+		decompressedData, err := compressor.Decompress(compressedData, 0)
+		assert.Nil(err)
+		assert.Equal(data, decompressedData)
+		_, err = compressor.Decompress(compressedData, uint64(len(data)-1))
+		assert.ErrorIs(err, ErrLimitExceeded)
+	}
+
+	_, err = NewFlateCompressor(10)
+	assert.NotNil(err)
+	_, err = NewGzipCompressor(-3)
+	assert.NotNil(err)
+	_, err = NewTransportLayer(func(*notppackets.Packet) error { return nil }, func() (*notppackets.Packet, error) { return nil, nil }, nil,
+		WithCompressors(flateCompressor, flateCompressor))
+	assert.NotNil(err)
+}
+
+// TestTransmitPacketWithCompression tests that the packets are compressed with the negotiated compressor above the threshold.
+func TestTransmitPacketWithCompression(t *testing.T) {
+	gzipCompressor, _ := NewGzipCompressor(gzip.DefaultCompression)
+	tests := []struct {
+		name                string
+		leaderOpts          []TransportLayerOption
+		followerOpts        []TransportLayerOption
+		data                []byte
+		isLegacy            bool
+		expectedCompression uint32
+	}{
+		{
+			name:                "DefaultCompressor",
+			data:                bytes.Repeat([]byte("state"), 100),
+			expectedCompression: FlateCompression,
+		},
+		{
+			name:                "BelowThreshold",
+			data:                []byte("state"),
+			expectedCompression: NoCompression,
+		},
+		{
+			name:                "CustomThreshold",
+			followerOpts:        []TransportLayerOption{WithCompressionThreshold(0)},
+			data:                []byte("state"),
+			expectedCompression: FlateCompression,
+		},
+		{
+			name:                "PreferredCompressor",
+			followerOpts:        []TransportLayerOption{WithCompressors(gzipCompressor, NewNoneCompressor())},
+			data:                bytes.Repeat([]byte("state"), 100),
+			expectedCompression: GzipCompression,
+		},
+		{
+			name:                "NoCommonCompressor",
+			leaderOpts:          []TransportLayerOption{WithCompressors(NewNoneCompressor())},
+			data:                bytes.Repeat([]byte("state"), 100),
+			expectedCompression: NoCompression,
+		},
+		{
+			name:       "LegacyLeader",
+			leaderOpts: []TransportLayerOption{WithProtocolVersions(notppackets.ProtocolVersion1, notppackets.ProtocolVersion1)},
+			data:       []byte("state"),
+			isLegacy:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var sentData []byte
+			inspector, err := NewPacketInspector(func(packet *notppackets.Packet) { sentData = packet.Data }, nil)
+			assert.Nil(err)
+
+			leaderEndpoint, followerEndpoint, err := NewInMemoryPipe(WithPipeTimeout(time.Second))
+			assert.Nil(err)
+			leader, err := NewStreamTransportLayer(leaderEndpoint, nil, test.leaderOpts...)
+			assert.Nil(err)
+			follower, err := NewStreamTransportLayer(followerEndpoint, inspector, test.followerOpts...)
+			assert.Nil(err)
+
+			assert.Nil(follower.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("request")}}))
+			_, err = leader.ReceivePacket()
+			assert.Nil(err)
+			assert.Nil(leader.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("response")}}))
+			_, err = follower.ReceivePacket()
+			assert.Nil(err)
+
+			assert.Nil(follower.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: test.data}}))
+			packetables, err := leader.ReceivePacket()
+			assert.Nil(err)
+			assert.Len(packetables, 1)
+			assert.Equal(test.data, packetables[0].(*notppackets.Packet).Data)
+
+			if test.isLegacy {
+				assert.NotEqual(byte(uncompressedPacketMarker), sentData[0])
+				return
+			}
+			reader, err := notppackets.NewPacketReader(&notppackets.Packet{Data: sentData})
+			assert.Nil(err)
+			protocol, _, err := reader.ReadProtocolHeader()
+			assert.Nil(err)
+			assert.Equal(test.expectedCompression, protocol.Compression)
+		})
+	}
+}
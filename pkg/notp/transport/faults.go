@@ -0,0 +1,334 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transport implements the transport layer of the NOTP protocol.
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// Fault represents a fault injected into the packets flowing through a fault injector.
+type Fault uint8
+
+const (
+	// NoFault represents the absence of fault.
+	NoFault Fault = iota
+	// DropFault drops the packet.
+	DropFault
+	// DuplicateFault delivers the packet twice.
+	DuplicateFault
+	// ReorderFault holds the packet back and delivers it after the next one.
+	ReorderFault
+	// DelayFault delays the packet.
+	DelayFault
+	// CorruptFault flips a random bit of the packet.
+	CorruptFault
+)
+
+// String returns the name of the fault.
+func (f Fault) String() string {
+	switch f {
+	case NoFault:
+		return "none"
+	case DropFault:
+		return "drop"
+	case DuplicateFault:
+		return "duplicate"
+	case ReorderFault:
+		return "reorder"
+	case DelayFault:
+		return "delay"
+	case CorruptFault:
+		return "corrupt"
+	default:
+		return fmt.Sprintf("fault(%d)", uint8(f))
+	}
+}
+
+// PacketMatcher defines a function type selecting the packets a fault rule applies to.
+type PacketMatcher func(packet *notppackets.Packet) bool
+
+// MatchDataPacket returns a matcher calling the function with the codec, the type and the payload of the first data packet,
+// after decompressing the packet with the compressors, or with the default ones if none is given.
+func MatchDataPacket(match func(codecID uint32, packetType uint64, data []byte) bool, compressors ...Compressor) PacketMatcher {
+	if len(compressors) == 0 {
+		compressors = defaultCompressors()
+	}
+	decoder := &TransportLayer{compressors: slices.Clone(compressors)}
+	return func(packet *notppackets.Packet) bool {
+		packet = &notppackets.Packet{Data: bytes.Clone(packet.Data)}
+		if err := decoder.decodePacket(packet); err != nil {
+			return false
+		}
+		reader, err := notppackets.NewPacketReader(packet)
+		if err != nil {
+			return false
+		}
+		protocol, err := reader.ReadProtocol()
+		if err != nil {
+			return false
+		}
+		data, state, err := reader.ReadNextDataPacket(nil)
+		if err != nil {
+			return false
+		}
+		return match(protocol.Codec, state.GetPacketType(), data)
+	}
+}
+
+// FaultRule defines a fault and the packets it is injected into.
+type FaultRule struct {
+	// Fault is the fault to inject.
+	Fault Fault
+	// Probability is the probability of injecting the fault into a matching packet, ignored when Nth is set.
+	Probability float64
+	// Nth injects the fault into the Nth matching packet only, counting from one.
+	Nth uint64
+	// Match selects the packets the rule applies to, where nil matches every packet.
+	Match PacketMatcher
+	// Delay is the delay of the DelayFault.
+	Delay time.Duration
+}
+
+// FaultStats holds the number of faults injected by a fault injector.
+type FaultStats struct {
+	Dropped    uint64
+	Duplicated uint64
+	Reordered  uint64
+	Delayed    uint64
+	Corrupted  uint64
+}
+
+// faultDirection holds the state of one direction of the packets flowing through a fault injector.
+type faultDirection struct {
+	held  *notppackets.Packet
+	queue []*notppackets.Packet
+	mutex sync.Mutex
+}
+
+// FaultInjector injects faults into the packets flowing through the wrapped senders and receivers according to
+// rules evaluated in order, where the first rule firing wins. Its random choices derive from a seed, so that the
+// same sequence of packets always gets the same faults.
+type FaultInjector struct {
+	rules  []FaultRule
+	counts []uint64
+	rng    *rand.Rand
+	stats  FaultStats
+	mutex  sync.Mutex
+}
+
+// decide returns the fault to inject into the packet and its delay, matching the rules before drawing the random choices,
+// so that the senders and the receivers sharing the fault injector do not wait for each other's matchers.
+func (f *FaultInjector) decide(packet *notppackets.Packet) (Fault, time.Duration) {
+	matches := make([]bool, len(f.rules))
+	for i, rule := range f.rules {
+		matches[i] = rule.Match == nil || rule.Match(packet)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for i, rule := range f.rules {
+		if !matches[i] {
+			continue
+		}
+		f.counts[i]++
+		fire := false
+		if rule.Nth > 0 {
+			fire = f.counts[i] == rule.Nth
+		} else if rule.Probability > 0 {
+			fire = f.rng.Float64() < rule.Probability
+		}
+		if !fire {
+			continue
+		}
+		switch rule.Fault {
+		case DropFault:
+			f.stats.Dropped++
+		case DuplicateFault:
+			f.stats.Duplicated++
+		case ReorderFault:
+			f.stats.Reordered++
+		case DelayFault:
+			f.stats.Delayed++
+		case CorruptFault:
+			f.stats.Corrupted++
+		}
+		return rule.Fault, rule.Delay
+	}
+	return NoFault, 0
+}
+
+// corrupt returns a copy of the packet with a random bit flipped.
+func (f *FaultInjector) corrupt(packet *notppackets.Packet) *notppackets.Packet {
+	data := bytes.Clone(packet.Data)
+	if len(data) > 0 {
+		f.mutex.Lock()
+		bit := f.rng.IntN(len(data) * 8)
+		f.mutex.Unlock()
+		data[bit/8] ^= 1 << (bit % 8)
+	}
+	return &notppackets.Packet{Data: data}
+}
+
+// apply injects the faults into the packet and returns the packets to deliver in order.
+func (f *FaultInjector) apply(ctx context.Context, direction *faultDirection, packet *notppackets.Packet) ([]*notppackets.Packet, error) {
+	fault, delay := f.decide(packet)
+	var packets []*notppackets.Packet
+	switch fault {
+	case DropFault:
+		return nil, nil
+	case DuplicateFault:
+		packets = []*notppackets.Packet{packet, {Data: bytes.Clone(packet.Data)}}
+	case ReorderFault:
+		direction.mutex.Lock()
+		defer direction.mutex.Unlock()
+		if direction.held == nil {
+			direction.held = packet
+			return nil, nil
+		}
+		held := direction.held
+		direction.held = nil
+		return []*notppackets.Packet{packet, held}, nil
+	case DelayFault:
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+		packets = []*notppackets.Packet{packet}
+	case CorruptFault:
+		packets = []*notppackets.Packet{f.corrupt(packet)}
+	default:
+		packets = []*notppackets.Packet{packet}
+	}
+	direction.mutex.Lock()
+	defer direction.mutex.Unlock()
+	if direction.held != nil {
+		packets = append(packets, direction.held)
+		direction.held = nil
+	}
+	return packets, nil
+}
+
+// GetStats returns the number of faults injected so far.
+func (f *FaultInjector) GetStats() FaultStats {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.stats
+}
+
+// WrapSender returns a sender injecting the faults into the packets before sending them through the sender.
+// Each wrapped sender keeps its own held packet and sends the packets in the order the faults are decided.
+func (f *FaultInjector) WrapSender(sender ContextPacketSender) ContextPacketSender {
+	direction := &faultDirection{}
+	var sendMutex sync.Mutex
+	return func(ctx context.Context, packet *notppackets.Packet) error {
+		if packet == nil {
+			return sender(ctx, packet)
+		}
+		sendMutex.Lock()
+		defer sendMutex.Unlock()
+		packets, err := f.apply(ctx, direction, packet)
+		if err != nil {
+			return err
+		}
+		for _, packet := range packets {
+			if err := sender(ctx, packet); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// WrapReceiver returns a receiver injecting the faults into the packets received through the receiver.
+// Each wrapped receiver keeps its own queue of the packets to deliver.
+func (f *FaultInjector) WrapReceiver(receiver ContextPacketReceiver) ContextPacketReceiver {
+	direction := &faultDirection{}
+	return func(ctx context.Context) (*notppackets.Packet, error) {
+		for {
+			direction.mutex.Lock()
+			if len(direction.queue) > 0 {
+				packet := direction.queue[0]
+				direction.queue = direction.queue[1:]
+				direction.mutex.Unlock()
+				return packet, nil
+			}
+			direction.mutex.Unlock()
+			packet, err := receiver(ctx)
+			if err != nil || packet == nil {
+				return packet, err
+			}
+			packets, err := f.apply(ctx, direction, packet)
+			if err != nil {
+				return nil, err
+			}
+			direction.mutex.Lock()
+			direction.queue = append(direction.queue, packets...)
+			direction.mutex.Unlock()
+		}
+	}
+}
+
+// WrapPacketSender returns a sender injecting the faults into the packets before sending them through the sender.
+func (f *FaultInjector) WrapPacketSender(sender PacketSender) PacketSender {
+	wrapped := f.WrapSender(withContextSender(sender))
+	return func(packet *notppackets.Packet) error {
+		return wrapped(context.Background(), packet)
+	}
+}
+
+// WrapPacketReceiver returns a receiver injecting the faults into the packets received through the receiver.
+func (f *FaultInjector) WrapPacketReceiver(receiver PacketReceiver) PacketReceiver {
+	wrapped := f.WrapReceiver(withContextReceiver(receiver))
+	return func() (*notppackets.Packet, error) {
+		return wrapped(context.Background())
+	}
+}
+
+// NewFaultInjector creates and initializes a new fault injector with the seed of its random choices and the rules.
+func NewFaultInjector(seed uint64, rules ...FaultRule) (*FaultInjector, error) {
+	for _, rule := range rules {
+		if rule.Fault == NoFault || rule.Fault > CorruptFault {
+			return nil, fmt.Errorf("notp: invalid fault %s", rule.Fault)
+		}
+		if rule.Probability < 0 || rule.Probability > 1 {
+			return nil, fmt.Errorf("notp: invalid fault probability %v", rule.Probability)
+		}
+		if rule.Nth == 0 && rule.Probability == 0 {
+			return nil, errors.New("notp: fault rule requires either a probability or the Nth packet")
+		}
+		if rule.Fault == DelayFault && rule.Delay <= 0 {
+			return nil, fmt.Errorf("notp: invalid fault delay %s", rule.Delay)
+		}
+	}
+	return &FaultInjector{
+		rules:  slices.Clone(rules),
+		counts: make([]uint64, len(rules)),
+		rng:    rand.New(rand.NewPCG(seed, seed)),
+	}, nil
+}
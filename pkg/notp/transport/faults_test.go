@@ -0,0 +1,254 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// sendThroughInjector sends the packets through a sender wrapped by the fault injector and returns the delivered ones.
+func sendThroughInjector(assert *assert.Assertions, injector *FaultInjector, packets [][]byte) [][]byte {
+	delivered := [][]byte{}
+	sender := injector.WrapSender(func(_ context.Context, packet *notppackets.Packet) error {
+		delivered = append(delivered, packet.Data)
+		return nil
+	})
+	for _, data := range packets {
+		assert.Nil(sender(context.Background(), &notppackets.Packet{Data: data}))
+	}
+	return delivered
+}
+
+// TestFaultInjectorRules tests the faults injected by the rules targeting the Nth packet.
+func TestFaultInjectorRules(t *testing.T) {
+	packets := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	tests := []struct {
+		name      string
+		rule      FaultRule
+		expected  [][]byte
+		corrupted bool
+	}{
+		{name: "Drop", rule: FaultRule{Fault: DropFault, Nth: 2}, expected: [][]byte{[]byte("a"), []byte("c")}},
+		{name: "Duplicate", rule: FaultRule{Fault: DuplicateFault, Nth: 1}, expected: [][]byte{[]byte("a"), []byte("a"), []byte("b"), []byte("c")}},
+		{name: "Reorder", rule: FaultRule{Fault: ReorderFault, Nth: 1}, expected: [][]byte{[]byte("b"), []byte("a"), []byte("c")}},
+		{name: "Delay", rule: FaultRule{Fault: DelayFault, Nth: 3, Delay: time.Millisecond}, expected: packets},
+		{name: "Corrupt", rule: FaultRule{Fault: CorruptFault, Nth: 2}, corrupted: true},
+		{
+			name:     "Match",
+			rule:     FaultRule{Fault: DropFault, Nth: 1, Match: func(packet *notppackets.Packet) bool { return packet.Data[0] == 'c' }},
+			expected: [][]byte{[]byte("a"), []byte("b")},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			injector, err := NewFaultInjector(1, test.rule)
+			assert.Nil(err)
+			delivered := sendThroughInjector(assert, injector, packets)
+			if test.corrupted {
+				assert.Len(delivered, 3)
+				assert.NotEqual(packets[1], delivered[1])
+				assert.Equal(packets[1], []byte("b"))
+				assert.Equal(uint64(1), injector.GetStats().Corrupted)
+				return
+			}
+			assert.Equal(test.expected, delivered)
+		})
+	}
+}
+
+// TestFaultInjectorDeterminism tests that the same seed injects the same faults.
+func TestFaultInjectorDeterminism(t *testing.T) {
+	assert := assert.New(t)
+	packets := make([][]byte, 200)
+	for i := range packets {
+		packets[i] = []byte{byte(i), byte(i >> 8)}
+	}
+	rules := []FaultRule{
+		{Fault: DropFault, Probability: 0.1},
+		{Fault: DuplicateFault, Probability: 0.1},
+		{Fault: ReorderFault, Probability: 0.1},
+		{Fault: CorruptFault, Probability: 0.1},
+	}
+	run := func(seed uint64) ([][]byte, FaultStats) {
+		injector, err := NewFaultInjector(seed, rules...)
+		assert.Nil(err)
+		return sendThroughInjector(assert, injector, packets), injector.GetStats()
+	}
+	first, firstStats := run(42)
+	second, secondStats := run(42)
+	other, _ := run(7)
+	assert.Equal(first, second)
+	assert.Equal(firstStats, secondStats)
+	assert.NotEqual(first, other)
+	assert.NotZero(firstStats.Dropped)
+	assert.NotZero(firstStats.Duplicated)
+	assert.NotZero(firstStats.Reordered)
+	assert.NotZero(firstStats.Corrupted)
+}
+
+// TestFaultInjectorReceiver tests the faults injected into the received packets of a transport layer.
+func TestFaultInjectorReceiver(t *testing.T) {
+	assert := assert.New(t)
+	leaderEndpoint, followerEndpoint, err := NewInMemoryPipe(WithPipeTimeout(time.Second))
+	assert.Nil(err)
+	match := MatchDataPacket(func(_ uint32, _ uint64, data []byte) bool { return bytes.Equal(data, []byte("drop")) })
+	injector, err := NewFaultInjector(1, FaultRule{Fault: DropFault, Nth: 1, Match: match}, FaultRule{Fault: DuplicateFault, Nth: 2})
+	assert.Nil(err)
+	leader, err := NewStreamTransportLayer(leaderEndpoint, nil, WithCompressionThreshold(1))
+	assert.Nil(err)
+	follower, err := NewContextTransportLayer(followerEndpoint.TransmitPacketContext, injector.WrapReceiver(followerEndpoint.ReceivePacketContext), nil)
+	assert.Nil(err)
+
+	assert.Nil(follower.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("hello")}}))
+	_, err = leader.ReceivePacket()
+	assert.Nil(err)
+	for _, data := range []string{"drop", "first", "second"} {
+		assert.Nil(leader.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte(data)}}))
+	}
+	for _, data := range []string{"first", "second", "second"} {
+		packetables, err := follower.ReceivePacket()
+		assert.Nil(err)
+		assert.Equal([]notppackets.Packetable{&notppackets.Packet{Data: []byte(data)}}, packetables)
+	}
+	assert.Equal(FaultStats{Dropped: 1, Duplicated: 1}, injector.GetStats())
+}
+
+// TestFaultInjectorSenders tests that each wrapped sender holds back its own reordered packets.
+func TestFaultInjectorSenders(t *testing.T) {
+	assert := assert.New(t)
+	injector, err := NewFaultInjector(1, FaultRule{Fault: ReorderFault, Nth: 1})
+	assert.Nil(err)
+	delivered := map[string][][]byte{}
+	wrap := func(name string) ContextPacketSender {
+		return injector.WrapSender(func(_ context.Context, packet *notppackets.Packet) error {
+			delivered[name] = append(delivered[name], packet.Data)
+			return nil
+		})
+	}
+	first, second := wrap("first"), wrap("second")
+	for _, send := range []struct {
+		sender ContextPacketSender
+		data   string
+	}{{first, "a"}, {second, "b"}, {first, "c"}} {
+		assert.Nil(send.sender(context.Background(), &notppackets.Packet{Data: []byte(send.data)}))
+	}
+	assert.Equal(map[string][][]byte{"first": {[]byte("c"), []byte("a")}, "second": {[]byte("b")}}, delivered)
+}
+
+// xorCompressor is a compressor flipping the bits of the data, used to test the custom compressors.
+type xorCompressor struct{}
+
+// GetID returns the identifier of the compressor.
+func (c *xorCompressor) GetID() uint32 {
+	return 7
+}
+
+// Compress compresses the data.
+func (c *xorCompressor) Compress(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = ^b
+	}
+	return out, nil
+}
+
+// Decompress decompresses the data.
+func (c *xorCompressor) Decompress(data []byte, _ uint64) ([]byte, error) {
+	return c.Compress(data)
+}
+
+// TestFaultInjectorConcurrentMatch tests that a slow matcher does not hold back the other senders sharing the fault injector.
+func TestFaultInjectorConcurrentMatch(t *testing.T) {
+	assert := assert.New(t)
+	entered, release := make(chan struct{}), make(chan struct{})
+	injector, err := NewFaultInjector(1, FaultRule{Fault: DropFault, Probability: 1, Match: func(packet *notppackets.Packet) bool {
+		if string(packet.Data) == "slow" {
+			close(entered)
+			<-release
+		}
+		return false
+	}})
+	assert.Nil(err)
+	send := func(context.Context, *notppackets.Packet) error { return nil }
+	slowSender, fastSender := injector.WrapSender(send), injector.WrapSender(send)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- slowSender(context.Background(), &notppackets.Packet{Data: []byte("slow")})
+	}()
+	<-entered
+	fast := make(chan error, 1)
+	go func() {
+		fast <- fastSender(context.Background(), &notppackets.Packet{Data: []byte("fast")})
+	}()
+	select {
+	case err := <-fast:
+		assert.Nil(err)
+	case <-time.After(5 * time.Second):
+		assert.Fail("the fast sender waited for the slow matcher")
+	}
+	close(release)
+	assert.Nil(<-done)
+}
+
+// TestMatchDataPacketWithCompressors tests that the data packet matcher decompresses the packets with the input compressors.
+func TestMatchDataPacketWithCompressors(t *testing.T) {
+	assert := assert.New(t)
+	leaderEndpoint, followerEndpoint, err := NewInMemoryPipe(WithPipeTimeout(time.Second))
+	assert.Nil(err)
+	leader, err := NewStreamTransportLayer(leaderEndpoint, nil, WithCompressors(&xorCompressor{}), WithCompressionThreshold(1))
+	assert.Nil(err)
+	follower, err := NewStreamTransportLayer(followerEndpoint, nil, WithCompressors(&xorCompressor{}))
+	assert.Nil(err)
+
+	assert.Nil(leader.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("hello")}}))
+	_, err = follower.ReceivePacket()
+	assert.Nil(err)
+	assert.Nil(follower.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("hello")}}))
+	_, err = leader.ReceivePacket()
+	assert.Nil(err)
+	assert.Nil(leader.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("drop")}}))
+	packet, err := followerEndpoint.ReceivePacketContext(context.Background())
+	assert.Nil(err)
+
+	isDrop := func(_ uint32, _ uint64, data []byte) bool { return bytes.Equal(data, []byte("drop")) }
+	assert.False(MatchDataPacket(isDrop)(packet))
+	assert.True(MatchDataPacket(isDrop, &xorCompressor{})(packet))
+}
+
+// TestFaultInjectorInvalidRules tests the validation of the fault rules.
+func TestFaultInjectorInvalidRules(t *testing.T) {
+	assert := assert.New(t)
+	for _, rule := range []FaultRule{
+		{Fault: NoFault, Nth: 1},
+		{Fault: CorruptFault + 1, Nth: 1},
+		{Fault: DropFault, Probability: 1.5},
+		{Fault: DropFault},
+		{Fault: DelayFault, Nth: 1},
+	} {
+		_, err := NewFaultInjector(1, rule)
+		assert.NotNil(err, "rule %+v", rule)
+	}
+}
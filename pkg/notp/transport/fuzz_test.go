@@ -0,0 +1,71 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"testing"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// FuzzReceivePacket fuzzes the reception of packets by the transport layer.
+func FuzzReceivePacket(f *testing.F) {
+	for _, version := range []uint32{notppackets.ProtocolVersion1, notppackets.ProtocolVersion2} {
+		var sent *notppackets.Packet
+		sender := func(packet *notppackets.Packet) error {
+			sent = packet
+			return nil
+		}
+		transportLayer, _ := NewTransportLayer(sender, func() (*notppackets.Packet, error) { return nil, nil }, nil, WithProtocolVersions(version, version))
+		transportLayer.TransmitPacket([]notppackets.Packetable{
+			&notppackets.Packet{Data: []byte("sample data")},
+			&notppackets.ProtocolPacket{Version: version},
+		})
+		f.Add(sent.Data)
+	}
+	var sent *notppackets.Packet
+	sender := func(packet *notppackets.Packet) error {
+		sent = packet
+		return nil
+	}
+	transportLayer, _ := NewTransportLayer(sender, func() (*notppackets.Packet, error) { return nil, nil }, nil,
+		WithProtocolVersions(notppackets.ProtocolVersion2, notppackets.ProtocolVersion2), WithCompressionThreshold(0))
+	transportLayer.peerMask = transportLayer.compressionMask
+	transportLayer.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("sample data")}})
+	f.Add(sent.Data)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		receiver := func() (*notppackets.Packet, error) {
+			return &notppackets.Packet{Data: data}, nil
+		}
+		transportLayer, err := NewTransportLayer(func(*notppackets.Packet) error { return nil }, receiver, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packetables, err := transportLayer.ReceivePacket()
+		if err != nil {
+			return
+		}
+		if len(packetables) == 0 {
+			t.Fatalf("no packets received for %v", data)
+		}
+		for _, packetable := range packetables {
+			if packetable == nil {
+				t.Fatalf("nil packet received for %v", data)
+			}
+		}
+	})
+}
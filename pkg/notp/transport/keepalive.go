@@ -0,0 +1,425 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transport implements the transport layer of the NOTP protocol.
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+const (
+	// DefaultKeepaliveInterval represents the default interval between two pings.
+	DefaultKeepaliveInterval = time.Second
+	// DefaultIdleTimeout represents the default time without receiving anything from the peer before giving up.
+	DefaultIdleTimeout = 5 * time.Second
+	// DefaultKeepaliveQueueSize represents the default number of received packets queued for the reader.
+	DefaultKeepaliveQueueSize = 1024
+
+	// keepaliveDataFrame carries a packet.
+	keepaliveDataFrame = byte(1)
+	// keepalivePingFrame asks the peer for a pong echoing its timestamp.
+	keepalivePingFrame = byte(2)
+	// keepalivePongFrame answers a ping echoing its timestamp.
+	keepalivePongFrame = byte(3)
+	// keepaliveTimestampSize represents the size in bytes of the timestamp carried by the pings and the pongs.
+	keepaliveTimestampSize = 8
+	// keepaliveRTTWeight represents the weight of the last sample in the smoothed round-trip time, as the denominator of a fraction.
+	keepaliveRTTWeight = 8
+)
+
+// ErrIdleTimeout is returned once nothing has been received from the peer within the idle timeout.
+var ErrIdleTimeout = errors.New("notp: peer idle timeout")
+
+// KeepaliveOption defines a function to configure the keepalive layer.
+type KeepaliveOption func(*KeepaliveLayer) error
+
+// WithKeepalive sets the interval between two pings and the time without receiving anything from the peer before giving up.
+func WithKeepalive(interval, idleTimeout time.Duration) KeepaliveOption {
+	return func(k *KeepaliveLayer) error {
+		if interval <= 0 || idleTimeout <= interval {
+			return fmt.Errorf("notp: invalid keepalive interval %s and idle timeout %s", interval, idleTimeout)
+		}
+		k.interval = interval
+		k.idleTimeout = idleTimeout
+		return nil
+	}
+}
+
+// WithKeepaliveCloser sets the functions closing the underlying connection when the keepalive layer is closed.
+func WithKeepaliveCloser(closeFunc, closeWriteFunc StreamCloseFunc) KeepaliveOption {
+	return func(k *KeepaliveLayer) error {
+		k.closeFunc = closeFunc
+		k.closeWriteFunc = closeWriteFunc
+		return nil
+	}
+}
+
+// WithKeepaliveQueueSize sets the number of received packets queued for the reader, beyond which the flow is aborted with ErrLimitExceeded.
+func WithKeepaliveQueueSize(queueSize int) KeepaliveOption {
+	return func(k *KeepaliveLayer) error {
+		if queueSize <= 0 {
+			return fmt.Errorf("notp: invalid keepalive queue size %d", queueSize)
+		}
+		k.queueSize = queueSize
+		return nil
+	}
+}
+
+// KeepaliveLayer exchanges pings and pongs with the peer independently of the packets, so that a busy peer is told apart
+// from a dead one: the flow is aborted with ErrIdleTimeout once nothing is received within the idle timeout.
+// The received packets are queued for the reader, so that the pings and the pongs keep flowing while it falls behind,
+// and the flow is aborted with ErrLimitExceeded once the queue is full.
+type KeepaliveLayer struct {
+	sender         ContextPacketSender
+	receiver       ContextPacketReceiver
+	closeFunc      StreamCloseFunc
+	closeWriteFunc StreamCloseFunc
+	interval       time.Duration
+	idleTimeout    time.Duration
+	start          time.Time
+	lastReceived   time.Time
+	rtt            time.Duration
+	pong           []byte
+	pongWake       chan struct{}
+	delivered      []*notppackets.Packet
+	delivery       chan struct{}
+	queueSize      int
+	writeClosed    *closeSignal
+	closed         *closeSignal
+	remoteClosed   *closeSignal
+	ended          *closeSignal
+	endErr         error
+	mutex          sync.Mutex
+	sendMutex      sync.Mutex
+}
+
+// TransmitPacket sends a packet.
+func (k *KeepaliveLayer) TransmitPacket(packet *notppackets.Packet) error {
+	return k.TransmitPacketContext(context.Background(), packet)
+}
+
+// TransmitPacketContext sends a packet, giving up once the context is done.
+func (k *KeepaliveLayer) TransmitPacketContext(ctx context.Context, packet *notppackets.Packet) error {
+	if packet == nil {
+		return errors.New("notp: cannot transmit a nil packet")
+	}
+	if k.writeClosed.isClosed() {
+		return ErrClosed
+	}
+	if k.ended.isClosed() {
+		return k.getEndError()
+	}
+	data := make([]byte, 0, len(packet.Data)+1)
+	data = append(data, keepaliveDataFrame)
+	data = append(data, packet.Data...)
+	return k.send(ctx, &notppackets.Packet{Data: data})
+}
+
+// ReceivePacket retrieves the next packet.
+func (k *KeepaliveLayer) ReceivePacket() (*notppackets.Packet, error) {
+	return k.ReceivePacketContext(context.Background())
+}
+
+// ReceivePacketContext retrieves the next packet, giving up once the context is done.
+func (k *KeepaliveLayer) ReceivePacketContext(ctx context.Context) (*notppackets.Packet, error) {
+	if k.closed.isClosed() {
+		return nil, ErrClosed
+	}
+	for {
+		packet, delivery := k.pop()
+		if packet != nil {
+			return packet, nil
+		}
+		select {
+		case <-delivery:
+		case <-k.remoteClosed.done():
+			return k.drain(io.EOF)
+		case <-k.ended.done():
+			return k.drain(k.getEndError())
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+}
+
+// pop returns the next received packet if any, or the channel closed on the next delivery otherwise.
+func (k *KeepaliveLayer) pop() (*notppackets.Packet, chan struct{}) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if len(k.delivered) == 0 {
+		return nil, k.delivery
+	}
+	packet := k.delivered[0]
+	k.delivered = k.delivered[1:]
+	return packet, nil
+}
+
+// drain returns the next received packet if any, or the error otherwise.
+func (k *KeepaliveLayer) drain(err error) (*notppackets.Packet, error) {
+	if packet, _ := k.pop(); packet != nil {
+		return packet, nil
+	}
+	return nil, err
+}
+
+// GetRTT returns the smoothed round-trip time measured through the pings, or zero if no pong has been received yet.
+func (k *KeepaliveLayer) GetRTT() time.Duration {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.rtt
+}
+
+// CloseWrite closes the underlying connection for writing, so that the peer receives io.EOF once it has received all the packets.
+func (k *KeepaliveLayer) CloseWrite() error {
+	if !k.writeClosed.close() {
+		return nil
+	}
+	if k.closeWriteFunc != nil {
+		return k.closeWriteFunc()
+	}
+	return nil
+}
+
+// Close closes the keepalive layer and the underlying connection.
+func (k *KeepaliveLayer) Close() error {
+	if !k.closed.close() {
+		return nil
+	}
+	k.writeClosed.close()
+	k.end(ErrClosed)
+	if k.closeFunc != nil {
+		return k.closeFunc()
+	}
+	return nil
+}
+
+// send sends the frame through the underlying connection, ending the keepalive layer on failure.
+func (k *KeepaliveLayer) send(ctx context.Context, frame *notppackets.Packet) error {
+	ctx, cancel := k.ended.bind(ctx)
+	defer cancel()
+	k.sendMutex.Lock()
+	defer k.sendMutex.Unlock()
+	if err := k.sender(ctx, frame); err != nil {
+		if k.ended.isClosed() {
+			return k.getEndError()
+		}
+		if ctx.Err() == nil {
+			k.end(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// sendTimestamp sends a ping or a pong carrying the timestamp.
+func (k *KeepaliveLayer) sendTimestamp(kind byte, timestamp []byte) error {
+	data := make([]byte, 0, keepaliveTimestampSize+1)
+	data = append(data, kind)
+	data = append(data, timestamp...)
+	return k.send(context.Background(), &notppackets.Packet{Data: data})
+}
+
+// pingLoop sends the pings and checks the idle timeout until the keepalive layer ends.
+func (k *KeepaliveLayer) pingLoop() {
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-k.ended.done():
+			return
+		}
+		k.mutex.Lock()
+		idle := time.Since(k.lastReceived)
+		k.mutex.Unlock()
+		if idle > k.idleTimeout && !k.remoteClosed.isClosed() {
+			k.end(fmt.Errorf("%w: nothing received for %s", ErrIdleTimeout, idle.Round(time.Millisecond)))
+			return
+		}
+		if k.writeClosed.isClosed() {
+			continue
+		}
+		timestamp := binary.BigEndian.AppendUint64(nil, uint64(time.Since(k.start)))
+		if err := k.sendTimestamp(keepalivePingFrame, timestamp); err != nil {
+			return
+		}
+	}
+}
+
+// pongLoop answers the latest ping until the keepalive layer ends, so that the read loop never waits for the connection.
+func (k *KeepaliveLayer) pongLoop() {
+	for {
+		select {
+		case <-k.pongWake:
+		case <-k.ended.done():
+			return
+		}
+		k.mutex.Lock()
+		timestamp := k.pong
+		k.mutex.Unlock()
+		if k.writeClosed.isClosed() {
+			continue
+		}
+		if err := k.sendTimestamp(keepalivePongFrame, timestamp); err != nil {
+			return
+		}
+	}
+}
+
+// readLoop processes the received frames until the keepalive layer ends.
+func (k *KeepaliveLayer) readLoop() {
+	for {
+		packet, err := k.receiver(k.ended.ctx)
+		if errors.Is(err, io.EOF) {
+			k.remoteClosed.close()
+			return
+		}
+		if err != nil {
+			k.end(err)
+			return
+		}
+		if len(packet.Data) == 0 {
+			k.end(errors.New("notp: invalid keepalive frame"))
+			return
+		}
+		now := time.Now()
+		k.mutex.Lock()
+		k.lastReceived = now
+		k.mutex.Unlock()
+		kind, payload := packet.Data[0], packet.Data[1:]
+		switch kind {
+		case keepaliveDataFrame:
+			k.mutex.Lock()
+			if len(k.delivered) >= k.queueSize {
+				k.mutex.Unlock()
+				k.end(fmt.Errorf("%w: received packets exceed the keepalive queue of %d packets", ErrLimitExceeded, k.queueSize))
+				return
+			}
+			k.delivered = append(k.delivered, &notppackets.Packet{Data: payload})
+			close(k.delivery)
+			k.delivery = make(chan struct{})
+			k.mutex.Unlock()
+		case keepalivePingFrame, keepalivePongFrame:
+			if len(payload) != keepaliveTimestampSize {
+				k.end(errors.New("notp: invalid keepalive timestamp"))
+				return
+			}
+			if kind == keepalivePingFrame {
+				k.mutex.Lock()
+				k.pong = payload
+				k.mutex.Unlock()
+				select {
+				case k.pongWake <- struct{}{}:
+				default:
+				}
+				continue
+			}
+			k.measure(time.Duration(binary.BigEndian.Uint64(payload)))
+		default:
+			k.end(fmt.Errorf("notp: invalid keepalive frame kind %d", kind))
+			return
+		}
+	}
+}
+
+// measure updates the smoothed round-trip time with the pong echoing the elapsed time since the start when its ping was sent.
+func (k *KeepaliveLayer) measure(sent time.Duration) {
+	sample := time.Since(k.start) - sent
+	if sample < 0 {
+		return
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.rtt == 0 {
+		k.rtt = sample
+		return
+	}
+	k.rtt += (sample - k.rtt) / keepaliveRTTWeight
+}
+
+// end ends the keepalive layer with the error, waking up the pending calls.
+func (k *KeepaliveLayer) end(err error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.ended.isClosed() {
+		return
+	}
+	k.endErr = err
+	k.ended.close()
+}
+
+// getEndError returns the error the keepalive layer ended with.
+func (k *KeepaliveLayer) getEndError() error {
+	if k.closed.isClosed() {
+		return ErrClosed
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.endErr
+}
+
+// NewKeepaliveLayer creates and initializes a new keepalive layer over the connection.
+func NewKeepaliveLayer(sender ContextPacketSender, receiver ContextPacketReceiver, opts ...KeepaliveOption) (*KeepaliveLayer, error) {
+	if sender == nil {
+		return nil, errors.New("notp: ContextPacketSender cannot be nil")
+	}
+	if receiver == nil {
+		return nil, errors.New("notp: ContextPacketReceiver cannot be nil")
+	}
+	now := time.Now()
+	keepalive := &KeepaliveLayer{
+		sender:       sender,
+		receiver:     receiver,
+		interval:     DefaultKeepaliveInterval,
+		idleTimeout:  DefaultIdleTimeout,
+		start:        now,
+		lastReceived: now,
+		pongWake:     make(chan struct{}, 1),
+		delivery:     make(chan struct{}),
+		queueSize:    DefaultKeepaliveQueueSize,
+		writeClosed:  newCloseSignal(),
+		closed:       newCloseSignal(),
+		remoteClosed: newCloseSignal(),
+		ended:        newCloseSignal(),
+	}
+	for _, opt := range opts {
+		if err := opt(keepalive); err != nil {
+			return nil, err
+		}
+	}
+	go keepalive.readLoop()
+	go keepalive.pingLoop()
+	go keepalive.pongLoop()
+	return keepalive, nil
+}
+
+// NewStreamKeepaliveLayer creates and initializes a new keepalive layer over the stream, which is closed along with the keepalive layer.
+func NewStreamKeepaliveLayer(stream Stream, opts ...KeepaliveOption) (*KeepaliveLayer, error) {
+	if stream == nil {
+		return nil, errors.New("notp: stream cannot be nil")
+	}
+	opts = append([]KeepaliveOption{WithKeepaliveCloser(stream.Close, stream.CloseWrite)}, opts...)
+	return NewKeepaliveLayer(stream.TransmitPacketContext, stream.ReceivePacketContext, opts...)
+}
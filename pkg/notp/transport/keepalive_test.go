@@ -0,0 +1,208 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// buildKeepaliveLayers initializes and returns two keepalive layers connected through an in-memory pipe.
+func buildKeepaliveLayers(assert *assert.Assertions, opts ...KeepaliveOption) (*KeepaliveLayer, *KeepaliveLayer) {
+	left, right, err := NewInMemoryPipe(WithPipeTimeout(time.Minute))
+	assert.Nil(err)
+	leftKeepalive, err := NewStreamKeepaliveLayer(left, opts...)
+	assert.Nil(err)
+	rightKeepalive, err := NewStreamKeepaliveLayer(right, opts...)
+	assert.Nil(err)
+	return leftKeepalive, rightKeepalive
+}
+
+// TestKeepaliveLayerBusyPeer tests that the pings keep alive an idle peer and measure the round-trip time.
+func TestKeepaliveLayerBusyPeer(t *testing.T) {
+	assert := assert.New(t)
+	left, right := buildKeepaliveLayers(assert, WithKeepalive(5*time.Millisecond, 50*time.Millisecond))
+	defer left.Close()
+	defer right.Close()
+
+	assert.Zero(left.GetRTT())
+	time.Sleep(150 * time.Millisecond)
+	for _, pair := range [][2]*KeepaliveLayer{{left, right}, {right, left}} {
+		assert.Nil(pair[0].TransmitPacket(&notppackets.Packet{Data: []byte("alive")}))
+		packet, err := pair[1].ReceivePacket()
+		assert.Nil(err)
+		assert.Equal([]byte("alive"), packet.Data)
+	}
+	assert.Positive(left.GetRTT())
+	assert.Positive(right.GetRTT())
+}
+
+// TestKeepaliveLayerSlowReader tests that a reader falling behind does not stop the pings and the pongs.
+func TestKeepaliveLayerSlowReader(t *testing.T) {
+	assert := assert.New(t)
+	left, right := buildKeepaliveLayers(assert, WithKeepalive(10*time.Millisecond, 100*time.Millisecond))
+	defer left.Close()
+	defer right.Close()
+
+	const count = 50
+	for i := range count {
+		assert.Nil(left.TransmitPacket(&notppackets.Packet{Data: []byte{byte(i)}}))
+	}
+	time.Sleep(400 * time.Millisecond)
+	for i := range count {
+		packet, err := right.ReceivePacket()
+		if !assert.Nil(err) {
+			return
+		}
+		assert.Equal([]byte{byte(i)}, packet.Data)
+	}
+	assert.Nil(left.TransmitPacket(&notppackets.Packet{Data: []byte("alive")}))
+	packet, err := right.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal([]byte("alive"), packet.Data)
+}
+
+// TestKeepaliveLayerQueueLimit tests that the flow is aborted once a slow reader fills the queue of the received packets.
+func TestKeepaliveLayerQueueLimit(t *testing.T) {
+	assert := assert.New(t)
+	left, right := buildKeepaliveLayers(assert, WithKeepaliveQueueSize(4))
+	defer left.Close()
+	defer right.Close()
+
+	for i := range 5 {
+		assert.Nil(left.TransmitPacket(&notppackets.Packet{Data: []byte{byte(i)}}))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	select {
+	case <-right.ended.done():
+	case <-ctx.Done():
+		assert.Fail("the keepalive layer did not end")
+		return
+	}
+	for i := range 4 {
+		packet, err := right.ReceivePacket()
+		if !assert.Nil(err) {
+			return
+		}
+		assert.Equal([]byte{byte(i)}, packet.Data)
+	}
+	_, err := right.ReceivePacket()
+	assert.ErrorIs(err, ErrLimitExceeded)
+}
+
+// TestKeepaliveLayerDeadPeer tests that the flow is aborted once nothing is received from the peer within the idle timeout.
+func TestKeepaliveLayerDeadPeer(t *testing.T) {
+	assert := assert.New(t)
+	left, right, err := NewInMemoryPipe(WithPipeTimeout(time.Minute), WithPipeBufferSize(100))
+	assert.Nil(err)
+	defer right.Close()
+	keepalive, err := NewStreamKeepaliveLayer(left, WithKeepalive(5*time.Millisecond, 30*time.Millisecond))
+	assert.Nil(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = keepalive.ReceivePacketContext(ctx)
+	assert.ErrorIs(err, ErrIdleTimeout)
+	assert.ErrorIs(keepalive.TransmitPacket(&notppackets.Packet{}), ErrIdleTimeout)
+	assert.Zero(keepalive.GetRTT())
+	packet, err := right.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal(keepalivePingFrame, packet.Data[0])
+
+	assert.Nil(keepalive.Close())
+	_, err = keepalive.ReceivePacket()
+	assert.ErrorIs(err, ErrClosed)
+}
+
+// TestKeepaliveLayerClose tests the half-close and the close of the keepalive layers.
+func TestKeepaliveLayerClose(t *testing.T) {
+	assert := assert.New(t)
+	left, right := buildKeepaliveLayers(assert, WithKeepalive(5*time.Millisecond, 30*time.Millisecond))
+
+	assert.Nil(left.TransmitPacket(&notppackets.Packet{Data: []byte("last")}))
+	assert.Nil(left.CloseWrite())
+	assert.ErrorIs(left.TransmitPacket(&notppackets.Packet{}), ErrClosed)
+	packet, err := right.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal([]byte("last"), packet.Data)
+	_, err = right.ReceivePacket()
+	assert.ErrorIs(err, io.EOF)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(right.TransmitPacket(&notppackets.Packet{Data: []byte("reply")}))
+	packet, err = left.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal([]byte("reply"), packet.Data)
+
+	assert.Nil(right.Close())
+	_, err = right.ReceivePacket()
+	assert.ErrorIs(err, ErrClosed)
+	_, err = left.ReceivePacket()
+	assert.ErrorIs(err, io.EOF)
+	assert.Nil(left.Close())
+}
+
+// TestKeepaliveLayerInvalidFrames tests that an invalid frame ends the keepalive layer.
+func TestKeepaliveLayerInvalidFrames(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Empty", data: []byte{}},
+		{name: "UnknownKind", data: []byte{9}},
+		{name: "ShortPing", data: []byte{keepalivePingFrame, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			left, right, err := NewInMemoryPipe(WithPipeTimeout(time.Minute))
+			assert.Nil(err)
+			keepalive, err := NewStreamKeepaliveLayer(left)
+			assert.Nil(err)
+			defer keepalive.Close()
+			assert.Nil(right.TransmitPacket(&notppackets.Packet{Data: test.data}))
+			_, err = keepalive.ReceivePacket()
+			assert.ErrorContains(err, "notp: invalid keepalive")
+		})
+	}
+}
+
+// TestKeepaliveLayerInvalidOptions tests the validation of the keepalive layer options.
+func TestKeepaliveLayerInvalidOptions(t *testing.T) {
+	assert := assert.New(t)
+	left, _, err := NewInMemoryPipe()
+	assert.Nil(err)
+	for _, opt := range []KeepaliveOption{
+		WithKeepalive(0, time.Second),
+		WithKeepalive(time.Second, time.Second),
+		WithKeepaliveQueueSize(0),
+	} {
+		_, err := NewStreamKeepaliveLayer(left, opt)
+		assert.NotNil(err)
+	}
+	_, err = NewKeepaliveLayer(left.TransmitPacketContext, nil)
+	assert.NotNil(err)
+	_, err = NewStreamKeepaliveLayer(nil)
+	assert.NotNil(err)
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transport implements the transport layer of the NOTP protocol.
+package transport
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// ErrLimitExceeded is returned when a received packet exceeds the limits of the transport layer.
+var ErrLimitExceeded = errors.New("notp: transport limit exceeded")
+
+// TransportLimits holds the limits enforced while receiving packets, where zero means unlimited.
+type TransportLimits struct {
+	// MaxCompressedSize is the maximum size in bytes of a packet as received.
+	MaxCompressedSize uint64
+	// MaxDecompressedSize is the maximum size in bytes of a packet once decompressed.
+	MaxDecompressedSize uint64
+	// MaxDataPackets is the maximum number of data packets of a data stream.
+	MaxDataPackets uint64
+	// MaxPayloadSize is the maximum size in bytes of the payload of a data packet.
+	MaxPayloadSize uint64
+}
+
+// WithLimits sets the limits enforced while receiving packets.
+func WithLimits(limits TransportLimits) TransportLayerOption {
+	return func(t *TransportLayer) error {
+		t.limits = limits
+		return nil
+	}
+}
+
+// getReaderLimits returns the limits enforced while decoding the data packets.
+func (l TransportLimits) getReaderLimits() notppackets.PacketReaderLimits {
+	return notppackets.PacketReaderLimits{
+		MaxPayloadSize: l.MaxPayloadSize,
+		MaxStreamSize:  l.MaxDataPackets,
+	}
+}
+
+// checkCompressedSize checks the size of a received packet against the limits.
+func (l TransportLimits) checkCompressedSize(size int) error {
+	if l.MaxCompressedSize > 0 && uint64(size) > l.MaxCompressedSize {
+		return fmt.Errorf("%w: packet of %d bytes exceeds the maximum of %d bytes", ErrLimitExceeded, size, l.MaxCompressedSize)
+	}
+	return nil
+}
+
+// checkDecompressedSize checks the size of a decompressed packet against the limits.
+func (l TransportLimits) checkDecompressedSize(size int) error {
+	if l.MaxDecompressedSize > 0 && uint64(size) > l.MaxDecompressedSize {
+		return fmt.Errorf("%w: decompressed packet exceeds the maximum of %d bytes", ErrLimitExceeded, l.MaxDecompressedSize)
+	}
+	return nil
+}
+
+// getDecompressionLimit returns the maximum size of the data stream following the protocol header, where zero means unlimited.
+func (l TransportLimits) getDecompressionLimit(headerSize int) (uint64, error) {
+	if l.MaxDecompressedSize == 0 {
+		return 0, nil
+	}
+	if uint64(headerSize) >= l.MaxDecompressedSize {
+		return 0, fmt.Errorf("%w: decompressed packet exceeds the maximum of %d bytes", ErrLimitExceeded, l.MaxDecompressedSize)
+	}
+	return l.MaxDecompressedSize - uint64(headerSize), nil
+}
+
+// wrapReaderError wraps the errors of the packet reader caused by the limits.
+func wrapReaderError(err error) error {
+	if errors.Is(err, notppackets.ErrOversized) || errors.Is(err, notppackets.ErrTooManyPackets) {
+		return fmt.Errorf("%w: %w", ErrLimitExceeded, err)
+	}
+	return err
+}
+
+// readAllLimited reads the reader until EOF failing as soon as more than the limit bytes are read, where zero means unlimited.
+func readAllLimited(reader io.Reader, limit uint64) ([]byte, error) {
+	if limit == 0 || limit >= math.MaxInt64 {
+		return io.ReadAll(reader)
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: decompressed packet exceeds the maximum of %d bytes", ErrLimitExceeded, limit)
+	}
+	return data, nil
+}
+
+// decompressLegacyData decompresses the zlib stream of the packets compressed as a whole.
+func decompressLegacyData(data []byte, limit uint64) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readAllLimited(reader, limit)
+}
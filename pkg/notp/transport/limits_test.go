@@ -0,0 +1,76 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// TestReceivePacketWithLimits tests that the received packets exceeding the limits are rejected.
+func TestReceivePacketWithLimits(t *testing.T) {
+	bomb := []notppackets.Packetable{&notppackets.Packet{Data: bytes.Repeat([]byte{0}, 1<<20)}}
+	payload := []notppackets.Packetable{&notppackets.Packet{Data: bytes.Repeat([]byte{1}, 1000)}}
+	manyPackets := []notppackets.Packetable{}
+	for range 10 {
+		manyPackets = append(manyPackets, &notppackets.Packet{Data: []byte("packet")})
+	}
+	tests := []struct {
+		name        string
+		version     uint32
+		limits      TransportLimits
+		packetables []notppackets.Packetable
+		hasError    bool
+	}{
+		{name: "WithinLimits", version: notppackets.ProtocolVersion2, limits: TransportLimits{MaxCompressedSize: 1 << 12, MaxDecompressedSize: 1 << 21, MaxDataPackets: 1, MaxPayloadSize: 1 << 20}, packetables: bomb},
+		{name: "CompressedSize", version: notppackets.ProtocolVersion2, limits: TransportLimits{MaxCompressedSize: 64}, packetables: bomb, hasError: true},
+		{name: "DecompressedSize", version: notppackets.ProtocolVersion2, limits: TransportLimits{MaxDecompressedSize: 1 << 16}, packetables: bomb, hasError: true},
+		{name: "LegacyDecompressedSize", version: notppackets.ProtocolVersion1, limits: TransportLimits{MaxDecompressedSize: 1 << 16}, packetables: bomb, hasError: true},
+		{name: "DataPackets", version: notppackets.ProtocolVersion2, limits: TransportLimits{MaxDataPackets: 9}, packetables: manyPackets, hasError: true},
+		{name: "PayloadSize", version: notppackets.ProtocolVersion2, limits: TransportLimits{MaxPayloadSize: 1 << 16}, packetables: bomb, hasError: true},
+		{name: "LegacyWithinPayloadSize", version: notppackets.ProtocolVersion1, limits: TransportLimits{MaxPayloadSize: 1000}, packetables: payload},
+		{name: "LegacyPayloadSize", version: notppackets.ProtocolVersion1, limits: TransportLimits{MaxPayloadSize: 999}, packetables: payload, hasError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var sent *notppackets.Packet
+			sender, err := NewTransportLayer(func(packet *notppackets.Packet) error {
+				sent = packet
+				return nil
+			}, func() (*notppackets.Packet, error) { return nil, nil }, nil, WithProtocolVersions(test.version, test.version))
+			assert.Nil(err)
+			sender.peerMask = sender.compressionMask
+			assert.Nil(sender.TransmitPacket(test.packetables))
+
+			receiver, err := NewTransportLayer(func(*notppackets.Packet) error { return nil }, func() (*notppackets.Packet, error) { return sent, nil }, nil, WithLimits(test.limits))
+			assert.Nil(err)
+			packetables, err := receiver.ReceivePacket()
+			if test.hasError {
+				assert.ErrorIs(err, ErrLimitExceeded)
+				return
+			}
+			assert.Nil(err)
+			assert.Len(packetables, len(test.packetables))
+		})
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transport implements the transport layer of the NOTP protocol.
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Direction represents the direction of the packets of a transport layer.
+type Direction uint8
+
+const (
+	// SendDirection represents the packets sent.
+	SendDirection Direction = iota
+	// ReceiveDirection represents the packets received.
+	ReceiveDirection
+)
+
+// String returns the name of the direction.
+func (d Direction) String() string {
+	switch d {
+	case SendDirection:
+		return "send"
+	case ReceiveDirection:
+		return "receive"
+	default:
+		return fmt.Sprintf("direction(%d)", uint8(d))
+	}
+}
+
+// PacketEvent holds the measures of a packet sent or received by a transport layer.
+type PacketEvent struct {
+	// RawSize is the size in bytes of the packet before compression.
+	RawSize int
+	// WireSize is the size in bytes of the packet as sent or received.
+	WireSize int
+	// Duration is the time spent sending the packet, or decoding it once received.
+	Duration time.Duration
+}
+
+// Metrics receives the events of the transport layers it is set to, possibly from several goroutines at once.
+type Metrics interface {
+	// OnSend is called once a packet has been sent.
+	OnSend(event PacketEvent)
+	// OnReceive is called once a packet has been received and decoded.
+	OnReceive(event PacketEvent)
+	// OnDecodeError is called when a received packet cannot be decoded.
+	OnDecodeError(err error)
+	// OnSendError is called when a packet cannot be sent for a reason other than a timeout.
+	OnSendError(err error)
+	// OnTimeout is called when a packet cannot be sent or received in time.
+	OnTimeout(direction Direction, err error)
+}
+
+// WithMetrics sets the metrics receiving the events of the transport layer.
+func WithMetrics(metrics Metrics) TransportLayerOption {
+	return func(t *TransportLayer) error {
+		t.metrics = metrics
+		return nil
+	}
+}
+
+// isTimeoutError checks if the error is caused by a timeout.
+func isTimeoutError(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrIdleTimeout) || errors.Is(err, context.DeadlineExceeded)
+}
+
+var (
+	// DefaultCompressionRatioBuckets represents the default upper bounds of the buckets of the compression ratio, as wire size over raw size.
+	DefaultCompressionRatioBuckets = []float64{0.1, 0.25, 0.5, 0.75, 0.9, 1}
+	// DefaultLatencyBuckets represents the default upper bounds in seconds of the buckets of the time spent sending or decoding a packet.
+	DefaultLatencyBuckets = []float64{0.0001, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+)
+
+// HistogramSnapshot holds the observations of a histogram, where the counts are cumulative as in the Prometheus exposition format.
+type HistogramSnapshot struct {
+	Bounds []float64
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+// histogram counts the observations in buckets.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+	mutex  sync.Mutex
+}
+
+// observe adds the value to the histogram.
+func (h *histogram) observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// snapshot returns the observations of the histogram.
+func (h *histogram) snapshot() HistogramSnapshot {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	snapshot := HistogramSnapshot{
+		Bounds: append([]float64(nil), h.bounds...),
+		Counts: make([]uint64, len(h.bounds)),
+		Count:  h.count,
+		Sum:    h.sum,
+	}
+	cumulative := uint64(0)
+	for i, count := range h.counts {
+		cumulative += count
+		snapshot.Counts[i] = cumulative
+	}
+	return snapshot
+}
+
+// newHistogram creates a new histogram with the upper bounds of its buckets.
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: slices.Clone(bounds), counts: make([]uint64, len(bounds))}
+}
+
+// DirectionSnapshot holds the metrics of the packets of a direction.
+type DirectionSnapshot struct {
+	Packets          uint64
+	RawBytes         uint64
+	WireBytes        uint64
+	Timeouts         uint64
+	CompressionRatio HistogramSnapshot
+	Latency          HistogramSnapshot
+}
+
+// MetricsSnapshot holds the metrics collected by a metrics collector.
+type MetricsSnapshot struct {
+	Sent         DirectionSnapshot
+	Received     DirectionSnapshot
+	DecodeErrors uint64
+	SendErrors   uint64
+}
+
+// directionMetrics collects the metrics of the packets of a direction.
+type directionMetrics struct {
+	packets          atomic.Uint64
+	rawBytes         atomic.Uint64
+	wireBytes        atomic.Uint64
+	timeouts         atomic.Uint64
+	compressionRatio *histogram
+	latency          *histogram
+}
+
+// observe adds the packet to the metrics.
+func (d *directionMetrics) observe(event PacketEvent) {
+	d.packets.Add(1)
+	d.rawBytes.Add(uint64(max(event.RawSize, 0)))
+	d.wireBytes.Add(uint64(max(event.WireSize, 0)))
+	if event.RawSize > 0 {
+		d.compressionRatio.observe(float64(event.WireSize) / float64(event.RawSize))
+	}
+	d.latency.observe(event.Duration.Seconds())
+}
+
+// snapshot returns the metrics of the direction.
+func (d *directionMetrics) snapshot() DirectionSnapshot {
+	return DirectionSnapshot{
+		Packets:          d.packets.Load(),
+		RawBytes:         d.rawBytes.Load(),
+		WireBytes:        d.wireBytes.Load(),
+		Timeouts:         d.timeouts.Load(),
+		CompressionRatio: d.compressionRatio.snapshot(),
+		Latency:          d.latency.snapshot(),
+	}
+}
+
+// newDirectionMetrics creates new metrics for a direction.
+func newDirectionMetrics() *directionMetrics {
+	return &directionMetrics{
+		compressionRatio: newHistogram(DefaultCompressionRatioBuckets),
+		latency:          newHistogram(DefaultLatencyBuckets),
+	}
+}
+
+// MetricsCollector collects in process the metrics of the transport layers it is set to.
+type MetricsCollector struct {
+	sent         *directionMetrics
+	received     *directionMetrics
+	decodeErrors atomic.Uint64
+	sendErrors   atomic.Uint64
+}
+
+// OnSend counts the packet sent.
+func (c *MetricsCollector) OnSend(event PacketEvent) {
+	c.sent.observe(event)
+}
+
+// OnReceive counts the packet received.
+func (c *MetricsCollector) OnReceive(event PacketEvent) {
+	c.received.observe(event)
+}
+
+// OnDecodeError counts the packet which cannot be decoded.
+func (c *MetricsCollector) OnDecodeError(error) {
+	c.decodeErrors.Add(1)
+}
+
+// OnSendError counts the packet which cannot be sent.
+func (c *MetricsCollector) OnSendError(error) {
+	c.sendErrors.Add(1)
+}
+
+// OnTimeout counts the timeout.
+func (c *MetricsCollector) OnTimeout(direction Direction, _ error) {
+	if direction == ReceiveDirection {
+		c.received.timeouts.Add(1)
+		return
+	}
+	c.sent.timeouts.Add(1)
+}
+
+// GetSnapshot returns the metrics collected so far.
+func (c *MetricsCollector) GetSnapshot() MetricsSnapshot {
+	return MetricsSnapshot{
+		Sent:         c.sent.snapshot(),
+		Received:     c.received.snapshot(),
+		DecodeErrors: c.decodeErrors.Load(),
+		SendErrors:   c.sendErrors.Load(),
+	}
+}
+
+// Expvar returns a variable exposing the metrics to the expvar package, to be published under a name of choice.
+func (c *MetricsCollector) Expvar() expvar.Var {
+	return expvar.Func(func() any { return c.GetSnapshot() })
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format.
+func (c *MetricsCollector) WritePrometheus(w io.Writer) error {
+	snapshot := c.GetSnapshot()
+	writer := &bytes.Buffer{}
+	directions := []struct {
+		name     string
+		snapshot DirectionSnapshot
+	}{{SendDirection.String(), snapshot.Sent}, {ReceiveDirection.String(), snapshot.Received}}
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(DirectionSnapshot) uint64
+	}{
+		{"notp_transport_packets_total", "Number of packets.", func(d DirectionSnapshot) uint64 { return d.Packets }},
+		{"notp_transport_raw_bytes_total", "Number of bytes before compression.", func(d DirectionSnapshot) uint64 { return d.RawBytes }},
+		{"notp_transport_wire_bytes_total", "Number of bytes as sent or received.", func(d DirectionSnapshot) uint64 { return d.WireBytes }},
+		{"notp_transport_timeouts_total", "Number of timeouts.", func(d DirectionSnapshot) uint64 { return d.Timeouts }},
+	}
+	for _, counter := range counters {
+		fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s counter\n", counter.name, counter.help, counter.name)
+		for _, direction := range directions {
+			fmt.Fprintf(writer, "%s{direction=%q} %d\n", counter.name, direction.name, counter.value(direction.snapshot))
+		}
+	}
+	fmt.Fprintf(writer, "# HELP notp_transport_decode_errors_total Number of received packets which cannot be decoded.\n")
+	fmt.Fprintf(writer, "# TYPE notp_transport_decode_errors_total counter\n")
+	fmt.Fprintf(writer, "notp_transport_decode_errors_total %d\n", snapshot.DecodeErrors)
+	fmt.Fprintf(writer, "# HELP notp_transport_send_errors_total Number of packets which cannot be sent for a reason other than a timeout.\n")
+	fmt.Fprintf(writer, "# TYPE notp_transport_send_errors_total counter\n")
+	fmt.Fprintf(writer, "notp_transport_send_errors_total %d\n", snapshot.SendErrors)
+
+	histograms := []struct {
+		name  string
+		help  string
+		value func(DirectionSnapshot) HistogramSnapshot
+	}{
+		{"notp_transport_compression_ratio", "Ratio of the size as sent or received over the size before compression.", func(d DirectionSnapshot) HistogramSnapshot { return d.CompressionRatio }},
+		{"notp_transport_latency_seconds", "Time spent sending a packet or decoding a received one.", func(d DirectionSnapshot) HistogramSnapshot { return d.Latency }},
+	}
+	for _, histogram := range histograms {
+		fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s histogram\n", histogram.name, histogram.help, histogram.name)
+		for _, direction := range directions {
+			writePrometheusHistogram(writer, histogram.name, direction.name, histogram.value(direction.snapshot))
+		}
+	}
+	_, err := writer.WriteTo(w)
+	return err
+}
+
+// writePrometheusHistogram writes the histogram of the direction in the Prometheus text exposition format.
+func writePrometheusHistogram(w io.Writer, name, direction string, histogram HistogramSnapshot) {
+	for i, bound := range histogram.Bounds {
+		fmt.Fprintf(w, "%s_bucket{direction=%q,le=%q} %d\n", name, direction, formatPrometheusFloat(bound), histogram.Counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{direction=%q,le=\"+Inf\"} %d\n", name, direction, histogram.Count)
+	fmt.Fprintf(w, "%s_sum{direction=%q} %s\n", name, direction, formatPrometheusFloat(histogram.Sum))
+	fmt.Fprintf(w, "%s_count{direction=%q} %d\n", name, direction, histogram.Count)
+}
+
+// formatPrometheusFloat formats the value as expected by the Prometheus text exposition format.
+func formatPrometheusFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// PrometheusHandler returns a handler serving the metrics in the Prometheus text exposition format.
+func (c *MetricsCollector) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = c.WritePrometheus(w)
+	})
+}
+
+// NewMetricsCollector creates and initializes a new metrics collector.
+func NewMetricsCollector() *MetricsCollector {
+	return &MetricsCollector{
+		sent:     newDirectionMetrics(),
+		received: newDirectionMetrics(),
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// TestMetricsCollector tests the metrics collected from the packets sent and received by transport layers.
+func TestMetricsCollector(t *testing.T) {
+	assert := assert.New(t)
+	left, right, err := NewInMemoryPipe(WithPipeTimeout(20 * time.Millisecond))
+	assert.Nil(err)
+	collector := NewMetricsCollector()
+	sender, err := NewStreamTransportLayer(left, nil, WithMetrics(collector))
+	assert.Nil(err)
+	receiver, err := NewStreamTransportLayer(right, nil, WithMetrics(collector))
+	assert.Nil(err)
+
+	for _, pair := range [][2]*TransportLayer{{sender, receiver}, {receiver, sender}} {
+		assert.Nil(pair[0].TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("hello")}}))
+		_, err = pair[1].ReceivePacket()
+		assert.Nil(err)
+	}
+	assert.Nil(sender.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: make([]byte, 100000)}}))
+	_, err = receiver.ReceivePacket()
+	assert.Nil(err)
+	_, err = receiver.ReceivePacket()
+	assert.ErrorIs(err, ErrTimeout)
+	assert.Nil(left.TransmitPacket(&notppackets.Packet{Data: []byte{0, 1, 2}}))
+	_, err = receiver.ReceivePacket()
+	assert.NotNil(err)
+
+	snapshot := collector.GetSnapshot()
+	assert.Equal(uint64(3), snapshot.Sent.Packets)
+	assert.Equal(uint64(3), snapshot.Received.Packets)
+	assert.Equal(snapshot.Sent.RawBytes, snapshot.Received.RawBytes)
+	assert.Equal(snapshot.Sent.WireBytes, snapshot.Received.WireBytes)
+	assert.Greater(snapshot.Sent.RawBytes, uint64(100000))
+	assert.Less(snapshot.Sent.WireBytes, uint64(10000))
+	assert.Equal(uint64(0), snapshot.Sent.Timeouts)
+	assert.Equal(uint64(1), snapshot.Received.Timeouts)
+	assert.Equal(uint64(1), snapshot.DecodeErrors)
+	for _, histogram := range []HistogramSnapshot{snapshot.Sent.CompressionRatio, snapshot.Received.Latency} {
+		assert.Equal(uint64(3), histogram.Count)
+		assert.Len(histogram.Counts, len(histogram.Bounds))
+		assert.LessOrEqual(histogram.Counts[len(histogram.Counts)-1], histogram.Count)
+	}
+	assert.Equal(uint64(1), snapshot.Sent.CompressionRatio.Counts[0])
+	assert.Equal(snapshot.Sent.CompressionRatio, snapshot.Received.CompressionRatio)
+}
+
+// TestMetricsCollectorLatency tests that the latency of the received packets excludes the wait for them.
+func TestMetricsCollectorLatency(t *testing.T) {
+	assert := assert.New(t)
+	left, right, err := NewInMemoryPipe(WithPipeTimeout(time.Second))
+	assert.Nil(err)
+	collector := NewMetricsCollector()
+	sender, err := NewStreamTransportLayer(left, nil)
+	assert.Nil(err)
+	receiver, err := NewStreamTransportLayer(right, nil, WithMetrics(collector))
+	assert.Nil(err)
+
+	time.AfterFunc(200*time.Millisecond, func() {
+		_ = sender.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("hello")}})
+	})
+	_, err = receiver.ReceivePacket()
+	assert.Nil(err)
+	latency := collector.GetSnapshot().Received.Latency
+	assert.Equal(uint64(1), latency.Count)
+	assert.Less(latency.Sum, 0.1)
+}
+
+// TestMetricsCollectorSendErrors tests that the packets which cannot be sent are counted apart from the timeouts.
+func TestMetricsCollectorSendErrors(t *testing.T) {
+	assert := assert.New(t)
+	collector := NewMetricsCollector()
+	sendErrs := []error{ErrTimeout, errors.New("notp: broken connection"), io.ErrClosedPipe}
+	sent := 0
+	transport, err := NewTransportLayer(func(*notppackets.Packet) error {
+		sent++
+		return sendErrs[sent-1]
+	}, func() (*notppackets.Packet, error) { return nil, nil }, nil, WithMetrics(collector))
+	assert.Nil(err)
+
+	for _, sendErr := range sendErrs {
+		assert.ErrorIs(transport.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("hello")}}), sendErr)
+	}
+	snapshot := collector.GetSnapshot()
+	assert.Equal(uint64(0), snapshot.Sent.Packets)
+	assert.Equal(uint64(1), snapshot.Sent.Timeouts)
+	assert.Equal(uint64(2), snapshot.SendErrors)
+}
+
+// TestMetricsCollectorBuckets tests that the collectors keep their buckets when the default ones change.
+func TestMetricsCollectorBuckets(t *testing.T) {
+	assert := assert.New(t)
+	collector := NewMetricsCollector()
+	bounds := slices.Clone(DefaultLatencyBuckets)
+	DefaultLatencyBuckets[0] = 100
+	defer func() { DefaultLatencyBuckets[0] = bounds[0] }()
+	assert.Equal(bounds, collector.GetSnapshot().Sent.Latency.Bounds)
+}
+
+// TestMetricsCollectorExporters tests the exposition of the metrics to expvar and to Prometheus.
+func TestMetricsCollectorExporters(t *testing.T) {
+	assert := assert.New(t)
+	collector := NewMetricsCollector()
+	collector.OnSend(PacketEvent{RawSize: 100, WireSize: 40, Duration: 2 * time.Millisecond})
+	collector.OnReceive(PacketEvent{RawSize: 100, WireSize: 100, Duration: time.Second})
+	collector.OnTimeout(SendDirection, ErrTimeout)
+	collector.OnDecodeError(ErrLimitExceeded)
+	collector.OnSendError(io.ErrClosedPipe)
+
+	snapshot := MetricsSnapshot{}
+	assert.Nil(json.Unmarshal([]byte(collector.Expvar().String()), &snapshot))
+	assert.Equal(collector.GetSnapshot(), snapshot)
+
+	recorder := httptest.NewRecorder()
+	collector.PrometheusHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.True(strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4"))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE notp_transport_packets_total counter",
+		`notp_transport_packets_total{direction="send"} 1`,
+		`notp_transport_raw_bytes_total{direction="receive"} 100`,
+		`notp_transport_wire_bytes_total{direction="send"} 40`,
+		`notp_transport_timeouts_total{direction="send"} 1`,
+		`notp_transport_timeouts_total{direction="receive"} 0`,
+		"notp_transport_decode_errors_total 1",
+		"# TYPE notp_transport_send_errors_total counter",
+		"notp_transport_send_errors_total 1",
+		"# TYPE notp_transport_compression_ratio histogram",
+		`notp_transport_compression_ratio_bucket{direction="send",le="0.25"} 0`,
+		`notp_transport_compression_ratio_bucket{direction="send",le="0.5"} 1`,
+		`notp_transport_compression_ratio_sum{direction="send"} 0.4`,
+		`notp_transport_latency_seconds_bucket{direction="receive",le="0.5"} 0`,
+		`notp_transport_latency_seconds_bucket{direction="receive",le="1"} 1`,
+		`notp_transport_latency_seconds_bucket{direction="receive",le="+Inf"} 1`,
+		`notp_transport_latency_seconds_count{direction="receive"} 1`,
+	} {
+		assert.Contains(body, line+"\n")
+	}
+}
@@ -0,0 +1,660 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transport implements the transport layer of the NOTP protocol.
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+const (
+	// DefaultMuxBufferSize represents the default number of packets a multiplexed stream holds, which the peer may send ahead of the reader.
+	DefaultMuxBufferSize = 16
+	// DefaultMuxAcceptBacklog represents the default number of opened streams waiting to be accepted.
+	DefaultMuxAcceptBacklog = 16
+
+	// muxHeaderSize represents the size in bytes of the header of a multiplexed frame.
+	muxHeaderSize = 5
+
+	// muxOpenFrame opens a stream.
+	muxOpenFrame = byte(1)
+	// muxDataFrame carries a packet of a stream.
+	muxDataFrame = byte(2)
+	// muxFinFrame closes a stream for writing.
+	muxFinFrame = byte(3)
+	// muxCloseFrame closes a stream.
+	muxCloseFrame = byte(4)
+	// muxWindowFrame grants the peer the sending of more packets of a stream.
+	muxWindowFrame = byte(5)
+	// muxWindowSize represents the size in bytes of the increment carried by a window frame.
+	muxWindowSize = 4
+)
+
+// muxFrame represents a frame waiting to be sent by the multiplexer.
+type muxFrame struct {
+	kind      byte
+	channelID uint32
+	data      []byte
+	done      chan error
+	cancelled atomic.Bool
+}
+
+// encode encodes the frame into a packet.
+func (f *muxFrame) encode() *notppackets.Packet {
+	data := make([]byte, muxHeaderSize, muxHeaderSize+len(f.data))
+	data[0] = f.kind
+	binary.BigEndian.PutUint32(data[1:], f.channelID)
+	return &notppackets.Packet{Data: append(data, f.data...)}
+}
+
+// newMuxWindowFrame creates a frame granting the peer the sending of more packets of the stream.
+func newMuxWindowFrame(channelID uint32, increment uint32) *muxFrame {
+	return &muxFrame{kind: muxWindowFrame, channelID: channelID, data: binary.BigEndian.AppendUint32(nil, increment)}
+}
+
+// complete reports the outcome of sending the frame.
+func (f *muxFrame) complete(err error) {
+	if f.done != nil {
+		f.done <- err
+	}
+}
+
+// decodeMuxFrame decodes a frame from a packet.
+func decodeMuxFrame(packet *notppackets.Packet) (*muxFrame, error) {
+	if packet == nil || len(packet.Data) < muxHeaderSize {
+		return nil, errors.New("notp: invalid multiplexed frame")
+	}
+	frame := &muxFrame{
+		kind:      packet.Data[0],
+		channelID: binary.BigEndian.Uint32(packet.Data[1:]),
+		data:      packet.Data[muxHeaderSize:],
+	}
+	if frame.kind < muxOpenFrame || frame.kind > muxWindowFrame {
+		return nil, fmt.Errorf("notp: invalid multiplexed frame kind %d", frame.kind)
+	}
+	if frame.kind == muxWindowFrame && len(frame.data) != muxWindowSize {
+		return nil, errors.New("notp: invalid multiplexed window frame")
+	}
+	return frame, nil
+}
+
+// MuxStream is a logical stream of a multiplexer, which can be used as the stream of its own transport layer.
+type MuxStream struct {
+	mux               *Multiplexer
+	id                uint32
+	incoming          chan *notppackets.Packet
+	outgoing          []*muxFrame
+	scheduled         bool
+	credit            uint64
+	consumed          int
+	writeClosed       *closeSignal
+	closed            *closeSignal
+	remoteWriteClosed *closeSignal
+	remoteClosed      *closeSignal
+}
+
+// canSend checks if the stream has a frame to send within the window granted by the peer, which bounds only the data frames.
+func (s *MuxStream) canSend() bool {
+	return len(s.outgoing) > 0 && (s.outgoing[0].kind != muxDataFrame || s.credit > 0)
+}
+
+// GetID returns the channel identifier of the stream.
+func (s *MuxStream) GetID() uint32 {
+	return s.id
+}
+
+// TransmitPacket sends a packet through the stream.
+func (s *MuxStream) TransmitPacket(packet *notppackets.Packet) error {
+	return s.TransmitPacketContext(context.Background(), packet)
+}
+
+// TransmitPacketContext sends a packet through the stream, waiting for its turn on the connection until the context is done.
+func (s *MuxStream) TransmitPacketContext(ctx context.Context, packet *notppackets.Packet) error {
+	if packet == nil {
+		return errors.New("notp: cannot transmit a nil packet")
+	}
+	if s.writeClosed.isClosed() || s.remoteClosed.isClosed() {
+		return ErrClosed
+	}
+	frame := &muxFrame{kind: muxDataFrame, channelID: s.id, data: packet.Data, done: make(chan error, 1)}
+	if err := s.mux.schedule(s, frame); err != nil {
+		return err
+	}
+	select {
+	case err := <-frame.done:
+		return err
+	case <-s.closed.done():
+		s.mux.cancel(s, frame)
+		return ErrClosed
+	case <-s.remoteClosed.done():
+		s.mux.cancel(s, frame)
+		return ErrClosed
+	case <-ctx.Done():
+		s.mux.cancel(s, frame)
+		return context.Cause(ctx)
+	}
+}
+
+// ReceivePacket retrieves the oldest packet received by the stream.
+func (s *MuxStream) ReceivePacket() (*notppackets.Packet, error) {
+	return s.ReceivePacketContext(context.Background())
+}
+
+// ReceivePacketContext retrieves the oldest packet received by the stream, giving up once the context is done.
+// Once the peer closes the stream for writing the remaining packets are drained before returning io.EOF.
+func (s *MuxStream) ReceivePacketContext(ctx context.Context) (*notppackets.Packet, error) {
+	if s.closed.isClosed() {
+		return nil, ErrClosed
+	}
+	select {
+	case packet := <-s.incoming:
+		s.mux.release(s)
+		return packet, nil
+	case <-s.closed.done():
+		return nil, ErrClosed
+	case <-s.remoteWriteClosed.done():
+		select {
+		case packet := <-s.incoming:
+			return packet, nil
+		default:
+			return nil, s.mux.getStreamEndError()
+		}
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// CloseWrite closes the stream for writing once the packets being sent are delivered, so that the peer receives io.EOF.
+func (s *MuxStream) CloseWrite() error {
+	if s.closed.isClosed() || !s.writeClosed.close() || s.remoteClosed.isClosed() {
+		return nil
+	}
+	return s.mux.schedule(s, &muxFrame{kind: muxFinFrame, channelID: s.id})
+}
+
+// Close closes the stream, discarding the packets not sent yet and waking up the pending calls with ErrClosed.
+func (s *MuxStream) Close() error {
+	if !s.closed.close() {
+		return nil
+	}
+	s.writeClosed.close()
+	if !s.mux.removeStream(s) {
+		return nil
+	}
+	return s.mux.scheduleControl(&muxFrame{kind: muxCloseFrame, channelID: s.id})
+}
+
+// closeRemote marks the stream as closed by the peer.
+func (s *MuxStream) closeRemote(closeAll bool) {
+	s.remoteWriteClosed.close()
+	if closeAll {
+		s.remoteClosed.close()
+	}
+}
+
+// MultiplexerOption defines a function to configure the multiplexer.
+type MultiplexerOption func(*Multiplexer) error
+
+// WithMuxBufferSize sets the number of received packets each stream holds, which is the window granted to the peer.
+func WithMuxBufferSize(bufferSize int) MultiplexerOption {
+	return func(m *Multiplexer) error {
+		if bufferSize <= 0 {
+			return fmt.Errorf("notp: invalid multiplexer buffer size %d", bufferSize)
+		}
+		m.bufferSize = bufferSize
+		return nil
+	}
+}
+
+// WithMuxAcceptBacklog sets the number of opened streams waiting to be accepted, beyond which the peer streams are refused.
+func WithMuxAcceptBacklog(backlog int) MultiplexerOption {
+	return func(m *Multiplexer) error {
+		if backlog <= 0 {
+			return fmt.Errorf("notp: invalid multiplexer accept backlog %d", backlog)
+		}
+		m.backlog = backlog
+		return nil
+	}
+}
+
+// WithMuxCloser sets the function closing the underlying connection when the multiplexer is closed.
+func WithMuxCloser(closeFunc StreamCloseFunc) MultiplexerOption {
+	return func(m *Multiplexer) error {
+		m.closeFunc = closeFunc
+		return nil
+	}
+}
+
+// Multiplexer carries many logical streams over a single connection, tagging each frame with the channel identifier of its stream.
+// The frames are sent in turn: the control frames first, then one frame per stream in round robin, so that a stream
+// with many packets cannot starve the others. Each stream sends its packets within the window granted by the peer,
+// which grows as the peer reads them, so that a stream left unread cannot stall the others.
+type Multiplexer struct {
+	sender     ContextPacketSender
+	receiver   ContextPacketReceiver
+	closeFunc  StreamCloseFunc
+	bufferSize int
+	backlog    int
+	nextID     uint32
+	streams    map[uint32]*MuxStream
+	accepted   chan *MuxStream
+	control    []*muxFrame
+	ready      []*MuxStream
+	wake       chan struct{}
+	closed     *closeSignal
+	ended      *closeSignal
+	endErr     error
+	mutex      sync.Mutex
+}
+
+// Open opens a new stream, notifying the peer which receives it from Accept.
+func (m *Multiplexer) Open(ctx context.Context) (*MuxStream, error) {
+	m.mutex.Lock()
+	if m.ended.isClosed() {
+		m.mutex.Unlock()
+		return nil, m.getEndError()
+	}
+	if m.nextID > math.MaxUint32-2 {
+		m.mutex.Unlock()
+		return nil, errors.New("notp: multiplexer channel identifiers exhausted")
+	}
+	stream := m.newStream(m.nextID)
+	m.nextID += 2
+	m.streams[stream.id] = stream
+	m.mutex.Unlock()
+
+	frame := &muxFrame{kind: muxOpenFrame, channelID: stream.id, done: make(chan error, 1)}
+	if err := m.scheduleControl(frame); err != nil {
+		return nil, err
+	}
+	if err := m.scheduleControl(newMuxWindowFrame(stream.id, uint32(m.bufferSize))); err != nil {
+		return nil, err
+	}
+	select {
+	case err := <-frame.done:
+		if err != nil {
+			return nil, err
+		}
+		return stream, nil
+	case <-ctx.Done():
+		frame.cancelled.Store(true)
+		_ = stream.Close()
+		return nil, context.Cause(ctx)
+	}
+}
+
+// Accept waits for the next stream opened by the peer.
+func (m *Multiplexer) Accept(ctx context.Context) (*MuxStream, error) {
+	select {
+	case stream := <-m.accepted:
+		return stream, nil
+	case <-m.ended.done():
+		select {
+		case stream := <-m.accepted:
+			return stream, nil
+		default:
+			return nil, m.getEndError()
+		}
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// Close closes the multiplexer, its streams and the underlying connection.
+func (m *Multiplexer) Close() error {
+	if !m.closed.close() {
+		return nil
+	}
+	m.end(ErrClosed)
+	m.mutex.Lock()
+	streams := make([]*MuxStream, 0, len(m.streams))
+	for _, stream := range m.streams {
+		streams = append(streams, stream)
+	}
+	m.mutex.Unlock()
+	for _, stream := range streams {
+		stream.closed.close()
+		stream.writeClosed.close()
+	}
+	if m.closeFunc != nil {
+		return m.closeFunc()
+	}
+	return nil
+}
+
+// newStream creates a stream with the channel identifier.
+func (m *Multiplexer) newStream(id uint32) *MuxStream {
+	return &MuxStream{
+		mux:               m,
+		id:                id,
+		incoming:          make(chan *notppackets.Packet, m.bufferSize),
+		writeClosed:       newCloseSignal(),
+		closed:            newCloseSignal(),
+		remoteWriteClosed: newCloseSignal(),
+		remoteClosed:      newCloseSignal(),
+	}
+}
+
+// removeStream removes the stream, returning false if it was already removed.
+func (m *Multiplexer) removeStream(stream *MuxStream) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.streams[stream.id] != stream {
+		return false
+	}
+	delete(m.streams, stream.id)
+	return !m.ended.isClosed()
+}
+
+// schedule queues the frame of the stream for sending in its turn.
+func (m *Multiplexer) schedule(stream *MuxStream, frame *muxFrame) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.ended.isClosed() {
+		return m.getEndError()
+	}
+	stream.outgoing = append(stream.outgoing, frame)
+	m.makeReady(stream)
+	return nil
+}
+
+// cancel removes the frame of the stream not sent yet, so that it holds back neither the window nor the frames behind it.
+func (m *Multiplexer) cancel(stream *MuxStream, frame *muxFrame) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if i := slices.Index(stream.outgoing, frame); i >= 0 {
+		stream.outgoing = slices.Delete(stream.outgoing, i, i+1)
+		m.makeReady(stream)
+	}
+}
+
+// makeReady queues the stream for sending in its turn if it has a frame to send within its window.
+func (m *Multiplexer) makeReady(stream *MuxStream) {
+	if stream.scheduled || !stream.canSend() {
+		return
+	}
+	stream.scheduled = true
+	m.ready = append(m.ready, stream)
+	m.notify()
+}
+
+// release grants the peer a window update once half of the packets the stream holds have been read.
+func (m *Multiplexer) release(stream *MuxStream) {
+	m.mutex.Lock()
+	stream.consumed++
+	if stream.consumed < max(1, m.bufferSize/2) || stream.remoteWriteClosed.isClosed() {
+		m.mutex.Unlock()
+		return
+	}
+	increment := stream.consumed
+	stream.consumed = 0
+	m.mutex.Unlock()
+	_ = m.scheduleControl(newMuxWindowFrame(stream.id, uint32(increment)))
+}
+
+// scheduleControl queues the control frame for sending before the frames of the streams.
+func (m *Multiplexer) scheduleControl(frame *muxFrame) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.ended.isClosed() {
+		return m.getEndError()
+	}
+	m.control = append(m.control, frame)
+	m.notify()
+	return nil
+}
+
+// notify wakes up the writer.
+func (m *Multiplexer) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the next frame to send, or nil once the multiplexer has ended.
+func (m *Multiplexer) next() *muxFrame {
+	for {
+		m.mutex.Lock()
+		if len(m.control) > 0 {
+			frame := m.control[0]
+			m.control = m.control[1:]
+			if frame.cancelled.Load() {
+				m.mutex.Unlock()
+				continue
+			}
+			m.mutex.Unlock()
+			return frame
+		}
+		for len(m.ready) > 0 {
+			stream := m.ready[0]
+			m.ready = m.ready[1:]
+			stream.scheduled = false
+			if !stream.canSend() {
+				continue
+			}
+			frame := stream.outgoing[0]
+			stream.outgoing = stream.outgoing[1:]
+			if frame.kind == muxDataFrame {
+				stream.credit--
+			}
+			m.makeReady(stream)
+			if stream.closed.isClosed() {
+				frame.complete(ErrClosed)
+				continue
+			}
+			m.mutex.Unlock()
+			return frame
+		}
+		m.mutex.Unlock()
+		select {
+		case <-m.wake:
+		case <-m.ended.done():
+			return nil
+		}
+	}
+}
+
+// writeLoop sends the scheduled frames in turn until the multiplexer ends.
+func (m *Multiplexer) writeLoop() {
+	for {
+		frame := m.next()
+		if frame == nil {
+			return
+		}
+		err := m.sender(m.closed.ctx, frame.encode())
+		frame.complete(err)
+		if err != nil {
+			m.end(err)
+			return
+		}
+	}
+}
+
+// readLoop dispatches the received frames to their streams until the multiplexer ends.
+func (m *Multiplexer) readLoop() {
+	for {
+		packet, err := m.receiver(m.closed.ctx)
+		if err != nil {
+			m.end(err)
+			return
+		}
+		frame, err := decodeMuxFrame(packet)
+		if err != nil {
+			m.end(err)
+			return
+		}
+		if err = m.dispatch(frame); err != nil {
+			m.end(err)
+			return
+		}
+	}
+}
+
+// dispatch delivers the received frame to its stream.
+func (m *Multiplexer) dispatch(frame *muxFrame) error {
+	m.mutex.Lock()
+	stream := m.streams[frame.channelID]
+	if frame.kind == muxOpenFrame {
+		if stream != nil || frame.channelID%2 == m.nextID%2 {
+			m.mutex.Unlock()
+			return fmt.Errorf("notp: invalid multiplexed stream %d opened by the peer", frame.channelID)
+		}
+		stream = m.newStream(frame.channelID)
+		m.streams[stream.id] = stream
+	} else if frame.kind == muxCloseFrame && stream != nil {
+		delete(m.streams, stream.id)
+	}
+	m.mutex.Unlock()
+	if stream == nil {
+		return nil
+	}
+	switch frame.kind {
+	case muxOpenFrame:
+		select {
+		case m.accepted <- stream:
+			return m.scheduleControl(newMuxWindowFrame(stream.id, uint32(m.bufferSize)))
+		default:
+			stream.closed.close()
+			if m.removeStream(stream) {
+				return m.scheduleControl(&muxFrame{kind: muxCloseFrame, channelID: stream.id})
+			}
+		}
+	case muxDataFrame:
+		if stream.remoteWriteClosed.isClosed() || stream.closed.isClosed() {
+			return nil
+		}
+		select {
+		case stream.incoming <- &notppackets.Packet{Data: frame.data}:
+		default:
+			// The peer exceeded the window of the stream, which is reset without stalling the other streams.
+			return stream.Close()
+		}
+	case muxWindowFrame:
+		m.mutex.Lock()
+		stream.credit += uint64(binary.BigEndian.Uint32(frame.data))
+		m.makeReady(stream)
+		m.mutex.Unlock()
+	case muxFinFrame:
+		stream.closeRemote(false)
+	case muxCloseFrame:
+		stream.closeRemote(true)
+	}
+	return nil
+}
+
+// end ends the multiplexer with the error, waking up its streams.
+func (m *Multiplexer) end(err error) {
+	m.mutex.Lock()
+	if m.ended.isClosed() {
+		m.mutex.Unlock()
+		return
+	}
+	m.endErr = err
+	m.ended.close()
+	streams := make([]*MuxStream, 0, len(m.streams))
+	for _, stream := range m.streams {
+		streams = append(streams, stream)
+	}
+	control := m.control
+	ready := m.ready
+	m.control = nil
+	m.ready = nil
+	m.mutex.Unlock()
+	for _, frame := range control {
+		frame.complete(ErrClosed)
+	}
+	for _, stream := range ready {
+		for _, frame := range stream.outgoing {
+			frame.complete(ErrClosed)
+		}
+	}
+	for _, stream := range streams {
+		stream.closeRemote(true)
+	}
+}
+
+// getEndError returns the error the multiplexer ended with.
+func (m *Multiplexer) getEndError() error {
+	if m.closed.isClosed() {
+		return ErrClosed
+	}
+	return m.endErr
+}
+
+// getStreamEndError returns the error of the streams closed by the peer.
+func (m *Multiplexer) getStreamEndError() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.endErr != nil && !errors.Is(m.endErr, io.EOF) && !errors.Is(m.endErr, ErrClosed) {
+		return m.endErr
+	}
+	return io.EOF
+}
+
+// NewMultiplexer creates and initializes a new multiplexer over the connection, where the initiator opens the streams
+// with odd channel identifiers and its peer with even ones.
+func NewMultiplexer(sender ContextPacketSender, receiver ContextPacketReceiver, initiator bool, opts ...MultiplexerOption) (*Multiplexer, error) {
+	if sender == nil {
+		return nil, errors.New("notp: ContextPacketSender cannot be nil")
+	}
+	if receiver == nil {
+		return nil, errors.New("notp: ContextPacketReceiver cannot be nil")
+	}
+	mux := &Multiplexer{
+		sender:     sender,
+		receiver:   receiver,
+		bufferSize: DefaultMuxBufferSize,
+		backlog:    DefaultMuxAcceptBacklog,
+		nextID:     2,
+		streams:    map[uint32]*MuxStream{},
+		wake:       make(chan struct{}, 1),
+		closed:     newCloseSignal(),
+		ended:      newCloseSignal(),
+	}
+	if initiator {
+		mux.nextID = 1
+	}
+	for _, opt := range opts {
+		if err := opt(mux); err != nil {
+			return nil, err
+		}
+	}
+	mux.accepted = make(chan *MuxStream, mux.backlog)
+	go mux.readLoop()
+	go mux.writeLoop()
+	return mux, nil
+}
+
+// NewStreamMultiplexer creates and initializes a new multiplexer over the stream, which is closed along with the multiplexer.
+func NewStreamMultiplexer(stream Stream, initiator bool, opts ...MultiplexerOption) (*Multiplexer, error) {
+	if stream == nil {
+		return nil, errors.New("notp: stream cannot be nil")
+	}
+	opts = append([]MultiplexerOption{WithMuxCloser(stream.Close)}, opts...)
+	return NewMultiplexer(stream.TransmitPacketContext, stream.ReceivePacketContext, initiator, opts...)
+}
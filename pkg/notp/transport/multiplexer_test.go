@@ -0,0 +1,325 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// buildMultiplexers initializes and returns two multiplexers connected through an in-memory pipe.
+func buildMultiplexers(assert *assert.Assertions, initiatorOpts, acceptorOpts []MultiplexerOption) (*Multiplexer, *Multiplexer) {
+	left, right, err := NewInMemoryPipe(WithPipeTimeout(time.Minute))
+	assert.Nil(err)
+	initiator, err := NewStreamMultiplexer(left, true, initiatorOpts...)
+	assert.Nil(err)
+	acceptor, err := NewStreamMultiplexer(right, false, acceptorOpts...)
+	assert.Nil(err)
+	return initiator, acceptor
+}
+
+// TestMultiplexerStreams tests the concurrent exchange of packets between transport layers over multiplexed streams.
+func TestMultiplexerStreams(t *testing.T) {
+	assert := assert.New(t)
+	initiator, acceptor := buildMultiplexers(assert, nil, nil)
+	defer initiator.Close()
+	defer acceptor.Close()
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := range 4 {
+		stream, err := initiator.Open(ctx)
+		assert.Nil(err)
+		assert.Equal(uint32(2*i+1), stream.GetID())
+		peerStream, err := acceptor.Accept(ctx)
+		assert.Nil(err)
+		assert.Equal(stream.GetID(), peerStream.GetID())
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			follower, err := NewStreamTransportLayer(stream, nil)
+			assert.Nil(err)
+			leader, err := NewStreamTransportLayer(peerStream, nil)
+			assert.Nil(err)
+			for j := range 20 {
+				request := []notppackets.Packetable{&notppackets.Packet{Data: fmt.Appendf(nil, "request %d/%d", stream.GetID(), j)}}
+				assert.Nil(follower.TransmitPacketContext(ctx, request))
+				received, err := leader.ReceivePacketContext(ctx)
+				assert.Nil(err)
+				assert.Equal(request, received)
+				response := []notppackets.Packetable{&notppackets.Packet{Data: fmt.Appendf(nil, "response %d/%d", stream.GetID(), j)}}
+				assert.Nil(leader.TransmitPacketContext(ctx, response))
+				received, err = follower.ReceivePacketContext(ctx)
+				assert.Nil(err)
+				assert.Equal(response, received)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stream, err := acceptor.Open(ctx)
+	assert.Nil(err)
+	assert.Equal(uint32(2), stream.GetID())
+	peerStream, err := initiator.Accept(ctx)
+	assert.Nil(err)
+	assert.Equal(uint32(2), peerStream.GetID())
+}
+
+// TestMultiplexerFairness tests that a stream with many packets does not starve another one.
+func TestMultiplexerFairness(t *testing.T) {
+	assert := assert.New(t)
+	left, right, err := NewInMemoryPipe(WithPipeTimeout(time.Minute), WithPipeBufferSize(1000))
+	assert.Nil(err)
+	var sentMutex sync.Mutex
+	sent := []uint32{}
+	sender := func(ctx context.Context, packet *notppackets.Packet) error {
+		time.Sleep(100 * time.Microsecond)
+		frame, err := decodeMuxFrame(packet)
+		assert.Nil(err)
+		if frame.kind == muxDataFrame {
+			sentMutex.Lock()
+			sent = append(sent, frame.channelID)
+			sentMutex.Unlock()
+		}
+		return left.TransmitPacketContext(ctx, packet)
+	}
+	initiator, err := NewMultiplexer(sender, left.ReceivePacketContext, true, WithMuxCloser(left.Close))
+	assert.Nil(err)
+	defer initiator.Close()
+	acceptor, err := NewStreamMultiplexer(right, false, WithMuxBufferSize(1000))
+	assert.Nil(err)
+	defer acceptor.Close()
+
+	ctx := context.Background()
+	bulk, err := initiator.Open(ctx)
+	assert.Nil(err)
+	control, err := initiator.Open(ctx)
+	assert.Nil(err)
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				assert.Nil(bulk.TransmitPacket(&notppackets.Packet{Data: make([]byte, 1024)}))
+			}
+		}()
+	}
+	time.Sleep(5 * time.Millisecond)
+	for range 5 {
+		assert.Nil(control.TransmitPacket(&notppackets.Packet{Data: []byte("control")}))
+	}
+	wg.Wait()
+
+	sentMutex.Lock()
+	defer sentMutex.Unlock()
+	last := 0
+	for i, id := range sent {
+		if id == control.GetID() {
+			last = i
+		}
+	}
+	assert.Len(sent, 205)
+	assert.Less(last, 100, "control packets starved by the bulk stream")
+}
+
+// TestMultiplexerReceiveFairness tests that a stream left unread does not stall the packets of another one.
+func TestMultiplexerReceiveFairness(t *testing.T) {
+	assert := assert.New(t)
+	initiator, acceptor := buildMultiplexers(assert, []MultiplexerOption{WithMuxBufferSize(4)}, []MultiplexerOption{WithMuxBufferSize(4)})
+	defer initiator.Close()
+	defer acceptor.Close()
+
+	ctx := context.Background()
+	bulk, err := initiator.Open(ctx)
+	assert.Nil(err)
+	control, err := initiator.Open(ctx)
+	assert.Nil(err)
+	peerBulk, err := acceptor.Accept(ctx)
+	assert.Nil(err)
+	peerControl, err := acceptor.Accept(ctx)
+	assert.Nil(err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range 10 {
+			assert.Nil(bulk.TransmitPacketContext(ctx, &notppackets.Packet{Data: []byte{byte(i)}}))
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(control.TransmitPacket(&notppackets.Packet{Data: []byte("control")}))
+	receiveCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	packet, err := peerControl.ReceivePacketContext(receiveCtx)
+	assert.Nil(err)
+	assert.Equal([]byte("control"), packet.Data)
+
+	for i := range 10 {
+		packet, err := peerBulk.ReceivePacketContext(receiveCtx)
+		assert.Nil(err)
+		assert.Equal([]byte{byte(i)}, packet.Data)
+	}
+	wg.Wait()
+}
+
+// TestMultiplexerWindowExceeded tests that a stream receiving more packets than its window is reset.
+func TestMultiplexerWindowExceeded(t *testing.T) {
+	assert := assert.New(t)
+	left, right, err := NewInMemoryPipe(WithPipeTimeout(time.Minute))
+	assert.Nil(err)
+	mux, err := NewStreamMultiplexer(left, true, WithMuxBufferSize(2))
+	assert.Nil(err)
+	defer mux.Close()
+
+	assert.Nil(right.TransmitPacket((&muxFrame{kind: muxOpenFrame, channelID: 2}).encode()))
+	stream, err := mux.Accept(context.Background())
+	assert.Nil(err)
+	packet, err := right.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal(newMuxWindowFrame(2, 2).encode(), packet)
+	for range 3 {
+		assert.Nil(right.TransmitPacket((&muxFrame{kind: muxDataFrame, channelID: 2, data: []byte("data")}).encode()))
+	}
+	packet, err = right.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal((&muxFrame{kind: muxCloseFrame, channelID: 2}).encode(), packet)
+	_, err = stream.ReceivePacket()
+	assert.ErrorIs(err, ErrClosed)
+}
+
+// TestMultiplexerCancelledFrame tests that a packet given up for lack of window does not hold back the half-close of the stream.
+func TestMultiplexerCancelledFrame(t *testing.T) {
+	assert := assert.New(t)
+	initiator, acceptor := buildMultiplexers(assert, nil, []MultiplexerOption{WithMuxBufferSize(1)})
+	defer initiator.Close()
+	defer acceptor.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := initiator.Open(ctx)
+	assert.Nil(err)
+	peerStream, err := acceptor.Accept(ctx)
+	assert.Nil(err)
+	assert.Nil(stream.TransmitPacketContext(ctx, &notppackets.Packet{Data: []byte("sent")}))
+	cancelledCtx, cancelSend := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancelSend()
+	assert.ErrorIs(stream.TransmitPacketContext(cancelledCtx, &notppackets.Packet{Data: []byte("cancelled")}), context.DeadlineExceeded)
+	assert.Nil(stream.CloseWrite())
+
+	select {
+	case <-peerStream.remoteWriteClosed.done():
+	case <-ctx.Done():
+		assert.Fail("the stream was not closed for writing")
+		return
+	}
+	packet, err := peerStream.ReceivePacketContext(ctx)
+	assert.Nil(err)
+	assert.Equal([]byte("sent"), packet.Data)
+	_, err = peerStream.ReceivePacketContext(ctx)
+	assert.ErrorIs(err, io.EOF)
+}
+
+// TestMultiplexerClose tests the half-close and the close of the multiplexed streams and of the multiplexers.
+func TestMultiplexerClose(t *testing.T) {
+	assert := assert.New(t)
+	initiator, acceptor := buildMultiplexers(assert, nil, []MultiplexerOption{WithMuxAcceptBacklog(1)})
+	ctx := context.Background()
+
+	stream, err := initiator.Open(ctx)
+	assert.Nil(err)
+	peerStream, err := acceptor.Accept(ctx)
+	assert.Nil(err)
+	assert.Nil(stream.TransmitPacket(&notppackets.Packet{Data: []byte("last")}))
+	assert.Nil(stream.CloseWrite())
+	assert.ErrorIs(stream.TransmitPacket(&notppackets.Packet{}), ErrClosed)
+	packet, err := peerStream.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal([]byte("last"), packet.Data)
+	_, err = peerStream.ReceivePacket()
+	assert.ErrorIs(err, io.EOF)
+	assert.Nil(peerStream.TransmitPacket(&notppackets.Packet{Data: []byte("reply")}))
+	packet, err = stream.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal([]byte("reply"), packet.Data)
+
+	assert.Nil(peerStream.Close())
+	_, err = stream.ReceivePacket()
+	assert.ErrorIs(err, io.EOF)
+	_, err = peerStream.ReceivePacket()
+	assert.ErrorIs(err, ErrClosed)
+
+	accepted, err := initiator.Open(ctx)
+	assert.Nil(err)
+	refused, err := initiator.Open(ctx)
+	assert.Nil(err)
+	_, err = refused.ReceivePacket()
+	assert.ErrorIs(err, io.EOF)
+	peerStream, err = acceptor.Accept(ctx)
+	assert.Nil(err)
+	assert.Equal(accepted.GetID(), peerStream.GetID())
+
+	time.AfterFunc(20*time.Millisecond, func() { _ = acceptor.Close() })
+	_, err = acceptor.Accept(ctx)
+	assert.ErrorIs(err, ErrClosed)
+	_, err = peerStream.ReceivePacket()
+	assert.ErrorIs(err, ErrClosed)
+	_, err = initiator.Accept(ctx)
+	assert.ErrorIs(err, io.EOF)
+	_, err = accepted.ReceivePacket()
+	assert.ErrorIs(err, io.EOF)
+	_, err = initiator.Open(ctx)
+	assert.ErrorIs(err, io.EOF)
+	assert.Nil(initiator.Close())
+}
+
+// TestMultiplexerInvalidFrames tests that an invalid frame ends the multiplexer.
+func TestMultiplexerInvalidFrames(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Short", data: []byte{muxDataFrame, 0}},
+		{name: "UnknownKind", data: []byte{9, 0, 0, 0, 1}},
+		{name: "OwnParity", data: []byte{muxOpenFrame, 0, 0, 0, 1}},
+		{name: "ShortWindow", data: []byte{muxWindowFrame, 0, 0, 0, 1, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			left, right, err := NewInMemoryPipe(WithPipeTimeout(time.Minute))
+			assert.Nil(err)
+			mux, err := NewStreamMultiplexer(left, true)
+			assert.Nil(err)
+			defer mux.Close()
+			assert.Nil(right.TransmitPacket(&notppackets.Packet{Data: test.data}))
+			_, err = mux.Accept(context.Background())
+			assert.ErrorContains(err, "notp: invalid multiplexed")
+		})
+	}
+}
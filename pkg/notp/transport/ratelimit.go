@@ -0,0 +1,182 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transport implements the transport layer of the NOTP protocol.
+package transport
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// RateLimit holds the rates of a rate limiter along with their bursts, where a zero rate means unlimited
+// and a zero burst means one second worth of the rate.
+type RateLimit struct {
+	// BytesPerSecond is the maximum number of bytes sent per second, as written on the wire.
+	BytesPerSecond uint64
+	// BurstBytes is the maximum number of bytes sent at once after an idle period.
+	BurstBytes uint64
+	// PacketsPerSecond is the maximum number of packets sent per second.
+	PacketsPerSecond uint64
+	// BurstPackets is the maximum number of packets sent at once after an idle period.
+	BurstPackets uint64
+}
+
+// tokenBucket represents a token bucket refilled at a rate up to its burst, whose tokens go negative once reserved in advance.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+}
+
+// newTokenBucket creates a full token bucket with the rate and the burst, where a zero burst means one second worth of the rate.
+func newTokenBucket(rate, burst uint64) tokenBucket {
+	if burst == 0 {
+		burst = rate
+	}
+	return tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst)}
+}
+
+// isUnlimited returns true if the bucket does not limit anything.
+func (b *tokenBucket) isUnlimited() bool {
+	return b.rate == 0
+}
+
+// refill adds the tokens accumulated over the elapsed time.
+func (b *tokenBucket) refill(elapsed time.Duration) {
+	if b.isUnlimited() {
+		return
+	}
+	b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+}
+
+// reserve takes the tokens and returns the time to wait before they are available.
+func (b *tokenBucket) reserve(tokens float64) time.Duration {
+	if b.isUnlimited() {
+		return 0
+	}
+	b.tokens -= tokens
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// release gives back the tokens of a cancelled reservation.
+func (b *tokenBucket) release(tokens float64) {
+	if b.isUnlimited() {
+		return
+	}
+	b.tokens = min(b.burst, b.tokens+tokens)
+}
+
+// RateLimiter shapes the traffic with token buckets for the bytes and the packets, and can be shared by several transport layers
+// to cap a common link. Its limit can be changed at any time and applies to the packets not reserved yet.
+type RateLimiter struct {
+	limit   RateLimit
+	bytes   tokenBucket
+	packets tokenBucket
+	updated time.Time
+	mutex   sync.Mutex
+}
+
+// SetLimit changes the limit of the rate limiter, keeping the tokens accumulated so far within the new bursts.
+func (r *RateLimiter) SetLimit(limit RateLimit) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.refill()
+	bytes, packets := newTokenBucket(limit.BytesPerSecond, limit.BurstBytes), newTokenBucket(limit.PacketsPerSecond, limit.BurstPackets)
+	if !r.bytes.isUnlimited() {
+		bytes.tokens = min(bytes.burst, r.bytes.tokens)
+	}
+	if !r.packets.isUnlimited() {
+		packets.tokens = min(packets.burst, r.packets.tokens)
+	}
+	r.limit, r.bytes, r.packets = limit, bytes, packets
+}
+
+// GetLimit returns the limit of the rate limiter.
+func (r *RateLimiter) GetLimit() RateLimit {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.limit
+}
+
+// refill adds the tokens accumulated since the last update.
+func (r *RateLimiter) refill() {
+	now := time.Now()
+	elapsed := now.Sub(r.updated)
+	r.updated = now
+	r.bytes.refill(elapsed)
+	r.packets.refill(elapsed)
+}
+
+// Wait waits until a packet of the size can be sent without exceeding the limit, or until the context is done.
+func (r *RateLimiter) Wait(ctx context.Context, size int) error {
+	if err := ctx.Err(); err != nil {
+		return context.Cause(ctx)
+	}
+	r.mutex.Lock()
+	r.refill()
+	delay := max(r.bytes.reserve(float64(size)), r.packets.reserve(1))
+	r.mutex.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		r.mutex.Lock()
+		r.bytes.release(float64(size))
+		r.packets.release(1)
+		r.mutex.Unlock()
+		return context.Cause(ctx)
+	}
+}
+
+// NewRateLimiter creates and initializes a new rate limiter with full buckets.
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		bytes:   newTokenBucket(limit.BytesPerSecond, limit.BurstBytes),
+		packets: newTokenBucket(limit.PacketsPerSecond, limit.BurstPackets),
+		updated: time.Now(),
+	}
+}
+
+// WithRateLimiter sets the rate limiter shaping the packets sent, once compressed, by the transport layer.
+func WithRateLimiter(limiter *RateLimiter) TransportLayerOption {
+	return func(t *TransportLayer) error {
+		t.limiter = limiter
+		return nil
+	}
+}
+
+// waitRateLimiter waits for the rate limiter of the transport layer, if any, to let the encoded packet through.
+func (t *TransportLayer) waitRateLimiter(ctx context.Context, packet *notppackets.Packet) error {
+	if t.limiter == nil {
+		return nil
+	}
+	ctx, cancel := t.writeClosed.bind(ctx)
+	defer cancel()
+	return t.limiter.Wait(ctx, len(packet.Data))
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// TestRateLimiterWait tests the time waited for the rate limiter to let the packets through.
+func TestRateLimiterWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   RateLimit
+		size    int
+		count   int
+		minimum time.Duration
+		maximum time.Duration
+	}{
+		{name: "Unlimited", size: 1 << 20, count: 100, maximum: 50 * time.Millisecond},
+		{name: "WithinBurst", limit: RateLimit{BytesPerSecond: 1000, BurstBytes: 10000}, size: 1000, count: 10, maximum: 50 * time.Millisecond},
+		{name: "Bytes", limit: RateLimit{BytesPerSecond: 100000, BurstBytes: 1000}, size: 2000, count: 6, minimum: 100 * time.Millisecond, maximum: time.Second},
+		{name: "Packets", limit: RateLimit{PacketsPerSecond: 100, BurstPackets: 1}, size: 1, count: 11, minimum: 90 * time.Millisecond, maximum: time.Second},
+		{name: "SlowestWins", limit: RateLimit{BytesPerSecond: 1 << 30, PacketsPerSecond: 100, BurstPackets: 1}, size: 1000, count: 11, minimum: 90 * time.Millisecond, maximum: time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			limiter := NewRateLimiter(test.limit)
+			start := time.Now()
+			for range test.count {
+				assert.Nil(limiter.Wait(context.Background(), test.size))
+			}
+			elapsed := time.Since(start)
+			assert.GreaterOrEqual(elapsed, test.minimum)
+			assert.Less(elapsed, test.maximum)
+		})
+	}
+}
+
+// TestRateLimiterSetLimit tests the change of the limit of a rate limiter while waiting.
+func TestRateLimiterSetLimit(t *testing.T) {
+	assert := assert.New(t)
+	limit := RateLimit{PacketsPerSecond: 1, BurstPackets: 1}
+	limiter := NewRateLimiter(limit)
+	assert.Equal(limit, limiter.GetLimit())
+	assert.Nil(limiter.Wait(context.Background(), 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(limiter.Wait(ctx, 1), context.DeadlineExceeded)
+
+	limiter.SetLimit(RateLimit{PacketsPerSecond: 1000})
+	assert.Equal(RateLimit{PacketsPerSecond: 1000}, limiter.GetLimit())
+	start := time.Now()
+	for range 10 {
+		assert.Nil(limiter.Wait(context.Background(), 1))
+	}
+	assert.Less(time.Since(start), 500*time.Millisecond)
+
+	limiter.SetLimit(RateLimit{})
+	for range 1000 {
+		assert.Nil(limiter.Wait(context.Background(), 1<<20))
+	}
+}
+
+// TestTransportLayerRateLimiter tests that the rate limiter of a transport layer counts the compressed bytes and wakes up on close.
+func TestTransportLayerRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+	left, right, err := NewInMemoryPipe(WithPipeTimeout(time.Minute), WithPipeBufferSize(100))
+	assert.Nil(err)
+	limiter := NewRateLimiter(RateLimit{BytesPerSecond: 10000, BurstBytes: 10000})
+	sender, err := NewStreamTransportLayer(left, nil, WithRateLimiter(limiter))
+	assert.Nil(err)
+	receiver, err := NewStreamTransportLayer(right, nil)
+	assert.Nil(err)
+
+	assert.Nil(sender.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("hello")}}))
+	_, err = receiver.ReceivePacket()
+	assert.Nil(err)
+	start := time.Now()
+	for range 5 {
+		assert.Nil(sender.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: make([]byte, 100000)}}))
+	}
+	assert.Less(time.Since(start), time.Second)
+	for range 5 {
+		packetables, err := receiver.ReceivePacket()
+		assert.Nil(err)
+		assert.Equal([]notppackets.Packetable{&notppackets.Packet{Data: make([]byte, 100000)}}, packetables)
+	}
+
+	limiter.SetLimit(RateLimit{PacketsPerSecond: 1, BurstPackets: 1})
+	assert.Nil(sender.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("last")}}))
+	time.AfterFunc(20*time.Millisecond, func() { _ = sender.CloseWrite() })
+	assert.ErrorIs(sender.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("late")}}), ErrClosed)
+}
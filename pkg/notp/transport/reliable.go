@@ -0,0 +1,623 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transport implements the transport layer of the NOTP protocol.
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+	"time"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+const (
+	// DefaultReliableWindow represents the default number of packets sent and not acknowledged yet.
+	DefaultReliableWindow = 64
+	// DefaultRetransmissionTimeout represents the default time waited for an acknowledgement before the first retransmission.
+	DefaultRetransmissionTimeout = 200 * time.Millisecond
+	// DefaultMaxRetransmissionTimeout represents the default maximum time waited for an acknowledgement after the backoff.
+	DefaultMaxRetransmissionTimeout = 5 * time.Second
+	// DefaultMaxRetransmissions represents the default number of retransmissions of a packet before giving up.
+	DefaultMaxRetransmissions = 10
+
+	// reliableHeaderSize represents the size in bytes of the header of a reliable frame.
+	reliableHeaderSize = 9
+	// reliableChecksumSize represents the size in bytes of the checksum of a reliable frame.
+	reliableChecksumSize = 4
+	// reliableWindowSize represents the size in bytes of the receive window carried by an acknowledgement.
+	reliableWindowSize = 4
+
+	// reliableDataFrame carries a packet.
+	reliableDataFrame = byte(1)
+	// reliableAckFrame acknowledges all the frames up to its sequence number and advertises the receive window.
+	reliableAckFrame = byte(2)
+	// reliableFinFrame ends the sequence of the packets.
+	reliableFinFrame = byte(3)
+)
+
+// ErrDeliveryFailed is returned once a packet has not been acknowledged after the maximum retransmissions.
+var ErrDeliveryFailed = errors.New("notp: delivery failed after the maximum retransmissions")
+
+// reliableChecksumTable is the CRC32C table used to detect the corrupted frames.
+var reliableChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// reliableFrame represents a frame of the reliable layer.
+type reliableFrame struct {
+	kind byte
+	seq  uint64
+	data []byte
+}
+
+// encode encodes the frame into a packet.
+func (f *reliableFrame) encode() *notppackets.Packet {
+	data := make([]byte, reliableHeaderSize, reliableHeaderSize+len(f.data)+reliableChecksumSize)
+	data[0] = f.kind
+	binary.BigEndian.PutUint64(data[1:], f.seq)
+	data = append(data, f.data...)
+	data = binary.BigEndian.AppendUint32(data, crc32.Checksum(data, reliableChecksumTable))
+	return &notppackets.Packet{Data: data}
+}
+
+// decodeReliableFrame decodes a frame from a packet, rejecting the corrupted ones.
+func decodeReliableFrame(packet *notppackets.Packet) (*reliableFrame, error) {
+	if packet == nil || len(packet.Data) < reliableHeaderSize+reliableChecksumSize {
+		return nil, errors.New("notp: invalid reliable frame")
+	}
+	body := packet.Data[:len(packet.Data)-reliableChecksumSize]
+	if crc32.Checksum(body, reliableChecksumTable) != binary.BigEndian.Uint32(packet.Data[len(body):]) {
+		return nil, errors.New("notp: corrupted reliable frame")
+	}
+	frame := &reliableFrame{
+		kind: body[0],
+		seq:  binary.BigEndian.Uint64(body[1:]),
+		data: body[reliableHeaderSize:],
+	}
+	if frame.kind < reliableDataFrame || frame.kind > reliableFinFrame {
+		return nil, fmt.Errorf("notp: invalid reliable frame kind %d", frame.kind)
+	}
+	if frame.kind == reliableAckFrame && len(frame.data) != reliableWindowSize {
+		return nil, errors.New("notp: invalid reliable acknowledgement")
+	}
+	return frame, nil
+}
+
+// reliableEntry represents a frame sent and not acknowledged yet.
+type reliableEntry struct {
+	frame    *reliableFrame
+	deadline time.Time
+	attempts int
+}
+
+// ReliableStats holds the counters of a reliable layer.
+type ReliableStats struct {
+	Retransmitted uint64
+	Duplicates    uint64
+	Reordered     uint64
+	Corrupted     uint64
+}
+
+// ReliableOption defines a function to configure the reliable layer.
+type ReliableOption func(*ReliableLayer) error
+
+// WithReliableWindow sets the number of packets sent and not acknowledged yet, beyond which the senders wait,
+// and the number of received packets held for the reader, which is advertised to the peer in the acknowledgements.
+func WithReliableWindow(window int) ReliableOption {
+	return func(r *ReliableLayer) error {
+		if window <= 0 {
+			return fmt.Errorf("notp: invalid reliable window %d", window)
+		}
+		r.window = window
+		return nil
+	}
+}
+
+// WithRetransmission sets the time waited for an acknowledgement, doubled at each retransmission up to the maximum,
+// and the number of retransmissions of a packet before giving up.
+func WithRetransmission(timeout, maxTimeout time.Duration, maxRetransmissions int) ReliableOption {
+	return func(r *ReliableLayer) error {
+		if timeout <= 0 || maxTimeout < timeout {
+			return fmt.Errorf("notp: invalid retransmission timeouts %s-%s", timeout, maxTimeout)
+		}
+		if maxRetransmissions < 0 {
+			return fmt.Errorf("notp: invalid maximum retransmissions %d", maxRetransmissions)
+		}
+		r.timeout = timeout
+		r.maxTimeout = maxTimeout
+		r.maxRetransmissions = maxRetransmissions
+		return nil
+	}
+}
+
+// WithReliableCloser sets the function closing the underlying connection when the reliable layer is closed.
+func WithReliableCloser(closeFunc StreamCloseFunc) ReliableOption {
+	return func(r *ReliableLayer) error {
+		r.closeFunc = closeFunc
+		return nil
+	}
+}
+
+// ReliableLayer delivers the packets over a lossy connection: it numbers the outgoing packets, acknowledges their receipt,
+// retransmits them on timeout with backoff, discards the duplicates and the corrupted frames and restores their order.
+// The acknowledgements advertise the room left for the reader, so that a slow reader holds back the peer instead of
+// failing its delivery, while a single packet probes a full window until the reader catches up or the peer stops
+// acknowledging the probes.
+type ReliableLayer struct {
+	sender             ContextPacketSender
+	receiver           ContextPacketReceiver
+	closeFunc          StreamCloseFunc
+	window             int
+	timeout            time.Duration
+	maxTimeout         time.Duration
+	maxRetransmissions int
+	nextSeq            uint64
+	unacked            []*reliableEntry
+	acked              chan struct{}
+	peerLimit          uint64
+	probes             int
+	expected           uint64
+	pending            map[uint64]*reliableFrame
+	delivered          []*notppackets.Packet
+	delivery           chan struct{}
+	advertised         int
+	ackWake            chan struct{}
+	wake               chan struct{}
+	stats              ReliableStats
+	writeClosed        *closeSignal
+	closed             *closeSignal
+	remoteWriteClosed  *closeSignal
+	ended              *closeSignal
+	endErr             error
+	mutex              sync.Mutex
+	sendMutex          sync.Mutex
+}
+
+// TransmitPacket sends a packet reliably.
+func (r *ReliableLayer) TransmitPacket(packet *notppackets.Packet) error {
+	return r.TransmitPacketContext(context.Background(), packet)
+}
+
+// TransmitPacketContext sends a packet reliably, waiting for room in the window until the context is done.
+func (r *ReliableLayer) TransmitPacketContext(ctx context.Context, packet *notppackets.Packet) error {
+	if packet == nil {
+		return errors.New("notp: cannot transmit a nil packet")
+	}
+	if r.writeClosed.isClosed() {
+		return ErrClosed
+	}
+	return r.enqueue(ctx, reliableDataFrame, packet.Data)
+}
+
+// ReceivePacket retrieves the next packet in order.
+func (r *ReliableLayer) ReceivePacket() (*notppackets.Packet, error) {
+	return r.ReceivePacketContext(context.Background())
+}
+
+// ReceivePacketContext retrieves the next packet in order, giving up once the context is done.
+func (r *ReliableLayer) ReceivePacketContext(ctx context.Context) (*notppackets.Packet, error) {
+	if r.closed.isClosed() {
+		return nil, ErrClosed
+	}
+	for {
+		packet, delivery := r.pop()
+		if packet != nil {
+			return packet, nil
+		}
+		select {
+		case <-delivery:
+		case <-r.closed.done():
+			return nil, ErrClosed
+		case <-r.remoteWriteClosed.done():
+			return r.drain(io.EOF)
+		case <-r.ended.done():
+			return r.drain(r.getEndError())
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+}
+
+// pop returns the next delivered packet if any, or the channel closed on the next delivery otherwise.
+// Once the reader has made room for half of the window, the acknowledgement advertising it is sent.
+func (r *ReliableLayer) pop() (*notppackets.Packet, chan struct{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.delivered) == 0 {
+		return nil, r.delivery
+	}
+	packet := r.delivered[0]
+	r.delivered = r.delivered[1:]
+	if r.getFreeWindow() >= r.advertised+max(1, r.window/2) {
+		select {
+		case r.ackWake <- struct{}{}:
+		default:
+		}
+	}
+	return packet, nil
+}
+
+// drain returns the next delivered packet if any, or the error otherwise.
+func (r *ReliableLayer) drain(err error) (*notppackets.Packet, error) {
+	if packet, _ := r.pop(); packet != nil {
+		return packet, nil
+	}
+	return nil, err
+}
+
+// getFreeWindow returns the number of packets the reader has room for.
+func (r *ReliableLayer) getFreeWindow() int {
+	return max(0, r.window-len(r.delivered))
+}
+
+// Flush waits until all the packets sent have been acknowledged.
+func (r *ReliableLayer) Flush(ctx context.Context) error {
+	for {
+		r.mutex.Lock()
+		if r.ended.isClosed() {
+			r.mutex.Unlock()
+			return r.getEndError()
+		}
+		if len(r.unacked) == 0 {
+			r.mutex.Unlock()
+			return nil
+		}
+		acked := r.acked
+		r.mutex.Unlock()
+		select {
+		case <-acked:
+		case <-r.ended.done():
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// CloseWrite ends the sequence of the packets, so that the peer receives io.EOF once it has received all of them.
+func (r *ReliableLayer) CloseWrite() error {
+	if !r.writeClosed.close() {
+		return nil
+	}
+	return r.enqueue(context.Background(), reliableFinFrame, nil)
+}
+
+// Close closes the reliable layer and the underlying connection, giving up on the packets not acknowledged yet.
+func (r *ReliableLayer) Close() error {
+	if !r.closed.close() {
+		return nil
+	}
+	r.writeClosed.close()
+	r.end(ErrClosed)
+	if r.closeFunc != nil {
+		return r.closeFunc()
+	}
+	return nil
+}
+
+// GetStats returns the counters of the reliable layer.
+func (r *ReliableLayer) GetStats() ReliableStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stats
+}
+
+// enqueue numbers and sends the frame once there is room in the window, keeping it for retransmission until acknowledged.
+func (r *ReliableLayer) enqueue(ctx context.Context, kind byte, data []byte) error {
+	for {
+		r.mutex.Lock()
+		if r.ended.isClosed() {
+			r.mutex.Unlock()
+			return r.getEndError()
+		}
+		if len(r.unacked) == 0 || (len(r.unacked) < r.window && r.nextSeq <= r.peerLimit) {
+			break
+		}
+		acked := r.acked
+		r.mutex.Unlock()
+		select {
+		case <-acked:
+		case <-r.ended.done():
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+	frame := &reliableFrame{kind: kind, seq: r.nextSeq, data: data}
+	r.nextSeq++
+	r.unacked = append(r.unacked, &reliableEntry{frame: frame, deadline: time.Now().Add(r.timeout)})
+	r.mutex.Unlock()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return r.send(frame)
+}
+
+// send sends the frame through the underlying connection, ending the reliable layer on failure.
+func (r *ReliableLayer) send(frame *reliableFrame) error {
+	r.sendMutex.Lock()
+	defer r.sendMutex.Unlock()
+	if err := r.sender(r.ended.ctx, frame.encode()); err != nil {
+		r.end(err)
+		return err
+	}
+	return nil
+}
+
+// getBackoff returns the time waited for an acknowledgement after the attempts.
+func (r *ReliableLayer) getBackoff(attempts int) time.Duration {
+	timeout := r.timeout
+	for range attempts {
+		timeout *= 2
+		if timeout >= r.maxTimeout {
+			return r.maxTimeout
+		}
+	}
+	return timeout
+}
+
+// retransmitLoop retransmits the frames not acknowledged in time until the reliable layer ends.
+func (r *ReliableLayer) retransmitLoop() {
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+	for {
+		r.mutex.Lock()
+		var next time.Time
+		for _, entry := range r.unacked {
+			if next.IsZero() || entry.deadline.Before(next) {
+				next = entry.deadline
+			}
+		}
+		r.mutex.Unlock()
+		var timerCh <-chan time.Time
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+			timerCh = timer.C
+		}
+		select {
+		case <-timerCh:
+		case <-r.wake:
+			timer.Stop()
+			continue
+		case <-r.ended.done():
+			return
+		}
+
+		now := time.Now()
+		r.mutex.Lock()
+		var frames []*reliableFrame
+		failed := false
+		for _, entry := range r.unacked {
+			if entry.deadline.After(now) {
+				continue
+			}
+			if entry.frame.seq > r.peerLimit {
+				if r.probes >= r.maxRetransmissions {
+					failed = true
+					break
+				}
+				r.probes++
+			} else if entry.attempts >= r.maxRetransmissions {
+				failed = true
+				break
+			}
+			entry.attempts++
+			entry.deadline = now.Add(r.getBackoff(entry.attempts))
+			r.stats.Retransmitted++
+			frames = append(frames, entry.frame)
+		}
+		r.mutex.Unlock()
+		if failed {
+			r.end(ErrDeliveryFailed)
+			return
+		}
+		for _, frame := range frames {
+			if err := r.send(frame); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readLoop processes the received frames until the reliable layer ends.
+func (r *ReliableLayer) readLoop() {
+	for {
+		packet, err := r.receiver(r.ended.ctx)
+		if err != nil {
+			r.end(err)
+			return
+		}
+		frame, err := decodeReliableFrame(packet)
+		if err != nil {
+			r.mutex.Lock()
+			r.stats.Corrupted++
+			r.mutex.Unlock()
+			continue
+		}
+		if frame.kind == reliableAckFrame {
+			r.acknowledge(frame.seq, binary.BigEndian.Uint32(frame.data))
+			continue
+		}
+		if r.accept(frame) {
+			r.remoteWriteClosed.close()
+		}
+		select {
+		case r.ackWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ackLoop sends the latest acknowledgement until the reliable layer ends, so that the read loop never waits for the connection.
+func (r *ReliableLayer) ackLoop() {
+	for {
+		select {
+		case <-r.ackWake:
+		case <-r.ended.done():
+			return
+		}
+		r.mutex.Lock()
+		ack := r.expected - 1
+		r.advertised = r.getFreeWindow()
+		data := binary.BigEndian.AppendUint32(nil, uint32(r.advertised))
+		r.mutex.Unlock()
+		if err := r.send(&reliableFrame{kind: reliableAckFrame, seq: ack, data: data}); err != nil {
+			return
+		}
+	}
+}
+
+// acknowledge removes the frames acknowledged by the peer and extends the sequence numbers it has room for,
+// retransmitting at once the frames probing the window it opens, while any acknowledgement answers the probes.
+func (r *ReliableLayer) acknowledge(seq uint64, window uint32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.probes = 0
+	removed := 0
+	for removed < len(r.unacked) && r.unacked[removed].frame.seq <= seq {
+		removed++
+	}
+	limit := seq + uint64(window)
+	if removed == 0 && limit <= r.peerLimit {
+		return
+	}
+	r.unacked = r.unacked[removed:]
+	if limit > r.peerLimit {
+		now := time.Now()
+		for _, entry := range r.unacked {
+			if entry.frame.seq > r.peerLimit && entry.frame.seq <= limit {
+				entry.deadline = now
+				entry.attempts = 0
+			}
+		}
+		r.peerLimit = limit
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+	close(r.acked)
+	r.acked = make(chan struct{})
+}
+
+// accept stores the received frame within the room left for the reader and queues the frames which can be delivered
+// in order, returning true once the peer has ended the sequence of the packets.
+func (r *ReliableLayer) accept(frame *reliableFrame) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	switch {
+	case frame.seq < r.expected:
+		r.stats.Duplicates++
+	case frame.seq >= r.expected+uint64(r.getFreeWindow()):
+	case frame.seq > r.expected:
+		if _, exists := r.pending[frame.seq]; exists {
+			r.stats.Duplicates++
+		} else {
+			r.stats.Reordered++
+			r.pending[frame.seq] = frame
+		}
+	default:
+		ended := false
+		for exists := true; exists; frame, exists = r.pending[r.expected] {
+			delete(r.pending, r.expected)
+			r.expected++
+			if frame.kind == reliableFinFrame {
+				ended = true
+				continue
+			}
+			r.delivered = append(r.delivered, &notppackets.Packet{Data: frame.data})
+		}
+		close(r.delivery)
+		r.delivery = make(chan struct{})
+		return ended
+	}
+	return false
+}
+
+// end ends the reliable layer with the error, waking up the pending calls.
+func (r *ReliableLayer) end(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.ended.isClosed() {
+		return
+	}
+	r.endErr = err
+	r.ended.close()
+}
+
+// getEndError returns the error the reliable layer ended with, where the end of the underlying connection is io.EOF.
+func (r *ReliableLayer) getEndError() error {
+	if r.closed.isClosed() {
+		return ErrClosed
+	}
+	if errors.Is(r.endErr, io.EOF) {
+		return io.EOF
+	}
+	return r.endErr
+}
+
+// NewReliableLayer creates and initializes a new reliable layer over the connection.
+func NewReliableLayer(sender ContextPacketSender, receiver ContextPacketReceiver, opts ...ReliableOption) (*ReliableLayer, error) {
+	if sender == nil {
+		return nil, errors.New("notp: ContextPacketSender cannot be nil")
+	}
+	if receiver == nil {
+		return nil, errors.New("notp: ContextPacketReceiver cannot be nil")
+	}
+	reliable := &ReliableLayer{
+		sender:             sender,
+		receiver:           receiver,
+		window:             DefaultReliableWindow,
+		timeout:            DefaultRetransmissionTimeout,
+		maxTimeout:         DefaultMaxRetransmissionTimeout,
+		maxRetransmissions: DefaultMaxRetransmissions,
+		nextSeq:            1,
+		acked:              make(chan struct{}),
+		expected:           1,
+		delivery:           make(chan struct{}),
+		pending:            map[uint64]*reliableFrame{},
+		wake:               make(chan struct{}, 1),
+		ackWake:            make(chan struct{}, 1),
+		writeClosed:        newCloseSignal(),
+		closed:             newCloseSignal(),
+		remoteWriteClosed:  newCloseSignal(),
+		ended:              newCloseSignal(),
+	}
+	for _, opt := range opts {
+		if err := opt(reliable); err != nil {
+			return nil, err
+		}
+	}
+	reliable.peerLimit = uint64(reliable.window)
+	reliable.advertised = reliable.window
+	go reliable.readLoop()
+	go reliable.retransmitLoop()
+	go reliable.ackLoop()
+	return reliable, nil
+}
+
+// NewStreamReliableLayer creates and initializes a new reliable layer over the stream, which is closed along with the reliable layer.
+func NewStreamReliableLayer(stream Stream, opts ...ReliableOption) (*ReliableLayer, error) {
+	if stream == nil {
+		return nil, errors.New("notp: stream cannot be nil")
+	}
+	opts = append([]ReliableOption{WithReliableCloser(stream.Close)}, opts...)
+	return NewReliableLayer(stream.TransmitPacketContext, stream.ReceivePacketContext, opts...)
+}
@@ -0,0 +1,270 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// buildReliableLayers initializes and returns two reliable layers connected through an in-memory pipe, injecting the faults into both directions.
+func buildReliableLayers(assert *assert.Assertions, seed uint64, rules []FaultRule, opts ...ReliableOption) (*ReliableLayer, *ReliableLayer) {
+	left, right, err := NewInMemoryPipe(WithPipeTimeout(time.Minute))
+	assert.Nil(err)
+	build := func(endpoint *InMemoryPipeEndpoint, seed uint64) *ReliableLayer {
+		injector, err := NewFaultInjector(seed, rules...)
+		assert.Nil(err)
+		opts := append([]ReliableOption{WithReliableCloser(endpoint.Close)}, opts...)
+		reliable, err := NewReliableLayer(injector.WrapSender(endpoint.TransmitPacketContext), endpoint.ReceivePacketContext, opts...)
+		assert.Nil(err)
+		return reliable
+	}
+	return build(left, seed), build(right, seed+1)
+}
+
+// TestReliableLayerDelivery tests that the packets are delivered in order exactly once over a faulty connection.
+func TestReliableLayerDelivery(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []FaultRule
+	}{
+		{name: "NoFaults"},
+		{name: "Drop", rules: []FaultRule{{Fault: DropFault, Probability: 0.2}}},
+		{name: "Duplicate", rules: []FaultRule{{Fault: DuplicateFault, Probability: 0.2}}},
+		{name: "Reorder", rules: []FaultRule{{Fault: ReorderFault, Probability: 0.2}}},
+		{name: "Corrupt", rules: []FaultRule{{Fault: CorruptFault, Probability: 0.2}}},
+		{name: "All", rules: []FaultRule{
+			{Fault: DropFault, Probability: 0.1},
+			{Fault: DuplicateFault, Probability: 0.1},
+			{Fault: ReorderFault, Probability: 0.1},
+			{Fault: CorruptFault, Probability: 0.1},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			left, right := buildReliableLayers(assert, 42, test.rules, WithReliableWindow(16), WithRetransmission(10*time.Millisecond, 100*time.Millisecond, 20))
+			defer left.Close()
+			defer right.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			const count = 200
+			var wg sync.WaitGroup
+			for _, pair := range [][2]*ReliableLayer{{left, right}, {right, left}} {
+				sender, receiver := pair[0], pair[1]
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					for i := range count {
+						assert.Nil(sender.TransmitPacketContext(ctx, &notppackets.Packet{Data: fmt.Appendf(nil, "packet %d", i)}))
+					}
+					assert.Nil(sender.CloseWrite())
+					assert.Nil(sender.Flush(ctx))
+				}()
+				go func() {
+					defer wg.Done()
+					for i := range count {
+						packet, err := receiver.ReceivePacketContext(ctx)
+						if !assert.Nil(err) {
+							return
+						}
+						assert.Equal(fmt.Appendf(nil, "packet %d", i), packet.Data)
+					}
+					_, err := receiver.ReceivePacketContext(ctx)
+					assert.ErrorIs(err, io.EOF)
+				}()
+			}
+			wg.Wait()
+			leftStats, rightStats := left.GetStats(), right.GetStats()
+			if len(test.rules) == 0 {
+				assert.Zero(leftStats.Reordered + leftStats.Corrupted)
+				assert.Zero(rightStats.Reordered + rightStats.Corrupted)
+			} else {
+				assert.NotEqual(ReliableStats{}, leftStats)
+				assert.NotEqual(ReliableStats{}, rightStats)
+			}
+		})
+	}
+}
+
+// TestReliableLayerDeliveryFailed tests that the reliable layer gives up once a packet is never acknowledged.
+func TestReliableLayerDeliveryFailed(t *testing.T) {
+	assert := assert.New(t)
+	left, right := buildReliableLayers(assert, 1, []FaultRule{{Fault: DropFault, Probability: 1}}, WithRetransmission(time.Millisecond, 4*time.Millisecond, 3))
+	defer right.Close()
+
+	assert.Nil(left.TransmitPacket(&notppackets.Packet{Data: []byte("lost")}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.ErrorIs(left.Flush(ctx), ErrDeliveryFailed)
+	assert.ErrorIs(left.TransmitPacket(&notppackets.Packet{}), ErrDeliveryFailed)
+	_, err := left.ReceivePacket()
+	assert.ErrorIs(err, ErrDeliveryFailed)
+	assert.Equal(uint64(3), left.GetStats().Retransmitted)
+
+	assert.Nil(left.Close())
+	_, err = left.ReceivePacket()
+	assert.ErrorIs(err, ErrClosed)
+}
+
+// TestReliableLayerSlowReader tests that a reader falling behind holds back the peer without failing its delivery.
+func TestReliableLayerSlowReader(t *testing.T) {
+	assert := assert.New(t)
+	leftEndpoint, rightEndpoint, err := NewInMemoryPipe(WithPipeTimeout(time.Minute), WithPipeBufferSize(10000))
+	assert.Nil(err)
+	opts := []ReliableOption{WithReliableWindow(4), WithRetransmission(time.Millisecond, 4*time.Millisecond, 3)}
+	left, err := NewStreamReliableLayer(leftEndpoint, opts...)
+	assert.Nil(err)
+	defer left.Close()
+	right, err := NewStreamReliableLayer(rightEndpoint, opts...)
+	assert.Nil(err)
+	defer right.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	const count = 50
+	done := make(chan error, 1)
+	go func() {
+		for i := range count {
+			if err := left.TransmitPacketContext(ctx, &notppackets.Packet{Data: []byte{byte(i)}}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- left.Flush(ctx)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	for i := range count {
+		packet, err := right.ReceivePacketContext(ctx)
+		if !assert.Nil(err) {
+			return
+		}
+		assert.Equal([]byte{byte(i)}, packet.Data)
+		if i%10 == 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	assert.Nil(<-done)
+}
+
+// TestReliableLayerSilentPeer tests that the reliable layer gives up once a peer advertising a full window stops acknowledging the probes.
+func TestReliableLayerSilentPeer(t *testing.T) {
+	assert := assert.New(t)
+	leftEndpoint, rightEndpoint, err := NewInMemoryPipe(WithPipeTimeout(time.Minute), WithPipeBufferSize(100))
+	assert.Nil(err)
+	defer rightEndpoint.Close()
+	left, err := NewStreamReliableLayer(leftEndpoint, WithReliableWindow(1), WithRetransmission(time.Millisecond, 4*time.Millisecond, 3))
+	assert.Nil(err)
+	defer left.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.Nil(left.TransmitPacket(&notppackets.Packet{Data: []byte("first")}))
+	packet, err := rightEndpoint.ReceivePacket()
+	assert.Nil(err)
+	frame, err := decodeReliableFrame(packet)
+	assert.Nil(err)
+	ack := &reliableFrame{kind: reliableAckFrame, seq: frame.seq, data: make([]byte, reliableWindowSize)}
+	assert.Nil(rightEndpoint.TransmitPacket(ack.encode()))
+	assert.Nil(left.Flush(ctx))
+	retransmitted := left.GetStats().Retransmitted
+	assert.Nil(left.TransmitPacketContext(ctx, &notppackets.Packet{Data: []byte("probe")}))
+	assert.ErrorIs(left.Flush(ctx), ErrDeliveryFailed)
+	assert.Equal(retransmitted+3, left.GetStats().Retransmitted)
+}
+
+// TestReliableLayerClose tests the close of the reliable layers.
+func TestReliableLayerClose(t *testing.T) {
+	assert := assert.New(t)
+	left, right := buildReliableLayers(assert, 1, nil)
+
+	time.AfterFunc(20*time.Millisecond, func() { _ = right.Close() })
+	_, err := right.ReceivePacket()
+	assert.ErrorIs(err, ErrClosed)
+	assert.ErrorIs(right.TransmitPacket(&notppackets.Packet{}), ErrClosed)
+	_, err = left.ReceivePacket()
+	assert.ErrorIs(err, io.EOF)
+	assert.Nil(left.Close())
+	assert.Nil(left.Close())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	left, right = buildReliableLayers(assert, 1, nil)
+	defer left.Close()
+	defer right.Close()
+	_, err = left.ReceivePacketContext(ctx)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+}
+
+// TestReliableLayerInvalidFrames tests that the invalid and the corrupted frames are discarded.
+func TestReliableLayerInvalidFrames(t *testing.T) {
+	assert := assert.New(t)
+	left, right, err := NewInMemoryPipe(WithPipeTimeout(time.Minute))
+	assert.Nil(err)
+	reliable, err := NewStreamReliableLayer(left)
+	assert.Nil(err)
+	defer reliable.Close()
+
+	valid := (&reliableFrame{kind: reliableDataFrame, seq: 1, data: []byte("valid")}).encode()
+	corrupted := (&reliableFrame{kind: reliableDataFrame, seq: 1, data: []byte("corrupted")}).encode()
+	corrupted.Data[reliableHeaderSize] ^= 0xFF
+	unknown := (&reliableFrame{kind: 9, seq: 1}).encode()
+	for _, packet := range []*notppackets.Packet{{Data: []byte{reliableDataFrame}}, corrupted, unknown, valid, valid} {
+		assert.Nil(right.TransmitPacket(packet))
+	}
+	packet, err := reliable.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal([]byte("valid"), packet.Data)
+	packet, err = right.ReceivePacket()
+	assert.Nil(err)
+	frame, err := decodeReliableFrame(packet)
+	assert.Nil(err)
+	assert.Equal(reliableAckFrame, frame.kind)
+	assert.Equal(uint64(1), frame.seq)
+	assert.Len(frame.data, reliableWindowSize)
+	assert.Eventually(func() bool { return reliable.GetStats().Duplicates == 1 }, time.Second, time.Millisecond)
+	assert.Equal(ReliableStats{Duplicates: 1, Corrupted: 3}, reliable.GetStats())
+}
+
+// TestReliableLayerInvalidOptions tests the validation of the reliable layer options.
+func TestReliableLayerInvalidOptions(t *testing.T) {
+	assert := assert.New(t)
+	left, _, err := NewInMemoryPipe()
+	assert.Nil(err)
+	for _, opt := range []ReliableOption{
+		WithReliableWindow(0),
+		WithRetransmission(0, time.Second, 1),
+		WithRetransmission(time.Second, time.Millisecond, 1),
+		WithRetransmission(time.Second, time.Second, -1),
+	} {
+		_, err := NewStreamReliableLayer(left, opt)
+		assert.NotNil(err)
+	}
+	_, err = NewReliableLayer(nil, left.ReceivePacketContext)
+	assert.NotNil(err)
+	_, err = NewStreamReliableLayer(nil)
+	assert.NotNil(err)
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transport implements the transport layer of the NOTP protocol.
+package transport
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+var (
+	// ErrClosed is returned by the operations on a closed stream or transport layer, while the peer observes io.EOF.
+	ErrClosed = errors.New("notp: stream closed")
+	// ErrTimeout is wrapped by the errors of the streams giving up on sending or receiving a packet after their timeout.
+	ErrTimeout = errors.New("notp: timeout")
+)
+
+// Stream represents a bidirectional stream of packets which can be closed as a whole or for writing only.
+type Stream interface {
+	// TransmitPacketContext transmits the packet, giving up once the context is done.
+	TransmitPacketContext(ctx context.Context, packet *notppackets.Packet) error
+	// ReceivePacketContext receives the next packet, giving up once the context is done.
+	ReceivePacketContext(ctx context.Context) (*notppackets.Packet, error)
+	// Close closes the stream, waking up the pending calls.
+	Close() error
+	// CloseWrite closes the stream for writing, so that the peer receives io.EOF once the transmitted packets are drained.
+	CloseWrite() error
+}
+
+// closeSignal signals the closing of a stream.
+type closeSignal struct {
+	closed atomic.Bool
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+// close closes the signal, returning true on the first call only.
+func (s *closeSignal) close() bool {
+	if !s.closed.CompareAndSwap(false, true) {
+		return false
+	}
+	s.cancel(ErrClosed)
+	return true
+}
+
+// isClosed returns true if the signal has been closed.
+func (s *closeSignal) isClosed() bool {
+	return s.closed.Load()
+}
+
+// done returns a channel closed once the signal is closed.
+func (s *closeSignal) done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// bind returns a context derived from the given one which is cancelled with ErrClosed once the signal is closed.
+func (s *closeSignal) bind(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(s.ctx, func() { cancel(ErrClosed) })
+	return ctx, func() {
+		stop()
+		cancel(context.Canceled)
+	}
+}
+
+// newCloseSignal creates and initializes a new close signal.
+func newCloseSignal() *closeSignal {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	return &closeSignal{ctx: ctx, cancel: cancel}
+}
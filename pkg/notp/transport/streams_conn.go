@@ -0,0 +1,283 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transport implements the transport layer of the NOTP protocol.
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+const (
+	// DefaultMaxFrameSize represents the default maximum size in bytes of a frame of a connection stream.
+	DefaultMaxFrameSize = uint32(64 << 20)
+	// frameHeaderSize represents the size in bytes of the length prefix of a frame.
+	frameHeaderSize = 4
+)
+
+// readDeadliner is implemented by the connections supporting read deadlines.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// writeDeadliner is implemented by the connections supporting write deadlines.
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// ConnStream is a stream transmitting the packets over a connection as frames prefixed by their length.
+type ConnStream struct {
+	conn         io.ReadWriteCloser
+	reader       *bufio.Reader
+	timeout      time.Duration
+	maxFrameSize uint32
+	readErr      error
+	writeErr     error
+	closed       *closeSignal
+	readMutex    sync.Mutex
+	writeMutex   sync.Mutex
+}
+
+// TransmitPacket writes the packet to the connection as a frame.
+func (t *ConnStream) TransmitPacket(packet *notppackets.Packet) error {
+	return t.TransmitPacketContext(context.Background(), packet)
+}
+
+// TransmitPacketContext writes the packet to the connection as a frame, giving up once the context is done if the connection supports write deadlines.
+func (t *ConnStream) TransmitPacketContext(ctx context.Context, packet *notppackets.Packet) error {
+	if packet == nil {
+		return errors.New("notp: cannot transmit a nil packet")
+	}
+	if uint64(len(packet.Data)) > uint64(t.maxFrameSize) {
+		return fmt.Errorf("%w: frame of %d bytes exceeds the maximum of %d bytes", ErrLimitExceeded, len(packet.Data), t.maxFrameSize)
+	}
+	frame := make([]byte, 0, frameHeaderSize+len(packet.Data))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(packet.Data)))
+	frame = append(frame, packet.Data...)
+
+	if t.closed.isClosed() {
+		return ErrClosed
+	}
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+	if t.writeErr != nil {
+		return t.writeErr
+	}
+	if err := ctx.Err(); err != nil {
+		return context.Cause(ctx)
+	}
+	if conn, ok := t.conn.(writeDeadliner); ok {
+		stop, err := watchContext(ctx, conn.SetWriteDeadline, t.getDeadline(ctx))
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+	n, err := t.conn.Write(frame)
+	if err != nil {
+		if t.closed.isClosed() {
+			return ErrClosed
+		}
+		if ctxErr := getContextError(ctx); ctxErr != nil {
+			err = ctxErr
+		} else if isTimeout(err) {
+			err = fmt.Errorf("%w sending packet: %w", ErrTimeout, err)
+		}
+		if n > 0 {
+			t.writeErr = fmt.Errorf("notp: broken connection stream: %w", err)
+			return t.writeErr
+		}
+		return err
+	}
+	return nil
+}
+
+// ReceivePacket reads the next frame from the connection, waiting up to the timeout if the connection supports read deadlines.
+func (t *ConnStream) ReceivePacket() (*notppackets.Packet, error) {
+	return t.ReceivePacketContext(context.Background())
+}
+
+// ReceivePacketContext reads the next frame from the connection, giving up once the context is done if the connection supports read deadlines.
+func (t *ConnStream) ReceivePacketContext(ctx context.Context) (*notppackets.Packet, error) {
+	if t.closed.isClosed() {
+		return nil, ErrClosed
+	}
+	t.readMutex.Lock()
+	defer t.readMutex.Unlock()
+	if t.readErr != nil {
+		return nil, t.readErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, context.Cause(ctx)
+	}
+	if conn, ok := t.conn.(readDeadliner); ok {
+		stop, err := watchContext(ctx, conn.SetReadDeadline, t.getDeadline(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer stop()
+	}
+	var header [frameHeaderSize]byte
+	n, err := io.ReadFull(t.reader, header[:])
+	if err != nil {
+		if t.closed.isClosed() {
+			return nil, ErrClosed
+		}
+		ctxErr := getContextError(ctx)
+		switch {
+		case n == 0 && errors.Is(err, io.EOF):
+			t.readErr = io.EOF
+			return nil, t.readErr
+		case ctxErr != nil:
+			err = ctxErr
+		case isTimeout(err):
+			err = fmt.Errorf("%w waiting for packet: %w", ErrTimeout, err)
+		}
+		if n == 0 {
+			return nil, err
+		}
+		return nil, t.failRead(err)
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > t.maxFrameSize {
+		return nil, t.failRead(fmt.Errorf("%w: frame of %d bytes exceeds the maximum of %d bytes", ErrLimitExceeded, size, t.maxFrameSize))
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(t.reader, data); err != nil {
+		if t.closed.isClosed() {
+			return nil, ErrClosed
+		}
+		if ctxErr := getContextError(ctx); ctxErr != nil {
+			err = ctxErr
+		}
+		return nil, t.failRead(err)
+	}
+	return &notppackets.Packet{Data: data}, nil
+}
+
+// getDeadline returns the earliest between the timeout and the deadline of the context, or the zero time if there is none.
+func (t *ConnStream) getDeadline(ctx context.Context) time.Time {
+	var deadline time.Time
+	if t.timeout > 0 {
+		deadline = time.Now().Add(t.timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	return deadline
+}
+
+// getContextError returns the cause of the context being done, including its deadline being reached before the context notices it.
+func getContextError(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// watchContext sets the deadline and moves it to the past once the context is done to interrupt the pending call, returning the function to stop watching
+// which waits for a concurrent move to complete, so that it cannot leak into the next call.
+func watchContext(ctx context.Context, setDeadline func(time.Time) error, deadline time.Time) (func(), error) {
+	if err := setDeadline(deadline); err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(done)
+		_ = setDeadline(time.Now())
+	})
+	return func() {
+		if !stop() {
+			<-done
+		}
+	}, nil
+}
+
+// failRead marks the stream as broken, as a frame was partially read and the next frames cannot be located.
+func (t *ConnStream) failRead(err error) error {
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	t.readErr = fmt.Errorf("notp: broken connection stream: %w", err)
+	return t.readErr
+}
+
+// CloseWrite closes the connection for writing, so that the peer receives io.EOF once the transmitted frames are drained.
+// The connection must support the half-close, as the TCP and Unix connections do.
+func (t *ConnStream) CloseWrite() error {
+	conn, ok := t.conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.New("notp: connection does not support closing for writing")
+	}
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+	if errors.Is(t.writeErr, ErrClosed) {
+		return nil
+	}
+	t.writeErr = ErrClosed
+	return conn.CloseWrite()
+}
+
+// Close closes the connection, waking up the pending calls with ErrClosed.
+func (t *ConnStream) Close() error {
+	if !t.closed.close() {
+		return nil
+	}
+	return t.conn.Close()
+}
+
+// isTimeout returns true if the error is caused by an expired deadline.
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var timeoutErr interface{ Timeout() bool }
+	return errors.As(err, &timeoutErr) && timeoutErr.Timeout()
+}
+
+// NewConnStream creates and initializes a new stream over the connection, where a zero timeout disables the deadlines
+// and a zero maximum frame size uses the default one. Timeouts and cancellations need a connection supporting deadlines.
+func NewConnStream(conn io.ReadWriteCloser, timeout time.Duration, maxFrameSize uint32) (*ConnStream, error) {
+	if conn == nil {
+		return nil, errors.New("notp: connection cannot be nil")
+	}
+	if timeout < 0 {
+		return nil, fmt.Errorf("notp: invalid timeout %s", timeout)
+	}
+	if maxFrameSize == 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	return &ConnStream{
+		conn:         conn,
+		reader:       bufio.NewReader(conn),
+		timeout:      timeout,
+		maxFrameSize: maxFrameSize,
+		closed:       newCloseSignal(),
+	}, nil
+}
@@ -0,0 +1,257 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// buildConnStreams initializes and returns two connection streams connected through a TCP loopback connection.
+func buildConnStreams(assert *assert.Assertions, timeout time.Duration) (*ConnStream, *ConnStream) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(err)
+	serverConn := <-accepted
+	assert.NotNil(serverConn)
+	leaderStream, err := NewConnStream(serverConn, timeout, 0)
+	assert.Nil(err)
+	followerStream, err := NewConnStream(clientConn, timeout, 0)
+	assert.Nil(err)
+	return leaderStream, followerStream
+}
+
+// TestConnStreamTransportLayers tests the exchange of packets between two transport layers over connection streams.
+func TestConnStreamTransportLayers(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []TransportLayerOption
+	}{
+		{name: "LatestVersion"},
+		{name: "LegacyVersion", opts: []TransportLayerOption{WithProtocolVersions(notppackets.ProtocolVersion1, notppackets.ProtocolVersion1)}},
+		{name: "Compressed", opts: []TransportLayerOption{WithCompressionThreshold(1)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			leaderStream, followerStream := buildConnStreams(assert, time.Second)
+			defer leaderStream.Close()
+			defer followerStream.Close()
+
+			leader, err := NewTransportLayer(leaderStream.TransmitPacket, leaderStream.ReceivePacket, nil, test.opts...)
+			assert.Nil(err)
+			follower, err := NewTransportLayer(followerStream.TransmitPacket, followerStream.ReceivePacket, nil, test.opts...)
+			assert.Nil(err)
+
+			request := []notppackets.Packetable{&notppackets.Packet{Data: []byte("request")}}
+			assert.Nil(follower.TransmitPacket(request))
+			received, err := leader.ReceivePacket()
+			assert.Nil(err)
+			assert.Equal(request, received)
+
+			response := []notppackets.Packetable{
+				&notppackets.Packet{Data: make([]byte, 100_000)},
+				&notppackets.Packet{Data: []byte("response")},
+			}
+			assert.Nil(leader.TransmitPacket(response))
+			received, err = follower.ReceivePacket()
+			assert.Nil(err)
+			assert.Equal(response, received)
+		})
+	}
+}
+
+// TestConnStreamReassemblesFrames tests that frames split across several writes are reassembled.
+func TestConnStreamReassemblesFrames(t *testing.T) {
+	assert := assert.New(t)
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	stream, err := NewConnStream(local, time.Second, 0)
+	assert.Nil(err)
+
+	go func() {
+		frame := binary.BigEndian.AppendUint32(nil, 5)
+		frame = append(frame, []byte("hello")...)
+		frame = binary.BigEndian.AppendUint32(frame, 0)
+		for _, b := range frame {
+			_, _ = remote.Write([]byte{b})
+		}
+	}()
+	packet, err := stream.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal([]byte("hello"), packet.Data)
+	packet, err = stream.ReceivePacket()
+	assert.Nil(err)
+	assert.Empty(packet.Data)
+}
+
+// TestConnStreamTimeout tests that an expired read deadline is reported without breaking the stream.
+func TestConnStreamTimeout(t *testing.T) {
+	assert := assert.New(t)
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	stream, err := NewConnStream(local, 50*time.Millisecond, 0)
+	assert.Nil(err)
+	peer, err := NewConnStream(remote, time.Second, 0)
+	assert.Nil(err)
+
+	_, err = stream.ReceivePacket()
+	assert.NotNil(err)
+	assert.ErrorContains(err, "notp: timeout waiting for packet")
+
+	go func() { _ = peer.TransmitPacket(&notppackets.Packet{Data: []byte("late")}) }()
+	packet, err := stream.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal([]byte("late"), packet.Data)
+}
+
+// TestConnStreamContext tests that a cancelled context interrupts a pending read without breaking the stream.
+func TestConnStreamContext(t *testing.T) {
+	assert := assert.New(t)
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	stream, err := NewConnStream(local, 0, 0)
+	assert.Nil(err)
+	peer, err := NewConnStream(remote, 0, 0)
+	assert.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = stream.ReceivePacketContext(ctx)
+	assert.ErrorIs(err, context.Canceled)
+	assert.ErrorIs(stream.TransmitPacketContext(ctx, &notppackets.Packet{}), context.Canceled)
+
+	deadlineCtx, deadlineCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer deadlineCancel()
+	_, err = stream.ReceivePacketContext(deadlineCtx)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+
+	go func() { _ = peer.TransmitPacket(&notppackets.Packet{Data: []byte("next")}) }()
+	packet, err := stream.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal([]byte("next"), packet.Data)
+}
+
+// TestConnStreamClose tests the half-close and the close of connection streams under transport layers.
+func TestConnStreamClose(t *testing.T) {
+	assert := assert.New(t)
+	leaderStream, followerStream := buildConnStreams(assert, time.Second)
+	leader, err := NewStreamTransportLayer(leaderStream, nil)
+	assert.Nil(err)
+	follower, err := NewStreamTransportLayer(followerStream, nil)
+	assert.Nil(err)
+
+	request := []notppackets.Packetable{&notppackets.Packet{Data: []byte("request")}}
+	assert.Nil(follower.TransmitPacket(request))
+	assert.Nil(follower.CloseWrite())
+	assert.ErrorIs(follower.TransmitPacket(request), ErrClosed)
+	received, err := leader.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal(request, received)
+	_, err = leader.ReceivePacket()
+	assert.ErrorIs(err, io.EOF)
+
+	response := []notppackets.Packetable{&notppackets.Packet{Data: []byte("response")}}
+	assert.Nil(leader.TransmitPacket(response))
+	received, err = follower.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal(response, received)
+
+	time.AfterFunc(20*time.Millisecond, func() { _ = follower.Close() })
+	_, err = follower.ReceivePacket()
+	assert.ErrorIs(err, ErrClosed)
+	assert.Nil(follower.Close())
+	_, err = followerStream.ReceivePacket()
+	assert.ErrorIs(err, ErrClosed)
+	assert.Nil(leader.Close())
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	stream, err := NewConnStream(local, time.Second, 0)
+	assert.Nil(err)
+	assert.NotNil(stream.CloseWrite())
+	assert.Nil(stream.Close())
+	assert.ErrorIs(stream.TransmitPacket(&notppackets.Packet{}), ErrClosed)
+}
+
+// TestConnStreamInvalidFrames tests the errors raised by oversized and truncated frames.
+func TestConnStreamInvalidFrames(t *testing.T) {
+	tests := []struct {
+		name        string
+		frame       []byte
+		expectedErr error
+	}{
+		{name: "Oversized", frame: binary.BigEndian.AppendUint32(nil, 17), expectedErr: ErrLimitExceeded},
+		{name: "TruncatedHeader", frame: []byte{0, 0}, expectedErr: io.ErrUnexpectedEOF},
+		{name: "TruncatedPayload", frame: append(binary.BigEndian.AppendUint32(nil, 8), 1, 2), expectedErr: io.ErrUnexpectedEOF},
+		{name: "Empty", expectedErr: io.EOF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			local, remote := net.Pipe()
+			defer local.Close()
+			stream, err := NewConnStream(local, time.Second, 16)
+			assert.Nil(err)
+
+			go func() {
+				_, _ = remote.Write(test.frame)
+				_ = remote.Close()
+			}()
+			_, err = stream.ReceivePacket()
+			assert.True(errors.Is(err, test.expectedErr), "unexpected error %v", err)
+			_, err = stream.ReceivePacket()
+			assert.True(errors.Is(err, test.expectedErr), "unexpected error %v", err)
+		})
+	}
+}
+
+// TestConnStreamTransmitOversized tests that an oversized packet is rejected before being written.
+func TestConnStreamTransmitOversized(t *testing.T) {
+	assert := assert.New(t)
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	stream, err := NewConnStream(local, time.Second, 4)
+	assert.Nil(err)
+	assert.ErrorIs(stream.TransmitPacket(&notppackets.Packet{Data: []byte("hello")}), ErrLimitExceeded)
+	assert.NotNil(stream.TransmitPacket(nil))
+
+	_, err = NewConnStream(nil, time.Second, 0)
+	assert.NotNil(err)
+	_, err = NewConnStream(local, -time.Second, 0)
+	assert.NotNil(err)
+}
@@ -18,44 +18,108 @@
 package transport
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"time"
 
 	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
 )
 
+// DefaultInMemoryBufferSize represents the default number of packets an in-memory stream holds before blocking the sender.
+const DefaultInMemoryBufferSize = 10
+
 // InMemoryStream simulates an in-memory stream for packet transmission with a fixed timeout.
 type InMemoryStream struct {
-	packets  []notppackets.Packet
-	packetCh chan notppackets.Packet
-	timeout  time.Duration
+	packetCh    chan notppackets.Packet
+	timeout     time.Duration
+	writeClosed *closeSignal
+	closed      *closeSignal
 }
 
 // TransmitPacket appends a packet to the in-memory stream.
 func (t *InMemoryStream) TransmitPacket(packet *notppackets.Packet) error {
+	return t.TransmitPacketContext(context.Background(), packet)
+}
+
+// TransmitPacketContext appends a packet to the in-memory stream, giving up once the context is done.
+func (t *InMemoryStream) TransmitPacketContext(ctx context.Context, packet *notppackets.Packet) error {
 	if packet == nil {
 		return errors.New("notp: cannot transmit a nil packet")
 	}
-	t.packets = append(t.packets, *packet)
-	t.packetCh <- *packet
-	return nil
+	if t.writeClosed.isClosed() {
+		return ErrClosed
+	}
+	select {
+	case t.packetCh <- *packet:
+		return nil
+	case <-t.writeClosed.done():
+		return ErrClosed
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
 }
 
 // ReceivePacket retrieves the oldest packet from the in-memory stream, with a fixed timeout.
 func (t *InMemoryStream) ReceivePacket() (*notppackets.Packet, error) {
+	return t.ReceivePacketContext(context.Background())
+}
+
+// ReceivePacketContext retrieves the oldest packet from the in-memory stream, with a fixed timeout, giving up once the context is done.
+// Once the stream is closed for writing the remaining packets are drained before returning io.EOF.
+func (t *InMemoryStream) ReceivePacketContext(ctx context.Context) (*notppackets.Packet, error) {
+	if t.closed.isClosed() {
+		return nil, ErrClosed
+	}
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
 	select {
 	case packet := <-t.packetCh:
 		return &packet, nil
-	case <-time.After(t.timeout):
-		return nil, errors.New("notp: timeout waiting for packet")
+	case <-t.closed.done():
+		return nil, ErrClosed
+	case <-t.writeClosed.done():
+		if t.closed.isClosed() {
+			return nil, ErrClosed
+		}
+		select {
+		case packet := <-t.packetCh:
+			return &packet, nil
+		default:
+			return nil, io.EOF
+		}
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	case <-timer.C:
+		return nil, fmt.Errorf("%w waiting for packet", ErrTimeout)
 	}
 }
 
+// CloseWrite closes the in-memory stream for writing, the receivers drain the remaining packets and then get io.EOF.
+func (t *InMemoryStream) CloseWrite() error {
+	t.writeClosed.close()
+	return nil
+}
+
+// Close closes the in-memory stream, discarding the remaining packets and waking up the pending calls with ErrClosed.
+func (t *InMemoryStream) Close() error {
+	t.closed.close()
+	t.writeClosed.close()
+	return nil
+}
+
 // NewInMemoryStream creates and initializes a new in-memory stream with a fixed timeout.
 func NewInMemoryStream(timeout time.Duration) (*InMemoryStream, error) {
+	return newInMemoryStream(timeout, DefaultInMemoryBufferSize), nil
+}
+
+// newInMemoryStream creates and initializes a new in-memory stream with a fixed timeout and buffer size, where a zero buffer size makes every send wait for its receiver.
+func newInMemoryStream(timeout time.Duration, bufferSize int) *InMemoryStream {
 	return &InMemoryStream{
-		packets:  make([]notppackets.Packet, 0),
-		packetCh: make(chan notppackets.Packet, 10),
-		timeout:  timeout,
-	}, nil
+		packetCh:    make(chan notppackets.Packet, bufferSize),
+		timeout:     timeout,
+		writeClosed: newCloseSignal(),
+		closed:      newCloseSignal(),
+	}
 }
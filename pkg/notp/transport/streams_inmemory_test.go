@@ -0,0 +1,58 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// TestInMemoryStreamClose tests that closing the in-memory stream for writing drains the packets before io.EOF and that closing it wakes up the pending receivers.
+func TestInMemoryStreamClose(t *testing.T) {
+	assert := assert.New(t)
+	stream, err := NewInMemoryStream(time.Minute)
+	assert.Nil(err)
+
+	assert.Nil(stream.TransmitPacket(&notppackets.Packet{Data: []byte("first")}))
+	assert.Nil(stream.TransmitPacket(&notppackets.Packet{Data: []byte("second")}))
+	assert.Nil(stream.CloseWrite())
+	assert.Nil(stream.CloseWrite())
+	assert.ErrorIs(stream.TransmitPacket(&notppackets.Packet{}), ErrClosed)
+	for _, data := range []string{"first", "second"} {
+		packet, err := stream.ReceivePacket()
+		assert.Nil(err)
+		assert.Equal([]byte(data), packet.Data)
+	}
+	_, err = stream.ReceivePacket()
+	assert.ErrorIs(err, io.EOF)
+
+	stream, err = NewInMemoryStream(time.Minute)
+	assert.Nil(err)
+	time.AfterFunc(20*time.Millisecond, func() { _ = stream.Close() })
+	start := time.Now()
+	_, err = stream.ReceivePacket()
+	assert.ErrorIs(err, ErrClosed)
+	assert.Less(time.Since(start), time.Second)
+	assert.ErrorIs(stream.TransmitPacket(&notppackets.Packet{}), ErrClosed)
+	_, err = stream.ReceivePacket()
+	assert.ErrorIs(err, ErrClosed)
+}
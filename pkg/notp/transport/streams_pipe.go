@@ -0,0 +1,196 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transport implements the transport layer of the NOTP protocol.
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// DefaultInMemoryPipeTimeout represents the default time an endpoint of an in-memory pipe waits for a packet.
+const DefaultInMemoryPipeTimeout = 5 * time.Second
+
+// inMemoryPipeConfig holds the configuration of an in-memory pipe.
+type inMemoryPipeConfig struct {
+	timeout     time.Duration
+	bufferSize  int
+	historySize int
+}
+
+// InMemoryPipeOption defines a function to configure an in-memory pipe.
+type InMemoryPipeOption func(*inMemoryPipeConfig) error
+
+// WithPipeTimeout sets the time an endpoint waits for a packet.
+func WithPipeTimeout(timeout time.Duration) InMemoryPipeOption {
+	return func(c *inMemoryPipeConfig) error {
+		if timeout <= 0 {
+			return fmt.Errorf("notp: invalid pipe timeout %s", timeout)
+		}
+		c.timeout = timeout
+		return nil
+	}
+}
+
+// WithPipeBufferSize sets the number of packets each direction holds before blocking the sender.
+func WithPipeBufferSize(bufferSize int) InMemoryPipeOption {
+	return func(c *inMemoryPipeConfig) error {
+		if bufferSize < 0 {
+			return fmt.Errorf("notp: invalid pipe buffer size %d", bufferSize)
+		}
+		c.bufferSize = bufferSize
+		return nil
+	}
+}
+
+// WithPipeBlocking makes the pipe unbuffered, so that every send waits for the peer to receive the packet.
+func WithPipeBlocking() InMemoryPipeOption {
+	return WithPipeBufferSize(0)
+}
+
+// WithPipeHistory enables the history of the sent and received packets of each endpoint, keeping the latest ones up to the size.
+func WithPipeHistory(historySize int) InMemoryPipeOption {
+	return func(c *inMemoryPipeConfig) error {
+		if historySize <= 0 {
+			return fmt.Errorf("notp: invalid pipe history size %d", historySize)
+		}
+		c.historySize = historySize
+		return nil
+	}
+}
+
+// packetHistory holds the latest packets up to a size and is safe for concurrent use.
+type packetHistory struct {
+	size    int
+	packets []notppackets.Packet
+	mutex   sync.RWMutex
+}
+
+// record records a copy of the packet, dropping the oldest one once the history is full.
+func (h *packetHistory) record(packet *notppackets.Packet) {
+	if h.size == 0 {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if len(h.packets) == h.size {
+		h.packets = append(h.packets[:0], h.packets[1:]...)
+	}
+	h.packets = append(h.packets, notppackets.Packet{Data: bytes.Clone(packet.Data)})
+}
+
+// getPackets returns a copy of the recorded packets.
+func (h *packetHistory) getPackets() []notppackets.Packet {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	packets := make([]notppackets.Packet, len(h.packets))
+	for i, packet := range h.packets {
+		packets[i] = notppackets.Packet{Data: bytes.Clone(packet.Data)}
+	}
+	return packets
+}
+
+// InMemoryPipeEndpoint is one of the two connected endpoints of an in-memory pipe.
+type InMemoryPipeEndpoint struct {
+	incoming *InMemoryStream
+	outgoing *InMemoryStream
+	sent     *packetHistory
+	received *packetHistory
+}
+
+// TransmitPacket sends a packet to the peer endpoint.
+func (e *InMemoryPipeEndpoint) TransmitPacket(packet *notppackets.Packet) error {
+	return e.TransmitPacketContext(context.Background(), packet)
+}
+
+// TransmitPacketContext sends a packet to the peer endpoint, giving up once the context is done.
+func (e *InMemoryPipeEndpoint) TransmitPacketContext(ctx context.Context, packet *notppackets.Packet) error {
+	if err := e.outgoing.TransmitPacketContext(ctx, packet); err != nil {
+		return err
+	}
+	e.sent.record(packet)
+	return nil
+}
+
+// ReceivePacket retrieves the oldest packet sent by the peer endpoint, with a fixed timeout.
+func (e *InMemoryPipeEndpoint) ReceivePacket() (*notppackets.Packet, error) {
+	return e.ReceivePacketContext(context.Background())
+}
+
+// ReceivePacketContext retrieves the oldest packet sent by the peer endpoint, with a fixed timeout, giving up once the context is done.
+func (e *InMemoryPipeEndpoint) ReceivePacketContext(ctx context.Context) (*notppackets.Packet, error) {
+	packet, err := e.incoming.ReceivePacketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e.received.record(packet)
+	return packet, nil
+}
+
+// CloseWrite closes the endpoint for writing, the peer drains the remaining packets and then gets io.EOF.
+func (e *InMemoryPipeEndpoint) CloseWrite() error {
+	return e.outgoing.CloseWrite()
+}
+
+// Close closes the endpoint, so that the peer gets io.EOF on receive and ErrClosed on send.
+func (e *InMemoryPipeEndpoint) Close() error {
+	return errors.Join(e.outgoing.CloseWrite(), e.incoming.Close())
+}
+
+// GetSentPackets returns the latest packets sent by the endpoint, if the history is enabled.
+func (e *InMemoryPipeEndpoint) GetSentPackets() []notppackets.Packet {
+	return e.sent.getPackets()
+}
+
+// GetReceivedPackets returns the latest packets received by the endpoint, if the history is enabled.
+func (e *InMemoryPipeEndpoint) GetReceivedPackets() []notppackets.Packet {
+	return e.received.getPackets()
+}
+
+// NewInMemoryPipe creates and initializes two connected in-memory endpoints.
+func NewInMemoryPipe(opts ...InMemoryPipeOption) (*InMemoryPipeEndpoint, *InMemoryPipeEndpoint, error) {
+	config := &inMemoryPipeConfig{
+		timeout:    DefaultInMemoryPipeTimeout,
+		bufferSize: DefaultInMemoryBufferSize,
+	}
+	for _, opt := range opts {
+		if err := opt(config); err != nil {
+			return nil, nil, err
+		}
+	}
+	leftToRight := newInMemoryStream(config.timeout, config.bufferSize)
+	rightToLeft := newInMemoryStream(config.timeout, config.bufferSize)
+	left := &InMemoryPipeEndpoint{
+		incoming: rightToLeft,
+		outgoing: leftToRight,
+		sent:     &packetHistory{size: config.historySize},
+		received: &packetHistory{size: config.historySize},
+	}
+	right := &InMemoryPipeEndpoint{
+		incoming: leftToRight,
+		outgoing: rightToLeft,
+		sent:     &packetHistory{size: config.historySize},
+		received: &packetHistory{size: config.historySize},
+	}
+	return left, right, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// TestInMemoryPipe tests the exchange of packets between two transport layers over an in-memory pipe and the packet history.
+func TestInMemoryPipe(t *testing.T) {
+	assert := assert.New(t)
+	left, right, err := NewInMemoryPipe(WithPipeHistory(2))
+	assert.Nil(err)
+	leader, err := NewStreamTransportLayer(left, nil)
+	assert.Nil(err)
+	follower, err := NewStreamTransportLayer(right, nil)
+	assert.Nil(err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			_ = left.GetSentPackets()
+			_ = right.GetReceivedPackets()
+		}
+	}()
+	for _, data := range []string{"first", "second", "third"} {
+		packetables := []notppackets.Packetable{&notppackets.Packet{Data: []byte(data)}}
+		assert.Nil(leader.TransmitPacket(packetables))
+		received, err := follower.ReceivePacket()
+		assert.Nil(err)
+		assert.Equal(packetables, received)
+	}
+	wg.Wait()
+
+	sent := left.GetSentPackets()
+	assert.Len(sent, 2)
+	assert.Equal(sent, right.GetReceivedPackets())
+	assert.Empty(left.GetReceivedPackets())
+	assert.Empty(right.GetSentPackets())
+	sent[0].Data[0] = 0
+	assert.NotEqual(sent, left.GetSentPackets())
+
+	assert.Nil(follower.Close())
+	_, err = leader.ReceivePacket()
+	assert.ErrorIs(err, io.EOF)
+	assert.ErrorIs(leader.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{}}), ErrClosed)
+}
+
+// TestInMemoryPipeBlocking tests that a blocking pipe makes the sender wait for the receiver.
+func TestInMemoryPipeBlocking(t *testing.T) {
+	assert := assert.New(t)
+	left, right, err := NewInMemoryPipe(WithPipeBlocking(), WithPipeTimeout(time.Second))
+	assert.Nil(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(left.TransmitPacketContext(ctx, &notppackets.Packet{Data: []byte("lost")}), context.DeadlineExceeded)
+
+	received := make(chan *notppackets.Packet, 1)
+	go func() {
+		packet, _ := right.ReceivePacket()
+		received <- packet
+	}()
+	assert.Nil(left.TransmitPacket(&notppackets.Packet{Data: []byte("delivered")}))
+	assert.Equal([]byte("delivered"), (<-received).Data)
+
+	left, _, err = NewInMemoryPipe(WithPipeBufferSize(1))
+	assert.Nil(err)
+	assert.Nil(left.TransmitPacket(&notppackets.Packet{}))
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(left.TransmitPacketContext(ctx, &notppackets.Packet{}), context.DeadlineExceeded)
+
+	for _, opt := range []InMemoryPipeOption{WithPipeBufferSize(-1), WithPipeTimeout(0), WithPipeHistory(0)} {
+		_, _, err = NewInMemoryPipe(opt)
+		assert.NotNil(err)
+	}
+}
@@ -18,82 +18,187 @@
 package transport
 
 import (
+	"context"
 	"errors"
-	"io"
+	"fmt"
 	"time"
 
 	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
 )
 
+var (
+	// errWireSendTimeout is the cause of the contexts expiring while sending a packet.
+	errWireSendTimeout = fmt.Errorf("%w sending packet", ErrTimeout)
+	// errWireRecvTimeout is the cause of the contexts expiring while waiting for a packet.
+	errWireRecvTimeout = fmt.Errorf("%w waiting for packet", ErrTimeout)
+)
+
 // WireSendFunc wire send function.
 type WireSendFunc func(packet *notppackets.Packet) error
 
 // WireRecvFunc wire receive function.
 type WireRecvFunc func() (*notppackets.Packet, error)
 
+// WireContextSendFunc wire send function which stops once the context is done.
+type WireContextSendFunc func(ctx context.Context, packet *notppackets.Packet) error
+
+// WireContextRecvFunc wire receive function which stops once the context is done.
+type WireContextRecvFunc func(ctx context.Context) (*notppackets.Packet, error)
+
+// wireResult holds the outcome of a call to a wire function.
+type wireResult[T any] struct {
+	value T
+	err   error
+}
+
+// wireCaller runs a wire function which cannot be interrupted in the background, keeping at most one call running.
+type wireCaller[T any] struct {
+	slot    chan struct{}
+	pending chan wireResult[T]
+}
+
+// call runs the function and waits for its outcome until the context is done, in which case the call keeps running
+// and its outcome is handed to the next call if reusable, or discarded otherwise.
+func (c *wireCaller[T]) call(ctx context.Context, fn func() (T, error), reusable bool) (T, error) {
+	var zero T
+	select {
+	case c.slot <- struct{}{}:
+	case <-ctx.Done():
+		return zero, context.Cause(ctx)
+	}
+	defer func() { <-c.slot }()
+	if c.pending != nil && !reusable {
+		select {
+		case <-c.pending:
+			c.pending = nil
+		case <-ctx.Done():
+			return zero, context.Cause(ctx)
+		}
+	}
+	if c.pending == nil {
+		pending := make(chan wireResult[T], 1)
+		go func() {
+			value, err := fn()
+			pending <- wireResult[T]{value: value, err: err}
+		}()
+		c.pending = pending
+	}
+	select {
+	case result := <-c.pending:
+		c.pending = nil
+		return result.value, result.err
+	case <-ctx.Done():
+		return zero, context.Cause(ctx)
+	}
+}
+
+// newWireCaller creates and initializes a new wire caller.
+func newWireCaller[T any]() *wireCaller[T] {
+	return &wireCaller[T]{slot: make(chan struct{}, 1)}
+}
+
 // WireStream wire stream.
 type WireStream struct {
-	sender   WireSendFunc
-	receiver WireRecvFunc
-	timeout  time.Duration
+	sender      WireContextSendFunc
+	receiver    WireContextRecvFunc
+	timeout     time.Duration
+	writeClosed *closeSignal
+	closed      *closeSignal
 }
 
 // TransmitPacket appends a packet to the in-wire stream.
 func (t *WireStream) TransmitPacket(packet *notppackets.Packet) error {
-	errCh := make(chan error, 1)
-
-	go func() {
-		if err := t.sender(packet); err != nil {
-			errCh <- err
-			return
-		}
-		errCh <- nil
-	}()
+	return t.TransmitPacketContext(context.Background(), packet)
+}
 
-	select {
-	case err := <-errCh:
-		if err != nil {
-			return err
-		}
-		return nil
-	case <-time.After(t.timeout):
-		return errors.New("notp: timeout sending packet")
+// TransmitPacketContext appends a packet to the in-wire stream, with a fixed timeout, giving up once the context is done.
+func (t *WireStream) TransmitPacketContext(ctx context.Context, packet *notppackets.Packet) error {
+	if t.writeClosed.isClosed() {
+		return ErrClosed
+	}
+	ctx, unbind := t.writeClosed.bind(ctx)
+	defer unbind()
+	ctx, cancel := context.WithTimeoutCause(ctx, t.timeout, errWireSendTimeout)
+	defer cancel()
+	err := t.sender(ctx, packet)
+	if err != nil && ctx.Err() != nil {
+		return context.Cause(ctx)
 	}
+	return err
 }
 
 // ReceivePacket retrieves the oldest packet from the in-wire stream, with a fixed timeout.
 func (t *WireStream) ReceivePacket() (*notppackets.Packet, error) {
-	packetCh := make(chan *notppackets.Packet, 1)
-	errCh := make(chan error, 1)
-
-	go func() {
-		packet, err := t.receiver()
-		if err != nil {
-			if err == io.EOF {
-				errCh <- io.EOF
-			} else {
-				errCh <- err
-			}
-			return
-		}
-		packetCh <- packet
-	}()
+	return t.ReceivePacketContext(context.Background())
+}
 
-	select {
-	case packet := <-packetCh:
-		return packet, nil
-	case err := <-errCh:
-		return nil, err
-	case <-time.After(t.timeout):
-		return nil, errors.New("notp: timeout waiting for packet")
+// ReceivePacketContext retrieves the oldest packet from the in-wire stream, with a fixed timeout, giving up once the context is done.
+func (t *WireStream) ReceivePacketContext(ctx context.Context) (*notppackets.Packet, error) {
+	if t.closed.isClosed() {
+		return nil, ErrClosed
 	}
+	ctx, unbind := t.closed.bind(ctx)
+	defer unbind()
+	ctx, cancel := context.WithTimeoutCause(ctx, t.timeout, errWireRecvTimeout)
+	defer cancel()
+	packet, err := t.receiver(ctx)
+	if err != nil && ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	return packet, err
+}
+
+// CloseWrite closes the in-wire stream for writing, waking up the pending sends with ErrClosed.
+func (t *WireStream) CloseWrite() error {
+	t.writeClosed.close()
+	return nil
+}
+
+// Close closes the in-wire stream, waking up the pending calls with ErrClosed, while the wire itself is owned by the caller.
+func (t *WireStream) Close() error {
+	t.closed.close()
+	t.writeClosed.close()
+	return nil
 }
 
 // NewWireStream creates and initializes a new in-wire stream with a fixed timeout.
+// As the functions cannot be interrupted, at most one call per direction is kept running past the timeout: a late
+// packet is handed to the next receive instead of being lost and the next send waits for the previous one to complete.
 func NewWireStream(sender WireSendFunc, receiver WireRecvFunc, timeout time.Duration) (*WireStream, error) {
+	if sender == nil {
+		return nil, errors.New("notp: wire send function cannot be nil")
+	}
+	if receiver == nil {
+		return nil, errors.New("notp: wire receive function cannot be nil")
+	}
+	sendCaller := newWireCaller[struct{}]()
+	recvCaller := newWireCaller[*notppackets.Packet]()
+	return NewContextWireStream(
+		func(ctx context.Context, packet *notppackets.Packet) error {
+			_, err := sendCaller.call(ctx, func() (struct{}, error) { return struct{}{}, sender(packet) }, false)
+			return err
+		},
+		func(ctx context.Context) (*notppackets.Packet, error) {
+			return recvCaller.call(ctx, receiver, true)
+		},
+		timeout,
+	)
+}
+
+// NewContextWireStream creates and initializes a new in-wire stream with a fixed timeout, whose functions are called
+// directly and must return once the context is done.
+func NewContextWireStream(sender WireContextSendFunc, receiver WireContextRecvFunc, timeout time.Duration) (*WireStream, error) {
+	if sender == nil {
+		return nil, errors.New("notp: wire send function cannot be nil")
+	}
+	if receiver == nil {
+		return nil, errors.New("notp: wire receive function cannot be nil")
+	}
 	return &WireStream{
-		sender:   sender,
-		receiver: receiver,
-		timeout:  timeout,
+		sender:      sender,
+		receiver:    receiver,
+		timeout:     timeout,
+		writeClosed: newCloseSignal(),
+		closed:      newCloseSignal(),
 	}, nil
 }
@@ -0,0 +1,111 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// TestWireStreamKeepsLatePackets tests that a packet arriving after a timeout is handed to the next receive and that the timed out calls do not pile up goroutines.
+func TestWireStreamKeepsLatePackets(t *testing.T) {
+	assert := assert.New(t)
+	wire := make(chan *notppackets.Packet)
+	stream, err := NewWireStream(
+		func(packet *notppackets.Packet) error {
+			wire <- packet
+			return nil
+		},
+		func() (*notppackets.Packet, error) { return <-wire, nil },
+		20*time.Millisecond,
+	)
+	assert.Nil(err)
+
+	goroutines := runtime.NumGoroutine()
+	for range 5 {
+		_, err = stream.ReceivePacket()
+		assert.ErrorContains(err, "notp: timeout waiting for packet")
+	}
+	assert.LessOrEqual(runtime.NumGoroutine(), goroutines+1)
+
+	wire <- &notppackets.Packet{Data: []byte("late")}
+	packet, err := stream.ReceivePacket()
+	assert.Nil(err)
+	assert.Equal([]byte("late"), packet.Data)
+}
+
+// TestWireStreamContext tests that the context aware functions are interrupted once the context is done.
+func TestWireStreamContext(t *testing.T) {
+	assert := assert.New(t)
+	stream, err := NewContextWireStream(
+		func(ctx context.Context, packet *notppackets.Packet) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		func(ctx context.Context) (*notppackets.Packet, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		time.Minute,
+	)
+	assert.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = stream.ReceivePacketContext(ctx)
+	assert.ErrorIs(err, context.Canceled)
+	assert.ErrorIs(stream.TransmitPacketContext(ctx, &notppackets.Packet{}), context.Canceled)
+
+	stream, err = NewContextWireStream(stream.sender, stream.receiver, 20*time.Millisecond)
+	assert.Nil(err)
+	_, err = stream.ReceivePacket()
+	assert.ErrorContains(err, "notp: timeout waiting for packet")
+	assert.ErrorContains(stream.TransmitPacket(&notppackets.Packet{}), "notp: timeout sending packet")
+
+	_, err = NewWireStream(nil, nil, time.Second)
+	assert.NotNil(err)
+}
+
+// TestWireStreamClose tests that closing the in-wire stream wakes up a pending receive which cannot be interrupted.
+func TestWireStreamClose(t *testing.T) {
+	assert := assert.New(t)
+	wire := make(chan *notppackets.Packet)
+	stream, err := NewWireStream(
+		func(packet *notppackets.Packet) error {
+			wire <- packet
+			return nil
+		},
+		func() (*notppackets.Packet, error) { return <-wire, nil },
+		time.Minute,
+	)
+	assert.Nil(err)
+
+	time.AfterFunc(20*time.Millisecond, func() { _ = stream.Close() })
+	start := time.Now()
+	_, err = stream.ReceivePacket()
+	assert.ErrorIs(err, ErrClosed)
+	assert.Less(time.Since(start), time.Second)
+	assert.ErrorIs(stream.TransmitPacket(&notppackets.Packet{}), ErrClosed)
+	_, err = stream.ReceivePacket()
+	assert.ErrorIs(err, ErrClosed)
+}
@@ -18,75 +18,459 @@
 package transport
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
 
 	azdata "github.com/permguard/permguard-common/pkg/extensions/data"
 	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
 )
 
+// uncompressedPacketMarker is the first byte of the packets which are not compressed as a whole, as the protocol packet type starts with a zero byte which never starts a zlib stream.
+const uncompressedPacketMarker = 0x00
+
 // TransportLayer represents the transport layer responsible for packet transmission in the NOTP protocol.
 type TransportLayer struct {
-	inspector      *PacketInspector
-	packetSender   PacketSender
-	packetReceiver PacketReceiver
+	inspector       *PacketInspector
+	packetSender    ContextPacketSender
+	packetReceiver  ContextPacketReceiver
+	registry        *notppackets.PacketRegistry
+	codec           notppackets.PacketCodec
+	codecs          []notppackets.PacketCodec
+	checksumFlags   uint32
+	compressors     []Compressor
+	compressionMask uint32
+	threshold       int
+	limits          TransportLimits
+	limiter         *RateLimiter
+	metrics         Metrics
+	minVersion      uint32
+	maxVersion      uint32
+	protocolVersion uint32
+	peerMask        uint32
+	closeFunc       StreamCloseFunc
+	closeWriteFunc  StreamCloseFunc
+	writeClosed     *closeSignal
+	closed          *closeSignal
+	mutex           sync.RWMutex
+}
+
+// TransportLayerOption defines a function to configure the transport layer.
+type TransportLayerOption func(*TransportLayer) error
+
+// WithProtocolVersions sets the range of protocol versions supported by the transport layer.
+func WithProtocolVersions(minVersion, maxVersion uint32) TransportLayerOption {
+	return func(t *TransportLayer) error {
+		if minVersion < notppackets.ProtocolVersion1 || maxVersion > notppackets.LatestProtocolVersion || minVersion > maxVersion {
+			return fmt.Errorf("notp: invalid protocol version range %d-%d", minVersion, maxVersion)
+		}
+		t.minVersion = minVersion
+		t.maxVersion = maxVersion
+		return nil
+	}
+}
+
+// WithPacketRegistry sets the registry used to decode the received packets.
+func WithPacketRegistry(registry *notppackets.PacketRegistry) TransportLayerOption {
+	return func(t *TransportLayer) error {
+		if registry == nil {
+			return errors.New("notp: packet registry cannot be nil")
+		}
+		t.registry = registry
+		return nil
+	}
+}
+
+// WithPacketCodec sets the codec used to encode the payloads of the sent packets whenever the protocol version supports it.
+func WithPacketCodec(codec notppackets.PacketCodec) TransportLayerOption {
+	return func(t *TransportLayer) error {
+		if codec == nil {
+			return errors.New("notp: packet codec cannot be nil")
+		}
+		t.codec = codec
+		t.codecs = slices.DeleteFunc(t.codecs, func(c notppackets.PacketCodec) bool { return c.GetID() == codec.GetID() })
+		t.codecs = append(t.codecs, codec)
+		return nil
+	}
+}
+
+// WithStreamClosers sets the functions closing the underlying streams when the transport layer is closed as a whole or for writing.
+func WithStreamClosers(closeFunc, closeWriteFunc StreamCloseFunc) TransportLayerOption {
+	return func(t *TransportLayer) error {
+		t.closeFunc = closeFunc
+		t.closeWriteFunc = closeWriteFunc
+		return nil
+	}
+}
+
+// WithChecksums enables the data and packet checksums whenever the protocol version supports them.
+func WithChecksums() TransportLayerOption {
+	return func(t *TransportLayer) error {
+		t.checksumFlags = notppackets.DataChecksumFlag | notppackets.PacketChecksumFlag
+		return nil
+	}
+}
+
+// WithCompressors sets the compressors supported by the transport layer in order of preference.
+func WithCompressors(compressors ...Compressor) TransportLayerOption {
+	return func(t *TransportLayer) error {
+		ids := make([]uint32, 0, len(compressors))
+		for _, compressor := range compressors {
+			if compressor == nil {
+				return errors.New("notp: compressor cannot be nil")
+			}
+			id := compressor.GetID()
+			if slices.Contains(ids, id) {
+				return fmt.Errorf("notp: compressor %d is already defined", id)
+			}
+			ids = append(ids, id)
+		}
+		mask, err := notppackets.GetCompressionMask(ids...)
+		if err != nil {
+			return err
+		}
+		t.compressors = compressors
+		t.compressionMask = mask
+		return nil
+	}
+}
+
+// WithCompressionThreshold sets the size in bytes below which packets are sent uncompressed.
+func WithCompressionThreshold(threshold int) TransportLayerOption {
+	return func(t *TransportLayer) error {
+		if threshold < 0 {
+			return fmt.Errorf("notp: invalid compression threshold %d", threshold)
+		}
+		t.threshold = threshold
+		return nil
+	}
+}
+
+// GetPacketRegistry returns the registry used to decode the received packets.
+func (t *TransportLayer) GetPacketRegistry() *notppackets.PacketRegistry {
+	return t.registry
+}
+
+// GetProtocolVersion returns the protocol version used by the transport layer, which is the negotiated one once the peer has been heard.
+func (t *TransportLayer) GetProtocolVersion() uint32 {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	if t.protocolVersion == 0 {
+		return t.minVersion
+	}
+	return t.protocolVersion
+}
+
+// IsProtocolVersionNegotiated returns true if the protocol version has been negotiated with the peer.
+func (t *TransportLayer) IsProtocolVersionNegotiated() bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.protocolVersion != 0
+}
+
+// negotiateProtocolVersion selects the highest protocol version supported by both the transport layer and the peer.
+func (t *TransportLayer) negotiateProtocolVersion(protocol *notppackets.ProtocolPacket) error {
+	if protocol.Version < t.minVersion || protocol.Version > t.maxVersion {
+		return fmt.Errorf("notp: unsupported protocol version %d", protocol.Version)
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.protocolVersion != 0 {
+		return nil
+	}
+	peerMinVersion, peerMaxVersion := protocol.GetVersionRange()
+	version := min(t.maxVersion, peerMaxVersion)
+	if version < max(t.minVersion, peerMinVersion) {
+		return fmt.Errorf("notp: no common protocol version between %d-%d and %d-%d", t.minVersion, t.maxVersion, peerMinVersion, peerMaxVersion)
+	}
+	t.protocolVersion = version
+	return nil
+}
+
+// negotiateCompressions stores the compressions supported by the peer.
+func (t *TransportLayer) negotiateCompressions(protocol *notppackets.ProtocolPacket) {
+	if !protocol.HasLengthPrefixedFraming() {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.peerMask = protocol.Compressions
+}
+
+// selectCompressor returns the preferred compressor supported by the peer, or nil if the packets have to be sent uncompressed.
+func (t *TransportLayer) selectCompressor() Compressor {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	peerProtocol := &notppackets.ProtocolPacket{Compressions: t.peerMask}
+	for _, compressor := range t.compressors {
+		if compressor.GetID() != NoCompression && peerProtocol.SupportsCompression(compressor.GetID()) {
+			return compressor
+		}
+	}
+	return nil
+}
+
+// getCompressor returns the compressor with the input identifier.
+func (t *TransportLayer) getCompressor(id uint32) (Compressor, error) {
+	for _, compressor := range t.compressors {
+		if compressor.GetID() == id {
+			return compressor, nil
+		}
+	}
+	return nil, fmt.Errorf("notp: unsupported compression %d", id)
+}
+
+// getPacketCodec returns the codec with the input identifier.
+func (t *TransportLayer) getPacketCodec(id uint32) (notppackets.PacketCodec, error) {
+	for _, codec := range t.codecs {
+		if codec.GetID() == id {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("notp: unsupported codec %d", id)
+}
+
+// getSendingCodec returns the codec encoding the payloads of the packets sent with the protocol version.
+func (t *TransportLayer) getSendingCodec(version uint32) notppackets.PacketCodec {
+	if !(&notppackets.ProtocolPacket{Version: version}).HasLengthPrefixedFraming() {
+		return notppackets.NewBinaryCodec()
+	}
+	return t.codec
+}
+
+// EncodePacketables encodes the payloads of the packets as the transport layer sends them into unknown packets,
+// so that they can be measured before being transmitted without encoding them again.
+func (t *TransportLayer) EncodePacketables(packetables []notppackets.Packetable) ([]notppackets.Packetable, error) {
+	return encodePacketables(t.getSendingCodec(t.GetProtocolVersion()), packetables)
+}
+
+// encodePacketables encodes the payloads of the packets with the codec.
+func encodePacketables(codec notppackets.PacketCodec, packetables []notppackets.Packetable) ([]notppackets.Packetable, error) {
+	encodedPacketables := make([]notppackets.Packetable, 0, len(packetables))
+	for _, packetable := range packetables {
+		if packetable == nil {
+			return nil, errors.New("notp: cannot send a nil packet")
+		}
+		data, err := codec.Encode(packetable)
+		if err != nil {
+			return nil, err
+		}
+		encodedPacketables = append(encodedPacketables, &notppackets.UnknownPacket{
+			Packet:     notppackets.Packet{Data: data},
+			PacketType: packetable.GetType(),
+		})
+	}
+	return encodedPacketables, nil
+}
+
+// writePacket writes the protocol packet followed by the data packets.
+func writePacket(packet *notppackets.Packet, protocol *notppackets.ProtocolPacket, packetables []notppackets.Packetable) (*notppackets.PacketWriter, error) {
+	writer, err := notppackets.NewPacketWriter(packet)
+	if err != nil {
+		return nil, err
+	}
+	if err = writer.WriteProtocol(protocol); err != nil {
+		return nil, err
+	}
+	for _, packetable := range packetables {
+		err := writer.AppendDataPacket(packetable)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return writer, nil
+}
+
+// encodePacket writes the packet compressing it as required by the protocol version, and returns its size before compression.
+func (t *TransportLayer) encodePacket(protocol *notppackets.ProtocolPacket, packetables []notppackets.Packetable) (*notppackets.Packet, int, error) {
+	packet := &notppackets.Packet{}
+	writer, err := writePacket(packet, protocol, packetables)
+	if err != nil {
+		return nil, 0, err
+	}
+	rawSize := len(packet.Data)
+	if !protocol.HasLengthPrefixedFraming() {
+		packet.Data, err = azdata.CompressData(packet.Data)
+		if err != nil {
+			return nil, 0, err
+		}
+		return packet, rawSize, nil
+	}
+	compressor := t.selectCompressor()
+	if compressor == nil || len(packet.Data) < t.threshold {
+		return packet, rawSize, nil
+	}
+	protocol.Compression = compressor.GetID()
+	if err = writer.RewriteProtocol(protocol); err != nil {
+		return nil, 0, err
+	}
+	headerSize := writer.GetProtocolSize()
+	compressedData, err := compressor.Compress(packet.Data[headerSize:])
+	if err != nil {
+		return nil, 0, err
+	}
+	packet.Data = append(packet.Data[:headerSize:headerSize], compressedData...)
+	return packet, rawSize, nil
+}
+
+// decodePacket decompresses the packet as announced by its protocol packet within the limits.
+func (t *TransportLayer) decodePacket(packet *notppackets.Packet) error {
+	if err := t.limits.checkCompressedSize(len(packet.Data)); err != nil {
+		return err
+	}
+	if len(packet.Data) > 0 && packet.Data[0] != uncompressedPacketMarker {
+		decompressedData, err := decompressLegacyData(packet.Data, t.limits.MaxDecompressedSize)
+		if err != nil {
+			return err
+		}
+		packet.Data = decompressedData
+		return nil
+	}
+	reader, err := notppackets.NewPacketReader(packet)
+	if err != nil {
+		return err
+	}
+	protocol, headerSize, err := reader.ReadProtocolHeader()
+	if err != nil {
+		return err
+	}
+	if !protocol.IsCompressed() {
+		return t.limits.checkDecompressedSize(len(packet.Data))
+	}
+	compressor, err := t.getCompressor(protocol.Compression)
+	if err != nil {
+		return err
+	}
+	limit, err := t.limits.getDecompressionLimit(headerSize)
+	if err != nil {
+		return err
+	}
+	decompressedData, err := compressor.Decompress(packet.Data[headerSize:], limit)
+	if err != nil {
+		return err
+	}
+	packet.Data = append(packet.Data[:headerSize:headerSize], decompressedData...)
+	return nil
 }
 
 // TransmitPacket sends a packet through the transport layer.
 func (t *TransportLayer) TransmitPacket(packetables []notppackets.Packetable) error {
+	return t.TransmitPacketContext(context.Background(), packetables)
+}
+
+// TransmitPacketContext sends a packet through the transport layer, giving up once the context is done.
+func (t *TransportLayer) TransmitPacketContext(ctx context.Context, packetables []notppackets.Packetable) error {
 	if t.packetSender == nil {
 		return errors.New("notp: transport layer does not have a defined packet sender")
 	}
 	if len(packetables) == 0 {
 		return errors.New("notp: cannot send an empty packet")
 	}
-	packet := notppackets.Packet{}
-	writer, err := notppackets.NewPacketWriter(&packet)
+	if t.writeClosed.isClosed() {
+		return ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return context.Cause(ctx)
+	}
+	protocol := &notppackets.ProtocolPacket{
+		Version:    t.GetProtocolVersion(),
+		MinVersion: t.minVersion,
+		MaxVersion: t.maxVersion,
+	}
+	codec := t.getSendingCodec(protocol.Version)
+	if protocol.HasLengthPrefixedFraming() {
+		protocol.Flags |= t.checksumFlags
+		protocol.Compressions = t.compressionMask
+		protocol.Codec = codec.GetID()
+	}
+	packetables, err := encodePacketables(codec, packetables)
 	if err != nil {
 		return err
 	}
-	writer.WriteProtocol(&notppackets.ProtocolPacket{Version: 1})
-	for _, packetable := range packetables {
-		err := writer.AppendDataPacket(packetable)
-		if err != nil {
-			return err
-		}
-	}
-	compressedData, err := azdata.CompressData(packet.Data)
+	packet, rawSize, err := t.encodePacket(protocol, packetables)
 	if err != nil {
 		return err
 	}
-	packet.Data = compressedData
-	err = t.packetSender(&packet)
+	if err = t.waitRateLimiter(ctx, packet); err != nil {
+		return err
+	}
+	start := time.Now()
+	err = t.packetSender(ctx, packet)
 	if err != nil {
+		if t.metrics != nil {
+			if isTimeoutError(err) {
+				t.metrics.OnTimeout(SendDirection, err)
+			} else {
+				t.metrics.OnSendError(err)
+			}
+		}
 		return err
 	}
+	if t.metrics != nil {
+		t.metrics.OnSend(PacketEvent{RawSize: rawSize, WireSize: len(packet.Data), Duration: time.Since(start)})
+	}
 	if t.inspector != nil {
-		t.inspector.InspectSent(&packet)
+		t.inspector.InspectSent(packet)
 	}
 	return nil
 }
 
 // ReceivePacket retrieves a packet from the transport layer.
 func (t *TransportLayer) ReceivePacket() ([]notppackets.Packetable, error) {
+	return t.ReceivePacketContext(context.Background())
+}
+
+// ReceivePacketContext retrieves a packet from the transport layer, giving up once the context is done.
+func (t *TransportLayer) ReceivePacketContext(ctx context.Context) ([]notppackets.Packetable, error) {
 	if t.packetReceiver == nil {
 		return nil, errors.New("notp: transport layer does not have a defined packet receiver")
 	}
-	packet, err := t.packetReceiver()
+	if t.closed.isClosed() {
+		return nil, ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, context.Cause(ctx)
+	}
+	ctx, unbind := t.closed.bind(ctx)
+	defer unbind()
+	packet, err := t.packetReceiver(ctx)
 	if err != nil {
+		if t.closed.isClosed() {
+			return nil, ErrClosed
+		}
+		if t.metrics != nil && isTimeoutError(err) {
+			t.metrics.OnTimeout(ReceiveDirection, err)
+		}
 		return nil, err
 	}
 	if packet == nil {
 		return nil, errors.New("notp: received a nil packet")
 	}
-	decompressedData, err := azdata.DecompressData(packet.Data)
-	if err != nil {
+	wireSize := len(packet.Data)
+	start := time.Now()
+	packetables, err := t.readPacketables(packet)
+	if t.metrics != nil {
+		if err != nil {
+			t.metrics.OnDecodeError(err)
+		} else {
+			t.metrics.OnReceive(PacketEvent{RawSize: len(packet.Data), WireSize: wireSize, Duration: time.Since(start)})
+		}
+	}
+	return packetables, err
+}
+
+// readPacketables decodes the packetables of the received packet.
+func (t *TransportLayer) readPacketables(packet *notppackets.Packet) ([]notppackets.Packetable, error) {
+	if err := t.decodePacket(packet); err != nil {
 		return nil, err
 	}
-	packet.Data = decompressedData
 	if t.inspector != nil {
 		t.inspector.InspectReceived(packet)
 	}
-	reader, err := notppackets.NewPacketReader(packet)
+	reader, err := notppackets.NewPacketReaderWithLimits(packet, t.limits.getReaderLimits())
 	if err != nil {
 		return nil, err
 	}
@@ -94,8 +478,13 @@ func (t *TransportLayer) ReceivePacket() ([]notppackets.Packetable, error) {
 	if err != nil {
 		return nil, err
 	}
-	if protocol.Version != 1 {
-		return nil, errors.New("notp: unsupported protocol version")
+	if err = t.negotiateProtocolVersion(protocol); err != nil {
+		return nil, err
+	}
+	t.negotiateCompressions(protocol)
+	codec, err := t.getPacketCodec(protocol.Codec)
+	if err != nil {
+		return nil, err
 	}
 	packetables := []notppackets.Packetable{}
 	var state *notppackets.DataPacketState
@@ -103,10 +492,11 @@ func (t *TransportLayer) ReceivePacket() ([]notppackets.Packetable, error) {
 		var data []byte
 		data, state, err = reader.ReadNextDataPacket(state)
 		if err != nil {
-			return nil, err
+			return nil, wrapReaderError(err)
 		}
-		packetable := &notppackets.Packet{
-			Data: data,
+		packetable, err := t.decodePacketable(codec, state.GetPacketType(), data)
+		if err != nil {
+			return nil, err
 		}
 		packetables = append(packetables, packetable)
 		if state.IsComplete() {
@@ -116,17 +506,88 @@ func (t *TransportLayer) ReceivePacket() ([]notppackets.Packetable, error) {
 	return packetables, nil
 }
 
+// CloseWrite closes the transport layer for writing, so that the peer receives io.EOF, while the packets can still be received.
+func (t *TransportLayer) CloseWrite() error {
+	if !t.writeClosed.close() || t.closeWriteFunc == nil {
+		return nil
+	}
+	return t.closeWriteFunc()
+}
+
+// Close closes the transport layer and the underlying streams, waking up the pending receivers with ErrClosed.
+func (t *TransportLayer) Close() error {
+	t.writeClosed.close()
+	if !t.closed.close() || t.closeFunc == nil {
+		return nil
+	}
+	return t.closeFunc()
+}
+
+// decodePacketable decodes the data with the codec into the packet registered for the packet type.
+func (t *TransportLayer) decodePacketable(codec notppackets.PacketCodec, packetType uint64, data []byte) (notppackets.Packetable, error) {
+	packetable, err := t.registry.DecodeWithCodec(codec, packetType, data)
+	if errors.Is(err, notppackets.ErrUnknownPacketType) {
+		return &notppackets.UnknownPacket{
+			Packet:     notppackets.Packet{Data: data},
+			PacketType: packetType,
+		}, nil
+	}
+	return packetable, err
+}
+
 // NewTransportLayer creates and initializes a new transport layer.
-func NewTransportLayer(packetSender PacketSender, packetReceiver PacketReceiver, inspector *PacketInspector) (*TransportLayer, error) {
+func NewTransportLayer(packetSender PacketSender, packetReceiver PacketReceiver, inspector *PacketInspector, opts ...TransportLayerOption) (*TransportLayer, error) {
 	if packetSender == nil {
 		return nil, errors.New("notp: PacketSender cannot be nil")
 	}
 	if packetReceiver == nil {
 		return nil, errors.New("notp: PacketReceiver cannot be nil")
 	}
-	return &TransportLayer{
+	return newTransportLayer(withContextSender(packetSender), withContextReceiver(packetReceiver), inspector, opts...)
+}
+
+// NewContextTransportLayer creates and initializes a new transport layer whose sender and receiver stop once the context is done.
+func NewContextTransportLayer(packetSender ContextPacketSender, packetReceiver ContextPacketReceiver, inspector *PacketInspector, opts ...TransportLayerOption) (*TransportLayer, error) {
+	if packetSender == nil {
+		return nil, errors.New("notp: ContextPacketSender cannot be nil")
+	}
+	if packetReceiver == nil {
+		return nil, errors.New("notp: ContextPacketReceiver cannot be nil")
+	}
+	return newTransportLayer(packetSender, packetReceiver, inspector, opts...)
+}
+
+// NewStreamTransportLayer creates and initializes a new transport layer over the stream, which is closed along with the transport layer.
+func NewStreamTransportLayer(stream Stream, inspector *PacketInspector, opts ...TransportLayerOption) (*TransportLayer, error) {
+	if stream == nil {
+		return nil, errors.New("notp: stream cannot be nil")
+	}
+	opts = append([]TransportLayerOption{WithStreamClosers(stream.Close, stream.CloseWrite)}, opts...)
+	return newTransportLayer(stream.TransmitPacketContext, stream.ReceivePacketContext, inspector, opts...)
+}
+
+// newTransportLayer creates and initializes a new transport layer with the context aware sender and receiver.
+func newTransportLayer(packetSender ContextPacketSender, packetReceiver ContextPacketReceiver, inspector *PacketInspector, opts ...TransportLayerOption) (*TransportLayer, error) {
+	transportLayer := &TransportLayer{
 		inspector:      inspector,
 		packetSender:   packetSender,
 		packetReceiver: packetReceiver,
-	}, nil
+		registry:       notppackets.NewPacketRegistry(),
+		minVersion:     notppackets.ProtocolVersion1,
+		maxVersion:     notppackets.LatestProtocolVersion,
+		threshold:      DefaultCompressionThreshold,
+		codec:          notppackets.NewBinaryCodec(),
+		codecs:         []notppackets.PacketCodec{notppackets.NewBinaryCodec(), notppackets.NewJSONCodec()},
+		writeClosed:    newCloseSignal(),
+		closed:         newCloseSignal(),
+	}
+	if err := WithCompressors(defaultCompressors()...)(transportLayer); err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		if err := opt(transportLayer); err != nil {
+			return nil, err
+		}
+	}
+	return transportLayer, nil
 }
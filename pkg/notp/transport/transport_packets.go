@@ -18,6 +18,7 @@
 package transport
 
 import (
+	"context"
 	"errors"
 
 	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
@@ -32,6 +33,35 @@ type PacketSender func(packet *notppackets.Packet) error
 // PacketReceiver defines a function type for receiving packets from the transport layer.
 type PacketReceiver func() (*notppackets.Packet, error)
 
+// ContextPacketSender defines a function type for sending packets over the transport layer which stops once the context is done.
+type ContextPacketSender func(ctx context.Context, packet *notppackets.Packet) error
+
+// ContextPacketReceiver defines a function type for receiving packets from the transport layer which stops once the context is done.
+type ContextPacketReceiver func(ctx context.Context) (*notppackets.Packet, error)
+
+// StreamCloseFunc defines a function type for closing the streams underlying the transport layer.
+type StreamCloseFunc func() error
+
+// withContextSender adapts a packet sender which cannot be interrupted, checking the context before sending.
+func withContextSender(sender PacketSender) ContextPacketSender {
+	return func(ctx context.Context, packet *notppackets.Packet) error {
+		if err := ctx.Err(); err != nil {
+			return context.Cause(ctx)
+		}
+		return sender(packet)
+	}
+}
+
+// withContextReceiver adapts a packet receiver which cannot be interrupted, checking the context before receiving.
+func withContextReceiver(receiver PacketReceiver) ContextPacketReceiver {
+	return func(ctx context.Context) (*notppackets.Packet, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, context.Cause(ctx)
+		}
+		return receiver()
+	}
+}
+
 // PacketInspector provides functionality to inspect sent and received packets using the provided handlers.
 type PacketInspector struct {
 	sentPacketHandler     PacketHandler
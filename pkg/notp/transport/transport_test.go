@@ -0,0 +1,265 @@
+// Copyright 2024 Nitro Agility S.r.l.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	notppackets "github.com/permguard/permguard-notp-protocol/pkg/notp/packets"
+)
+
+// buildTransportLayers initializes and returns two transport layers connected through an in-memory pipe.
+func buildTransportLayers(assert *assert.Assertions, leaderOpts []TransportLayerOption, followerOpts []TransportLayerOption) (*TransportLayer, *TransportLayer) {
+	leaderEndpoint, followerEndpoint, err := NewInMemoryPipe(WithPipeTimeout(time.Second))
+	assert.Nil(err)
+	leader, err := NewStreamTransportLayer(leaderEndpoint, nil, leaderOpts...)
+	assert.Nil(err)
+	follower, err := NewStreamTransportLayer(followerEndpoint, nil, followerOpts...)
+	assert.Nil(err)
+	return leader, follower
+}
+
+// TestProtocolVersionNegotiation tests the negotiation of the protocol version between two transport layers.
+func TestProtocolVersionNegotiation(t *testing.T) {
+	tests := []struct {
+		name            string
+		leaderOpts      []TransportLayerOption
+		followerOpts    []TransportLayerOption
+		expectedVersion uint32
+	}{
+		{
+			name:            "LatestVersion",
+			expectedVersion: notppackets.LatestProtocolVersion,
+		},
+		{
+			name:            "LegacyLeader",
+			leaderOpts:      []TransportLayerOption{WithProtocolVersions(notppackets.ProtocolVersion1, notppackets.ProtocolVersion1)},
+			expectedVersion: notppackets.ProtocolVersion1,
+		},
+		{
+			name:            "LegacyFollower",
+			followerOpts:    []TransportLayerOption{WithProtocolVersions(notppackets.ProtocolVersion1, notppackets.ProtocolVersion1)},
+			expectedVersion: notppackets.ProtocolVersion1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			leader, follower := buildTransportLayers(assert, test.leaderOpts, test.followerOpts)
+			assert.False(follower.IsProtocolVersionNegotiated())
+
+			err := follower.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("request")}})
+			assert.Nil(err)
+			_, err = leader.ReceivePacket()
+			assert.Nil(err)
+			assert.True(leader.IsProtocolVersionNegotiated())
+			assert.Equal(test.expectedVersion, leader.GetProtocolVersion())
+
+			err = leader.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("response")}})
+			assert.Nil(err)
+			packetables, err := follower.ReceivePacket()
+			assert.Nil(err)
+			assert.Len(packetables, 1)
+			assert.True(follower.IsProtocolVersionNegotiated())
+			assert.Equal(test.expectedVersion, follower.GetProtocolVersion())
+		})
+	}
+}
+
+// TestProtocolVersionNegotiationFailure tests that transport layers without a common protocol version fail.
+func TestProtocolVersionNegotiationFailure(t *testing.T) {
+	assert := assert.New(t)
+	leader, follower := buildTransportLayers(assert,
+		[]TransportLayerOption{WithProtocolVersions(notppackets.ProtocolVersion2, notppackets.ProtocolVersion2)},
+		[]TransportLayerOption{WithProtocolVersions(notppackets.ProtocolVersion1, notppackets.ProtocolVersion1)})
+
+	err := follower.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("request")}})
+	assert.Nil(err)
+	_, err = leader.ReceivePacket()
+	assert.NotNil(err)
+	assert.False(leader.IsProtocolVersionNegotiated())
+
+	_, err = NewTransportLayer(func(*notppackets.Packet) error { return nil }, func() (*notppackets.Packet, error) { return nil, nil }, nil,
+		WithProtocolVersions(notppackets.ProtocolVersion2, notppackets.ProtocolVersion1))
+	assert.NotNil(err)
+}
+
+// TestReceivePacketWithRegistry tests that the received packets are decoded into the registered types.
+func TestReceivePacketWithRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	registry := notppackets.NewPacketRegistry()
+	err := registry.Register(func() notppackets.Packetable { return &notppackets.ProtocolPacket{} })
+	assert.Nil(err)
+	leader, follower := buildTransportLayers(assert, []TransportLayerOption{WithPacketRegistry(registry)}, nil)
+
+	unknownPacket := &notppackets.UnknownPacket{PacketType: notppackets.CombineUint32toUint64(100, 1)}
+	unknownPacket.Data = []byte("unknown")
+	inPacketables := []notppackets.Packetable{
+		&notppackets.ProtocolPacket{Version: 7},
+		&notppackets.Packet{Data: []byte("generic")},
+		unknownPacket,
+	}
+	err = follower.TransmitPacket(inPacketables)
+	assert.Nil(err)
+	outPacketables, err := leader.ReceivePacket()
+	assert.Nil(err)
+	assert.Len(outPacketables, 3)
+
+	protocol, ok := outPacketables[0].(*notppackets.ProtocolPacket)
+	assert.True(ok)
+	assert.Equal(uint32(7), protocol.Version)
+
+	packet, ok := outPacketables[1].(*notppackets.Packet)
+	assert.True(ok)
+	assert.Equal([]byte("generic"), packet.Data)
+
+	unknown, ok := outPacketables[2].(*notppackets.UnknownPacket)
+	assert.True(ok)
+	assert.Equal(unknownPacket.PacketType, unknown.GetType())
+	assert.Equal([]byte("unknown"), unknown.Data)
+}
+
+// TestTransmitPacketWithChecksums tests that checksums are only sent once the peer supports them.
+func TestTransmitPacketWithChecksums(t *testing.T) {
+	tests := []struct {
+		name         string
+		followerOpts []TransportLayerOption
+		hasChecksums bool
+	}{
+		{name: "LatestVersion", hasChecksums: true},
+		{name: "LegacyFollower", followerOpts: []TransportLayerOption{WithProtocolVersions(notppackets.ProtocolVersion1, notppackets.ProtocolVersion1)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var protocol *notppackets.ProtocolPacket
+			onReceived := func(packet *notppackets.Packet) {
+				reader, err := notppackets.NewPacketReader(packet)
+				assert.Nil(err)
+				protocol, err = reader.ReadProtocol()
+				assert.Nil(err)
+			}
+			inspector, err := NewPacketInspector(nil, onReceived)
+			assert.Nil(err)
+
+			leaderEndpoint, followerEndpoint, err := NewInMemoryPipe(WithPipeTimeout(time.Second))
+			assert.Nil(err)
+			leader, err := NewStreamTransportLayer(leaderEndpoint, nil, WithChecksums())
+			assert.Nil(err)
+			follower, err := NewStreamTransportLayer(followerEndpoint, inspector, append(test.followerOpts, WithChecksums())...)
+			assert.Nil(err)
+
+			assert.Nil(follower.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("request")}}))
+			_, err = leader.ReceivePacket()
+			assert.Nil(err)
+			assert.Nil(leader.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("response")}}))
+			packetables, err := follower.ReceivePacket()
+			assert.Nil(err)
+			assert.Len(packetables, 1)
+			assert.Equal(test.hasChecksums, protocol.HasDataChecksum())
+			assert.Equal(test.hasChecksums, protocol.HasPacketChecksum())
+		})
+	}
+}
+
+// TestTransmitPacketWithPacketCodec tests that the packets are encoded with the codec announced in the protocol packet.
+func TestTransmitPacketWithPacketCodec(t *testing.T) {
+	tests := []struct {
+		name          string
+		version       uint32
+		expectedCodec uint32
+	}{
+		{name: "JSONCodec", version: notppackets.ProtocolVersion2, expectedCodec: notppackets.JSONCodecID},
+		{name: "LegacyVersion", version: notppackets.ProtocolVersion1, expectedCodec: notppackets.BinaryCodecID},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var protocol *notppackets.ProtocolPacket
+			var payload []byte
+			onReceived := func(packet *notppackets.Packet) {
+				reader, err := notppackets.NewPacketReader(packet)
+				assert.Nil(err)
+				protocol, err = reader.ReadProtocol()
+				assert.Nil(err)
+				payload, _, err = reader.ReadNextDataPacket(nil)
+				assert.Nil(err)
+			}
+			inspector, err := NewPacketInspector(nil, onReceived)
+			assert.Nil(err)
+
+			registry := notppackets.NewPacketRegistry()
+			assert.Nil(registry.Register(func() notppackets.Packetable { return &notppackets.ProtocolPacket{} }))
+			leaderEndpoint, followerEndpoint, err := NewInMemoryPipe(WithPipeTimeout(time.Second))
+			assert.Nil(err)
+			leader, err := NewStreamTransportLayer(leaderEndpoint, nil,
+				WithProtocolVersions(test.version, test.version), WithPacketCodec(notppackets.NewJSONCodec()))
+			assert.Nil(err)
+			follower, err := NewStreamTransportLayer(followerEndpoint, inspector,
+				WithProtocolVersions(test.version, test.version), WithPacketRegistry(registry))
+			assert.Nil(err)
+
+			assert.Nil(follower.TransmitPacket([]notppackets.Packetable{&notppackets.Packet{Data: []byte("request")}}))
+			_, err = leader.ReceivePacket()
+			assert.Nil(err)
+			inPacketables := []notppackets.Packetable{
+				&notppackets.ProtocolPacket{Version: 7},
+				&notppackets.Packet{Data: []byte("response")},
+			}
+			assert.Nil(leader.TransmitPacket(inPacketables))
+			outPacketables, err := follower.ReceivePacket()
+			assert.Nil(err)
+			assert.Equal(inPacketables, outPacketables)
+			assert.Equal(test.expectedCodec, protocol.Codec)
+			if test.expectedCodec == notppackets.JSONCodecID {
+				assert.JSONEq(`{"Version": 7, "MinVersion": 0, "MaxVersion": 0, "Flags": 0, "Compression": 0, "Compressions": 0, "Codec": 0}`, string(payload))
+			}
+		})
+	}
+}
+
+// TestReceivePacketContext tests that a cancelled context interrupts the transport layer.
+func TestReceivePacketContext(t *testing.T) {
+	assert := assert.New(t)
+	stream, err := NewInMemoryStream(time.Minute)
+	assert.Nil(err)
+	transportLayer, err := NewContextTransportLayer(stream.TransmitPacketContext, stream.ReceivePacketContext, nil)
+	assert.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = transportLayer.ReceivePacketContext(ctx)
+	assert.ErrorIs(err, context.Canceled)
+	assert.Less(time.Since(start), time.Second)
+	assert.ErrorIs(transportLayer.TransmitPacketContext(ctx, []notppackets.Packetable{&notppackets.Packet{}}), context.Canceled)
+
+	assert.Nil(transportLayer.TransmitPacketContext(context.Background(), []notppackets.Packetable{&notppackets.Packet{Data: []byte("data")}}))
+	packetables, err := transportLayer.ReceivePacketContext(context.Background())
+	assert.Nil(err)
+	assert.Equal([]notppackets.Packetable{&notppackets.Packet{Data: []byte("data")}}, packetables)
+
+	_, err = NewContextTransportLayer(nil, stream.ReceivePacketContext, nil)
+	assert.NotNil(err)
+}